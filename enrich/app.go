@@ -1,14 +1,20 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
 
 	"github.com/meroxa/turbine-go"
 	"github.com/meroxa/turbine-go/runner"
+
+	"meroxa/turbine-go-examples/enrich/cache"
 )
 
 func main() {
+	loadUserCache()
 	runner.Start(App{})
+	closeUserCache()
 }
 
 var _ turbine.App = (*App)(nil)
@@ -26,13 +32,30 @@ func (a App) Run(v turbine.Turbine) error {
 		return err
 	}
 
-	err = v.RegisterSecret("CLEARBIT_API_KEY") // makes env var available to data app
+	enricher, err := newEnricher(v)
+	if err != nil {
+		return err
+	}
+	pending, err := startWebhookReceiver()
 	if err != nil {
 		return err
 	}
-	res := v.Process(rr, EnrichUserData{})
+	res := v.Process(rr, EnrichUserData{
+		Enricher: enricher,
+		Pending:  pending,
+	})
 
-	err = db.Write(res, "user_activity_enriched")
+	// records that couldn't be enriched go to a dead-letter collection, to be
+	// replayed once Clearbit is back
+	enriched := v.Process(res, Enriched{})
+	failed := v.Process(res, EnrichmentFailed{})
+
+	err = db.Write(enriched, "user_activity_enriched")
+	if err != nil {
+		return err
+	}
+
+	err = db.Write(failed, "user_activity_enrichment_dlq")
 	if err != nil {
 		return err
 	}
@@ -40,44 +63,128 @@ func (a App) Run(v turbine.Turbine) error {
 	return nil
 }
 
-type EnrichUserData struct{}
+// EnrichmentErrorField is the payload field EnrichUserData sets on records it
+// couldn't enrich, holding the error.
+const EnrichmentErrorField = "enrichment_error"
+
+// EnrichUserData adds user details from Enricher to each record. Records that
+// can't be enriched, for instance while Clearbit is down, are passed on
+// unenriched with the error in EnrichmentErrorField, so they can be routed to
+// a dead-letter collection with Enriched and EnrichmentFailed. They are also
+// handed to DeadLetter along with the error that caused it; when DeadLetter is
+// nil they are logged.
+//
+// Records whose lookup is still pending upstream are parked in Pending, if set,
+// and Process waits for their results before returning; see PendingLookups.
+type EnrichUserData struct {
+	Enricher   Enricher
+	Pending    *PendingLookups
+	DeadLetter func(turbine.RecordWithError)
+}
 
 func (f EnrichUserData) Process(rr []turbine.Record) []turbine.Record {
-	for i, r := range rr {
-		log.Printf("Got email: %s", r.Payload.Get("email"))
-		UserDetails, err := EnrichUserEmail(r.Payload.Get("email").(string))
-		if err != nil {
-			log.Println("error enriching user data: ", err)
-			break
-		}
-		log.Printf("Got UserDetails: %+v", UserDetails)
-		err = r.Payload.Set("full_name", UserDetails.FullName)
-		if err != nil {
-			log.Println("error setting full_name value: ", err)
-			break
-		}
-		err = r.Payload.Set("company", UserDetails.Company)
-		if err != nil {
-			log.Println("error setting company value: ", err)
-			break
+	var batch Batch
+	if f.Pending != nil {
+		batch = f.Pending.Batch()
+	}
+
+	var out []turbine.Record
+	for _, r := range rr {
+		err := enrichRecord(f.Enricher, &r)
+		if errors.Is(err, ErrLookupPending) && f.Pending != nil {
+			f.Pending.Park(batch, r.Payload.Get("email").(string), r)
+			continue
 		}
-		err = r.Payload.Set("location", UserDetails.Location)
 		if err != nil {
-			log.Println("error setting location value: ", err)
-			break
+			r = f.fail(r, err)
 		}
-		err = r.Payload.Set("role", UserDetails.Role)
-		if err != nil {
-			log.Println("error setting role value: ", err)
-			break
+		out = append(out, r)
+	}
+
+	if f.Pending != nil {
+		ready, failed := f.Pending.Await(batch, func(email string) (*UserDetails, error) {
+			return LookupUserDetails(f.Enricher, email)
+		})
+		out = append(out, ready...)
+		for _, r := range failed {
+			out = append(out, f.fail(r.Record, r.Error))
 		}
-		err = r.Payload.Set("seniority", UserDetails.Seniority)
-		if err != nil {
-			log.Println("error setting seniority value: ", err)
-			break
+	}
+
+	return out
+}
+
+// fail hands r to DeadLetter and returns it with err in EnrichmentErrorField.
+func (f EnrichUserData) fail(r turbine.Record, err error) turbine.Record {
+	if f.DeadLetter != nil {
+		f.DeadLetter(turbine.RecordWithError{Error: err, Record: r})
+	} else {
+		log.Printf("error enriching record %s: %s", r.Key, err)
+	}
+
+	p := r.Payload
+	if err := setPayloadField(&p, EnrichmentErrorField, err.Error(), "string"); err != nil {
+		log.Printf("error marking record %s: %s", r.Key, err)
+		return r
+	}
+	r.Payload = p
+	return r
+}
+
+// Enriched keeps the records EnrichUserData enriched, or found no data for.
+type Enriched struct{}
+
+func (f Enriched) Process(rr []turbine.Record) []turbine.Record {
+	return filterEnrichmentErrors(rr, false)
+}
+
+// EnrichmentFailed keeps the records EnrichUserData couldn't enrich, with the
+// error in EnrichmentErrorField.
+type EnrichmentFailed struct{}
+
+func (f EnrichmentFailed) Process(rr []turbine.Record) []turbine.Record {
+	return filterEnrichmentErrors(rr, true)
+}
+
+// filterEnrichmentErrors returns a new slice, since the same records are
+// routed by both Enriched and EnrichmentFailed.
+func filterEnrichmentErrors(rr []turbine.Record, failed bool) []turbine.Record {
+	var out []turbine.Record
+	for _, r := range rr {
+		if (r.Payload.Get(EnrichmentErrorField) != nil) == failed {
+			out = append(out, r)
 		}
-		rr[i] = r
 	}
+	return out
+}
 
-	return rr
+func enrichRecord(e Enricher, r *turbine.Record) error {
+	email, ok := r.Payload.Get("email").(string)
+	if !ok {
+		return errors.New("missing email")
+	}
+	log.Printf("Got email: %s", email)
+
+	UserDetails, err := LookupUserDetails(e, email)
+	if errors.Is(err, cache.ErrNotFound) {
+		log.Printf("no user data found for %s", email)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error enriching user data: %w", err)
+	}
+	log.Printf("Got UserDetails: %+v", UserDetails)
+
+	return setUserDetails(r, UserDetails)
+}
+
+// setUserDetails applies UserDetails to r, leaving r untouched on error.
+func setUserDetails(r *turbine.Record, UserDetails *UserDetails) error {
+	p := r.Payload
+	err := applyMapped(&p, UserDetails)
+	if err != nil {
+		return err
+	}
+	r.Payload = p
+	return nil
 }
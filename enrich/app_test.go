@@ -1,7 +1,19 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"testing"
+	"time"
+
+	"github.com/meroxa/turbine-go"
+
+	"meroxa/turbine-go-examples/enrich/cache"
+	"meroxa/turbine-go-examples/enrich/resilience"
 )
 
 func TestApp_Run(t *testing.T) {
@@ -9,3 +21,210 @@ func TestApp_Run(t *testing.T) {
 
 func TestAnonymize_Process(t *testing.T) {
 }
+
+func TestEnrichUserData_Process(t *testing.T) {
+	calls := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/combined/find" {
+			t.Errorf("unexpected request path %s", r.URL.Path)
+		}
+		email := r.URL.Query().Get("email")
+		calls[email]++
+
+		switch email {
+		case "user8@example.com":
+			// fail once to exercise retries
+			if calls[email] == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprint(w, `{"person":{"name":{"fullName":"User Eight"},"location":"London, UK","employment":{"role":"engineering","seniority":"senior"}},"company":{"name":"Example"}}`)
+		case "nobody@example.com":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"type":"unknown_record","message":"Unknown email address"}}`)
+		default:
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprint(w, `{"error":{"type":"email_invalid","message":"Invalid email"}}`)
+		}
+	}))
+	defer srv.Close()
+	enricher := setupClearbitTest(srv.URL)
+
+	var dlq []turbine.RecordWithError
+	out := EnrichUserData{
+		Enricher:   enricher,
+		DeadLetter: func(r turbine.RecordWithError) { dlq = append(dlq, r) },
+	}.Process([]turbine.Record{
+		testRecord("1", "user8@example.com"),
+		testRecord("2", "nobody@example.com"),
+		testRecord("3", "invalid"),
+		testRecord("4", "user8@example.com"),
+	})
+
+	if len(out) != 4 {
+		t.Fatalf("want 4 records, got %d", len(out))
+	}
+	if got := out[0].Payload.Get("full_name"); got != "User Eight" {
+		t.Fatalf("want full_name to be User Eight, got %v", got)
+	}
+	if got := out[1].Payload.Get("full_name"); got != nil {
+		t.Fatalf("want no full_name for unknown user, got %v", got)
+	}
+	if out[2].Key != "3" || out[2].Payload.Get("full_name") != nil {
+		t.Fatalf("want record 3 to be passed on unenriched, got %s", out[2].Payload)
+	}
+	if got := out[3].Payload.Get("company"); got != "Example" {
+		t.Fatalf("want company to be Example, got %v", got)
+	}
+	if calls["user8@example.com"] != 2 {
+		t.Fatalf("want 2 calls for user8 (one retry, then cached), got %d", calls["user8@example.com"])
+	}
+
+	if len(dlq) != 1 || dlq[0].Key != "3" {
+		t.Fatalf("want record 3 in dead letters, got %+v", dlq)
+	}
+	var httpErr *resilience.HTTPError
+	if !errors.As(dlq[0].Error, &httpErr) || httpErr.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("want 422 error for record 3, got %v", dlq[0].Error)
+	}
+}
+
+func TestEnrichUserData_ProcessCircuitOpen(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	enricher := setupClearbitTest(srv.URL)
+
+	var rr []turbine.Record
+	for i := 0; i < clearbitBreakerThreshold+2; i++ {
+		rr = append(rr, testRecord(fmt.Sprint(i), fmt.Sprintf("user%d@example.com", i)))
+	}
+
+	var dlq []turbine.RecordWithError
+	out := EnrichUserData{
+		Enricher:   enricher,
+		DeadLetter: func(r turbine.RecordWithError) { dlq = append(dlq, r) },
+	}.Process(rr)
+
+	if len(out) != len(rr) || len(dlq) != len(rr) {
+		t.Fatalf("want all records passed on and in dead letters, got %d out and %d dead letters", len(out), len(dlq))
+	}
+	for i, r := range out {
+		if r.Key != rr[i].Key || r.Payload.Get("company") != nil {
+			t.Fatalf("want record %s to be passed on unenriched, got %s", rr[i].Key, r.Payload)
+		}
+		if got := r.Payload.Get(EnrichmentErrorField); got != dlq[i].Error.Error() {
+			t.Fatalf("want record %s to be marked with %q, got %v", rr[i].Key, dlq[i].Error, got)
+		}
+	}
+	if calls != clearbitBreakerThreshold*clearbitBackoff.Attempts {
+		t.Fatalf("want %d calls before the breaker opened, got %d", clearbitBreakerThreshold*clearbitBackoff.Attempts, calls)
+	}
+	for _, r := range dlq[clearbitBreakerThreshold:] {
+		if !errors.Is(r.Error, resilience.ErrCircuitOpen) {
+			t.Fatalf("want ErrCircuitOpen for record %s, got %v", r.Key, r.Error)
+		}
+	}
+}
+
+func TestEnrichUserData_ProcessFixtures(t *testing.T) {
+	userCache = cache.New[*UserDetails](userCacheSize, userCacheTTL, userCacheNegativeTTL)
+	enricher, err := NewFixtureEnricher("fixtures/clearbit.json")
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+
+	rr := readFixtureRecords(t, "fixtures/pg.json", "user_activity")
+	out := EnrichUserData{
+		Enricher: enricher,
+		DeadLetter: func(r turbine.RecordWithError) {
+			t.Errorf("want no dead letters, got %s: %s", r.Key, r.Error)
+		},
+	}.Process(rr)
+
+	if len(out) != 3 {
+		t.Fatalf("want 3 records, got %d", len(out))
+	}
+	for _, r := range out {
+		if got := r.Payload.Get("company"); got != "Meroxa" {
+			t.Fatalf("want company to be Meroxa, got %v", got)
+		}
+		if got := r.Payload.Get("seniority"); got != "executive" {
+			t.Fatalf("want seniority to be executive, got %v", got)
+		}
+	}
+	if stats := userCache.Stats(); stats.Hits != 2 || stats.Misses != 1 {
+		t.Fatalf("want 2 cache hits and 1 miss, got %s", stats)
+	}
+}
+
+func TestEnrichmentRouting(t *testing.T) {
+	failed := testRecord("1", "user1@example.com")
+	if err := setPayloadField(&failed.Payload, EnrichmentErrorField, "boom", "string"); err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	rr := []turbine.Record{testRecord("0", "user0@example.com"), failed, testRecord("2", "user2@example.com")}
+
+	enriched := Enriched{}.Process(rr)
+	dlq := EnrichmentFailed{}.Process(rr)
+
+	if len(enriched) != 2 || enriched[0].Key != "0" || enriched[1].Key != "2" {
+		t.Fatalf("want records 0 and 2 enriched, got %v", enriched)
+	}
+	if len(dlq) != 1 || dlq[0].Key != "1" {
+		t.Fatalf("want record 1 in dead letters, got %v", dlq)
+	}
+	if rr[1].Key != "1" {
+		t.Fatalf("want the input left untouched, got %v", rr)
+	}
+}
+
+func setupClearbitTest(url string) ClearbitEnricher {
+	userCache = cache.New[*UserDetails](userCacheSize, userCacheTTL, userCacheNegativeTTL)
+	clearbitBackoff = resilience.Backoff{Attempts: 2, Initial: time.Millisecond, Max: time.Millisecond, Multiplier: 1}
+	clearbitBreaker = resilience.NewCircuitBreaker(clearbitBreakerThreshold, clearbitBreakerCooldown)
+	return ClearbitEnricher{BaseURL: url}
+}
+
+// readFixtureRecords reads a collection from a fixtures file the same way the
+// local runner does.
+func readFixtureRecords(t *testing.T, path, collection string) []turbine.Record {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+
+	var fixtures map[string][]struct {
+		Key   string
+		Value map[string]interface{}
+	}
+	err = json.Unmarshal(b, &fixtures)
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+
+	var rr []turbine.Record
+	for _, f := range fixtures[collection] {
+		p, _ := json.Marshal(f.Value)
+		rr = append(rr, turbine.Record{Key: f.Key, Payload: p})
+	}
+	return rr
+}
+
+func testRecord(key, email string) turbine.Record {
+	b, _ := json.Marshal(map[string]interface{}{
+		"schema": map[string]interface{}{
+			"name": "user_activity",
+			"type": "struct",
+			"fields": []map[string]interface{}{
+				{"field": "id", "optional": false, "type": "int32"},
+				{"field": "email", "optional": true, "type": "string"},
+			},
+		},
+		"payload": map[string]interface{}{"id": key, "email": email},
+	})
+	return turbine.Record{Key: key, Payload: b}
+}
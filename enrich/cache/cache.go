@@ -0,0 +1,206 @@
+// Package cache provides an in-memory LRU cache with per-entry TTL for
+// lookup-style functions, such as calls to enrichment APIs, that can be saved
+// to a file between runs.
+package cache
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by lookup functions when the upstream service has no
+// data for a key. Lookups failing with ErrNotFound are cached as negative entries.
+var ErrNotFound = errors.New("not found")
+
+// Stats counts the outcome of every lookup made through a Cache.
+type Stats struct {
+	Hits         int
+	NegativeHits int
+	Misses       int
+	Evictions    int
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("hits=%d negative_hits=%d misses=%d evictions=%d",
+		s.Hits, s.NegativeHits, s.Misses, s.Evictions)
+}
+
+// Cache is an in-memory LRU cache with per-entry TTL for lookup-style functions.
+// Not-found results are kept with their own (usually shorter) TTL so that
+// repeated misses don't hit the upstream service either.
+type Cache[V any] struct {
+	mu          sync.Mutex
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	ll          *list.List
+	items       map[string]*list.Element
+	stats       Stats
+	now         func() time.Time
+}
+
+type cacheEntry[V any] struct {
+	Key     string    `json:"key"`
+	Value   V         `json:"value,omitempty"`
+	Found   bool      `json:"found"`
+	Expires time.Time `json:"expires"`
+}
+
+// New returns a Cache holding at most size entries. Found values expire
+// after ttl, not-found results after negativeTTL.
+func New[V any](size int, ttl, negativeTTL time.Duration) *Cache[V] {
+	return &Cache[V]{
+		size:        size,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		ll:          list.New(),
+		items:       make(map[string]*list.Element),
+		now:         time.Now,
+	}
+}
+
+// Lookup returns the cached value for key, calling fn on a miss and caching its
+// result. A cached not-found result is returned as ErrNotFound without calling fn.
+// Any other error from fn is returned as is and not cached.
+func (c *Cache[V]) Lookup(key string, fn func(string) (V, error)) (V, error) {
+	if v, found, ok := c.Get(key); ok {
+		if !found {
+			return v, ErrNotFound
+		}
+		return v, nil
+	}
+
+	v, err := fn(key)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		c.SetNotFound(key)
+	case err == nil:
+		c.Set(key, v)
+	}
+	return v, err
+}
+
+// Get returns the value cached for key. ok reports whether the key was cached
+// and found reports whether the cached entry is a positive one.
+func (c *Cache[V]) Get(key string) (v V, found bool, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		return v, false, false
+	}
+
+	e := el.Value.(*cacheEntry[V])
+	if !c.now().Before(e.Expires) {
+		c.removeElement(el)
+		c.stats.Misses++
+		return v, false, false
+	}
+
+	c.ll.MoveToFront(el)
+	if e.Found {
+		c.stats.Hits++
+	} else {
+		c.stats.NegativeHits++
+	}
+	return e.Value, e.Found, true
+}
+
+// Set caches v for key.
+func (c *Cache[V]) Set(key string, v V) {
+	c.add(&cacheEntry[V]{Key: key, Value: v, Found: true, Expires: c.now().Add(c.ttl)})
+}
+
+// SetNotFound caches a not-found result for key.
+func (c *Cache[V]) SetNotFound(key string) {
+	c.add(&cacheEntry[V]{Key: key, Expires: c.now().Add(c.negativeTTL)})
+}
+
+// Stats returns a snapshot of the cache statistics.
+func (c *Cache[V]) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Load reads entries previously written by Save. Expired entries are skipped
+// and a missing file is not an error.
+func (c *Cache[V]) Load(path string) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var entries []*cacheEntry[V]
+	err = json.Unmarshal(b, &entries)
+	if err != nil {
+		return fmt.Errorf("unable to read cache file %s: %w", path, err)
+	}
+
+	// entries are saved most recently used first, add them back oldest first
+	now := c.now()
+	for i := len(entries) - 1; i >= 0; i-- {
+		if now.Before(entries[i].Expires) {
+			c.add(entries[i])
+		}
+	}
+	return nil
+}
+
+// Save writes all unexpired entries to path, most recently used first.
+func (c *Cache[V]) Save(path string) error {
+	c.mu.Lock()
+	now := c.now()
+	var entries []*cacheEntry[V]
+	for el := c.ll.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*cacheEntry[V])
+		if now.Before(e.Expires) {
+			entries = append(entries, e)
+		}
+	}
+	c.mu.Unlock()
+
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, b, 0o600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (c *Cache[V]) add(e *cacheEntry[V]) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[e.Key]; ok {
+		el.Value = e
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	c.items[e.Key] = c.ll.PushFront(e)
+	for c.size > 0 && c.ll.Len() > c.size {
+		c.removeElement(c.ll.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *Cache[V]) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*cacheEntry[V]).Key)
+}
@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCache_Lookup(t *testing.T) {
+	c := New[string](10, time.Minute, time.Second)
+	calls := 0
+	fn := func(key string) (string, error) {
+		calls++
+		if key == "missing" {
+			return "", ErrNotFound
+		}
+		return "value-" + key, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		v, err := c.Lookup("a", fn)
+		if err != nil {
+			t.Fatalf("want no error, got %s", err)
+		}
+		if v != "value-a" {
+			t.Fatalf("want value-a, got %s", v)
+		}
+		_, err = c.Lookup("missing", fn)
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("want ErrNotFound, got %v", err)
+		}
+	}
+
+	if calls != 2 {
+		t.Fatalf("want 2 upstream calls, got %d", calls)
+	}
+	want := Stats{Hits: 2, NegativeHits: 2, Misses: 2}
+	if got := c.Stats(); got != want {
+		t.Fatalf("want stats %+v, got %+v", want, got)
+	}
+}
+
+func TestCache_LookupErrorNotCached(t *testing.T) {
+	c := New[string](10, time.Minute, time.Minute)
+	calls := 0
+	fn := func(string) (string, error) {
+		calls++
+		return "", errors.New("boom")
+	}
+
+	_, _ = c.Lookup("a", fn)
+	_, _ = c.Lookup("a", fn)
+	if calls != 2 {
+		t.Fatalf("want 2 upstream calls, got %d", calls)
+	}
+}
+
+func TestCache_Expiry(t *testing.T) {
+	now := time.Now()
+	c := New[string](10, time.Minute, time.Second)
+	c.now = func() time.Time { return now }
+
+	c.Set("a", "1")
+	c.SetNotFound("b")
+
+	now = now.Add(2 * time.Second)
+	if _, _, ok := c.Get("a"); !ok {
+		t.Fatal("want a to be cached")
+	}
+	if _, _, ok := c.Get("b"); ok {
+		t.Fatal("want negative entry b to have expired")
+	}
+
+	now = now.Add(time.Minute)
+	if _, _, ok := c.Get("a"); ok {
+		t.Fatal("want a to have expired")
+	}
+}
+
+func TestCache_Eviction(t *testing.T) {
+	c := New[string](2, time.Minute, time.Minute)
+	c.Set("a", "1")
+	c.Set("b", "2")
+	c.Get("a")
+	c.Set("c", "3")
+
+	if _, _, ok := c.Get("b"); ok {
+		t.Fatal("want least recently used entry b to be evicted")
+	}
+	if _, _, ok := c.Get("a"); !ok {
+		t.Fatal("want a to be cached")
+	}
+	if got := c.Stats().Evictions; got != 1 {
+		t.Fatalf("want 1 eviction, got %d", got)
+	}
+}
+
+type user struct {
+	FullName string `json:"full_name"`
+}
+
+func TestCache_SaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+
+	c := New[*user](10, time.Minute, time.Minute)
+	c.Set("user8@example.com", &user{FullName: "User Eight"})
+	c.SetNotFound("nobody@example.com")
+	if err := c.Save(path); err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+
+	loaded := New[*user](10, time.Minute, time.Minute)
+	if err := loaded.Load(path); err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+
+	v, found, ok := loaded.Get("user8@example.com")
+	if !ok || !found || v.FullName != "User Eight" {
+		t.Fatalf("want cached user details, got %+v (found=%t, ok=%t)", v, found, ok)
+	}
+	if _, found, ok := loaded.Get("nobody@example.com"); !ok || found {
+		t.Fatalf("want cached not-found entry, got found=%t, ok=%t", found, ok)
+	}
+}
+
+func TestCache_LoadMissingFile(t *testing.T) {
+	c := New[string](10, time.Minute, time.Minute)
+	if err := c.Load(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+}
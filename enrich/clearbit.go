@@ -1,41 +1,115 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"github.com/clearbit/clearbit-go/clearbit"
 	"log"
-	"os"
+	"net/http"
+	"time"
+
+	"meroxa/turbine-go-examples/enrich/cache"
+	"meroxa/turbine-go-examples/enrich/resilience"
+)
+
+const (
+	clearbitBreakerThreshold = 5
+	clearbitBreakerCooldown  = 30 * time.Second
 )
 
-type UserDetails struct {
-	FullName        string
-	Location        string
-	Role            string
-	Seniority       string
-	Company         string
-	GithubUser      string
-	GithubFollowers int
+var (
+	clearbitBackoff = resilience.DefaultBackoff
+	clearbitBreaker = resilience.NewCircuitBreaker(clearbitBreakerThreshold, clearbitBreakerCooldown)
+)
+
+// ClearbitEnricher looks up users with Clearbit's combined Person and Company
+// API. Transient failures are retried with backoff and repeated failures trip
+// clearbitBreaker.
+//
+// Clearbit may queue a lookup and answer with 202 Accepted; Enrich then returns
+// ErrLookupPending and the lookup is repeated until it succeeds. If WebhookURL
+// is set the result is also posted there once ready, tagged with the email
+// address as its webhook ID.
+type ClearbitEnricher struct {
+	APIKey     string
+	BaseURL    string // Person API base URL, defaults to https://person.clearbit.com
+	WebhookURL string
 }
 
-func EnrichUserEmail(email string) (*UserDetails, error) {
-	key := os.Getenv("CLEARBIT_API_KEY")
-	client := clearbit.NewClient(clearbit.WithAPIKey(key))
-	results, resp, err := client.Person.FindCombined(clearbit.PersonFindParams{
-		Email: email,
+var _ Enricher = ClearbitEnricher{}
+
+func (e ClearbitEnricher) Enrich(email string) (*UserDetails, error) {
+	opts := []clearbit.Option{clearbit.WithAPIKey(e.APIKey)}
+	if e.BaseURL != "" {
+		opts = append(opts, clearbit.WithBaseURLs(map[string]string{"Person": e.BaseURL}))
+	}
+	if e.WebhookURL != "" {
+		opts = append(opts, clearbit.WithHTTPClient(&http.Client{
+			Transport: webhookTransport{base: http.DefaultTransport, url: e.WebhookURL},
+		}))
+	}
+	client := clearbit.NewClient(opts...)
+
+	var results *clearbit.PersonCompany
+	err := clearbitBreaker.Do(func() error {
+		return resilience.Retry(clearbitBackoff, func() error {
+			var resp *http.Response
+			var err error
+			results, resp, err = client.Person.FindCombined(clearbit.PersonFindParams{
+				Email: email,
+			})
+			if resp != nil && resp.StatusCode == http.StatusAccepted {
+				return resilience.Permanent(ErrLookupPending)
+			}
+			return resilience.CheckResponse(resp, err)
+		})
 	})
 
+	var httpErr *resilience.HTTPError
+	if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound {
+		return nil, cache.ErrNotFound
+	}
+	if errors.Is(err, ErrLookupPending) {
+		return nil, ErrLookupPending
+	}
+	if err != nil {
+		log.Printf("error looking up email %s: %s", email, err)
+		return nil, err
+	}
+
+	return userDetailsFromClearbit(results)
+}
+
+func userDetailsFromClearbit(results *clearbit.PersonCompany) (*UserDetails, error) {
+	b, err := json.Marshal(results)
+	if err != nil {
+		return nil, err
+	}
+	return decodeUserDetails(b)
+}
+
+// decodeUserDetails maps a combined Person and Company response to UserDetails.
+func decodeUserDetails(b []byte) (*UserDetails, error) {
+	ud := &UserDetails{}
+	err := decodeMapped(b, ud)
 	if err != nil {
-		log.Printf("error looking up email; resp: %+v", resp.Status)
 		return nil, err
 	}
+	return ud, nil
+}
 
-	return &UserDetails{
-		FullName:        results.Person.Name.FullName,
-		Location:        results.Person.Location,
-		Role:            results.Person.Employment.Role,
-		Seniority:       results.Person.Employment.Seniority,
-		Company:         results.Company.Name,
-		GithubUser:      results.Person.GitHub.Handle,
-		GithubFollowers: results.Person.GitHub.Followers,
-	}, nil
+// webhookTransport adds Clearbit's webhook_url and webhook_id parameters to
+// lookups, which clearbit.PersonFindParams has no fields for.
+type webhookTransport struct {
+	base http.RoundTripper
+	url  string
+}
 
+func (t webhookTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	q := req.URL.Query()
+	q.Set("webhook_url", t.url)
+	q.Set("webhook_id", q.Get("email"))
+	req.URL.RawQuery = q.Encode()
+	return t.base.RoundTrip(req)
 }
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/meroxa/turbine-go"
+
+	"meroxa/turbine-go-examples/enrich/cache"
+)
+
+// Enricher looks up details about a user by email address. Implementations
+// return cache.ErrNotFound when they have no data for the email, and
+// ErrLookupPending when the result will be delivered later.
+type Enricher interface {
+	Enrich(email string) (*UserDetails, error)
+}
+
+// UserDetails are the enrichment results for a user. Fields are mapped from
+// Clearbit's combined response and to the record payload by their source and
+// payload tags, see mapping.go.
+type UserDetails struct {
+	FullName        string `json:"full_name" source:"person.name.fullName" payload:"full_name"`
+	Location        string `json:"location" source:"person.location" payload:"location"`
+	Role            string `json:"role" source:"person.employment.role" payload:"role"`
+	Seniority       string `json:"seniority" source:"person.employment.seniority" payload:"seniority"`
+	Company         string `json:"company" source:"company.name" payload:"company"`
+	GithubUser      string `json:"github_user" source:"person.github.handle" payload:"github_user"`
+	GithubFollowers int    `json:"github_followers" source:"person.github.followers" payload:"github_followers" default:"0"`
+}
+
+// newEnricher returns a FixtureEnricher when CLEARBIT_FIXTURES points to a
+// fixtures file, so the app can run without network access, and a
+// ClearbitEnricher otherwise.
+func newEnricher(v turbine.Turbine) (Enricher, error) {
+	if path := os.Getenv("CLEARBIT_FIXTURES"); path != "" {
+		return NewFixtureEnricher(path)
+	}
+
+	err := v.RegisterSecret("CLEARBIT_API_KEY") // makes env var available to data app
+	if err != nil {
+		return nil, err
+	}
+	return ClearbitEnricher{
+		APIKey:     os.Getenv("CLEARBIT_API_KEY"),
+		BaseURL:    os.Getenv("CLEARBIT_BASE_URL"),
+		WebhookURL: os.Getenv("CLEARBIT_WEBHOOK_URL"),
+	}, nil
+}
+
+const (
+	userCacheSize        = 10000
+	userCacheTTL         = 24 * time.Hour
+	userCacheNegativeTTL = time.Hour
+)
+
+// userCache holds enrichment lookups by email. It is persisted to the file
+// named by CLEARBIT_CACHE_FILE, if set, so it survives restarts of the function.
+var userCache = cache.New[*UserDetails](userCacheSize, userCacheTTL, userCacheNegativeTTL)
+
+func loadUserCache() {
+	path := os.Getenv("CLEARBIT_CACHE_FILE")
+	if path == "" {
+		return
+	}
+	err := userCache.Load(path)
+	if err != nil {
+		log.Println("error loading user cache: ", err)
+	}
+}
+
+func closeUserCache() {
+	log.Printf("user cache stats: %s", userCache.Stats())
+	path := os.Getenv("CLEARBIT_CACHE_FILE")
+	if path == "" {
+		return
+	}
+	err := userCache.Save(path)
+	if err != nil {
+		log.Println("error saving user cache: ", err)
+	}
+}
+
+// LookupUserDetails is e.Enrich behind userCache.
+func LookupUserDetails(e Enricher, email string) (*UserDetails, error) {
+	return userCache.Lookup(email, e.Enrich)
+}
+
+// FixtureEnricher serves lookups from a local JSON file mapping email
+// addresses to UserDetails.
+type FixtureEnricher struct {
+	users map[string]UserDetails
+}
+
+func NewFixtureEnricher(path string) (*FixtureEnricher, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var users map[string]UserDetails
+	err = json.Unmarshal(b, &users)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read enrichment fixtures %s: %w", path, err)
+	}
+	return &FixtureEnricher{users: users}, nil
+}
+
+func (e *FixtureEnricher) Enrich(email string) (*UserDetails, error) {
+	ud, ok := e.users[email]
+	if !ok {
+		return nil, cache.ErrNotFound
+	}
+	return &ud, nil
+}
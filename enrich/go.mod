@@ -5,6 +5,8 @@ go 1.19
 require (
 	github.com/clearbit/clearbit-go v1.1.0
 	github.com/meroxa/turbine-go v0.0.0-20220914174030-f35bdc304176
+	github.com/tidwall/gjson v1.14.3
+	github.com/tidwall/sjson v1.2.5
 )
 
 require (
@@ -18,10 +20,8 @@ require (
 	github.com/google/uuid v1.3.0 // indirect
 	github.com/meroxa/meroxa-go v0.0.0-20220915173905-789eb4683302 // indirect
 	github.com/oklog/run v1.1.1-0.20200508094559-c7096881717e // indirect
-	github.com/tidwall/gjson v1.14.3 // indirect
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.0 // indirect
-	github.com/tidwall/sjson v1.2.5 // indirect
 	github.com/volatiletech/inflect v0.0.1 // indirect
 	github.com/volatiletech/null/v8 v8.1.2 // indirect
 	github.com/volatiletech/randomize v0.0.1 // indirect
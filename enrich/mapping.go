@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/meroxa/turbine-go"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// Enrichment results are mapped declaratively with struct tags:
+//
+//	FullName string `source:"person.name.fullName" payload:"full_name" default:"unknown"`
+//
+// source is the path of the value in the enrichment response (gjson syntax),
+// and default is used when that path is missing or null. The response value is
+// coerced to the Go type of the field. payload is the path the field is written
+// to in the record; fields without one are fetched but not written. New payload
+// fields are added to the record schema with the type matching the Go type.
+
+// decodeMapped fills the fields of dst, a pointer to a struct, that have a
+// source tag from the JSON document b.
+func decodeMapped(b []byte, dst interface{}) error {
+	v := reflect.ValueOf(dst).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		path, ok := sf.Tag.Lookup("source")
+		if !ok {
+			continue
+		}
+
+		res := gjson.GetBytes(b, path)
+		if !res.Exists() || res.Type == gjson.Null {
+			def, ok := sf.Tag.Lookup("default")
+			if !ok {
+				continue
+			}
+			res = gjson.Result{Type: gjson.String, Str: def}
+		}
+
+		err := setValue(v.Field(i), res)
+		if err != nil {
+			return fmt.Errorf("unable to map %s to %s: %w", path, sf.Name, err)
+		}
+	}
+	return nil
+}
+
+// applyMapped writes the fields of src, a struct or pointer to a struct, that
+// have a payload tag to p.
+func applyMapped(p *turbine.Payload, src interface{}) error {
+	v := reflect.Indirect(reflect.ValueOf(src))
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		path, ok := t.Field(i).Tag.Lookup("payload")
+		if !ok {
+			continue
+		}
+
+		kcType, err := kcDataType(t.Field(i).Type.Kind())
+		if err != nil {
+			return fmt.Errorf("error setting %s value: %w", path, err)
+		}
+		err = setPayloadField(p, path, v.Field(i).Interface(), kcType)
+		if err != nil {
+			return fmt.Errorf("error setting %s value: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// setPayloadField is like turbine.Payload.Set, but records kcType as the schema
+// type of new fields. New fields of nested paths are added to the schema of
+// their parent struct, which is added too if missing.
+func setPayloadField(p *turbine.Payload, path string, value interface{}, kcType string) error {
+	nestedPath := "payload." + path
+	fieldExists := gjson.GetBytes(*p, nestedPath).Exists()
+
+	val, err := sjson.SetBytes(*p, nestedPath, value)
+	if err != nil {
+		return err
+	}
+
+	if !fieldExists && gjson.GetBytes(val, "schema").Exists() {
+		val, err = addSchemaField(val, path, kcType)
+		if err != nil {
+			return err
+		}
+	}
+
+	*p = val
+	return nil
+}
+
+// addSchemaField adds the field at path to the schema of p, with the structs
+// leading to it.
+func addSchemaField(p []byte, path string, kcType string) ([]byte, error) {
+	schemaPath := "schema"
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		idx := -1
+		gjson.GetBytes(p, schemaPath+".fields").ForEach(func(k, f gjson.Result) bool {
+			if f.Get("field").String() == name {
+				idx = int(k.Int())
+				return false
+			}
+			return true
+		})
+		if idx >= 0 {
+			schemaPath = fmt.Sprintf("%s.fields.%d", schemaPath, idx)
+			continue
+		}
+
+		field := map[string]interface{}{
+			"field":    name,
+			"optional": true,
+			"type":     kcType,
+		}
+		if i < len(names)-1 {
+			field["type"] = "struct"
+			field["fields"] = []interface{}{}
+		}
+
+		var err error
+		p, err = sjson.SetBytes(p, schemaPath+".fields.-1", field)
+		if err != nil {
+			return nil, err
+		}
+		schemaPath = fmt.Sprintf("%s.fields.%d", schemaPath, gjson.GetBytes(p, schemaPath+".fields.#").Int()-1)
+	}
+	return p, nil
+}
+
+func setValue(f reflect.Value, res gjson.Result) error {
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(res.String())
+	case reflect.Bool:
+		if res.Type == gjson.String {
+			b, err := strconv.ParseBool(res.Str)
+			if err != nil {
+				return err
+			}
+			f.SetBool(b)
+			return nil
+		}
+		f.SetBool(res.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if res.Type == gjson.String {
+			n, err := strconv.ParseInt(res.Str, 10, f.Type().Bits())
+			if err != nil {
+				return err
+			}
+			f.SetInt(n)
+			return nil
+		}
+		f.SetInt(res.Int())
+	case reflect.Float32, reflect.Float64:
+		if res.Type == gjson.String {
+			n, err := strconv.ParseFloat(res.Str, f.Type().Bits())
+			if err != nil {
+				return err
+			}
+			f.SetFloat(n)
+			return nil
+		}
+		f.SetFloat(res.Float())
+	default:
+		return fmt.Errorf("unsupported type %s", f.Type())
+	}
+	return nil
+}
+
+// kcDataType maps Go kinds to Apache Kafka Connect data types.
+func kcDataType(k reflect.Kind) (string, error) {
+	switch k {
+	case reflect.String:
+		return "string", nil
+	case reflect.Bool:
+		return "boolean", nil
+	case reflect.Int8:
+		return "int8", nil
+	case reflect.Int16:
+		return "int16", nil
+	case reflect.Int, reflect.Int32:
+		return "int32", nil
+	case reflect.Int64:
+		return "int64", nil
+	case reflect.Float32:
+		return "float32", nil
+	case reflect.Float64:
+		return "float64", nil
+	default:
+		return "", fmt.Errorf("unsupported type %s", k)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/meroxa/turbine-go"
+	"github.com/tidwall/gjson"
+)
+
+type testDetails struct {
+	Name      string  `source:"person.name" payload:"name" default:"unknown"`
+	Followers int     `source:"person.followers" payload:"followers"`
+	Score     float64 `source:"person.score" payload:"stats.score"`
+	Rank      int64   `source:"person.rank" payload:"stats.rank"`
+	Active    bool    `source:"person.active" default:"true"`
+	Ignored   string
+}
+
+func TestDecodeMapped(t *testing.T) {
+	var d testDetails
+	err := decodeMapped([]byte(`{"person":{"name":null,"followers":"42","score":1.5}}`), &d)
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+
+	want := testDetails{Name: "unknown", Followers: 42, Score: 1.5, Active: true}
+	if d != want {
+		t.Fatalf("want %+v, got %+v", want, d)
+	}
+}
+
+func TestDecodeMapped_InvalidValue(t *testing.T) {
+	var d testDetails
+	err := decodeMapped([]byte(`{"person":{"followers":"many"}}`), &d)
+	if err == nil {
+		t.Fatal("want error, got nil")
+	}
+}
+
+func TestApplyMapped(t *testing.T) {
+	p := turbine.Payload(`{"schema":{"type":"struct","fields":[{"field":"name","optional":true,"type":"string"}]},"payload":{"name":"old"}}`)
+
+	err := applyMapped(&p, testDetails{Name: "alice", Followers: 7, Score: 2.5, Ignored: "x"})
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+
+	if got := p.Get("name"); got != "alice" {
+		t.Fatalf("want name to be alice, got %v", got)
+	}
+	if got := p.Get("stats.score"); got != 2.5 {
+		t.Fatalf("want stats.score to be 2.5, got %v", got)
+	}
+	if p.Get("Ignored") != nil || p.Get("Active") != nil {
+		t.Fatalf("want untagged fields not to be written, got %s", p)
+	}
+
+	fields := gjson.GetBytes(p, "schema.fields").Array()
+	if len(fields) != 3 {
+		t.Fatalf("want 3 schema fields, got %s", gjson.GetBytes(p, "schema.fields"))
+	}
+	want := map[string]string{"name": "string", "followers": "int32", "stats": "struct"}
+	for _, f := range fields {
+		if got := f.Get("type").String(); got != want[f.Get("field").String()] {
+			t.Fatalf("want %s to be %s, got %s", f.Get("field"), want[f.Get("field").String()], got)
+		}
+	}
+
+	stats := gjson.GetBytes(p, `schema.fields.#(field=="stats").fields`).Array()
+	if len(stats) != 2 {
+		t.Fatalf("want 2 fields in the stats struct, got %s", gjson.GetBytes(p, `schema.fields.#(field=="stats")`))
+	}
+	want = map[string]string{"score": "float64", "rank": "int64"}
+	for _, f := range stats {
+		if got := f.Get("type").String(); got != want[f.Get("field").String()] {
+			t.Fatalf("want stats.%s to be %s, got %s", f.Get("field"), want[f.Get("field").String()], got)
+		}
+	}
+}
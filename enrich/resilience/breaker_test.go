@@ -0,0 +1,59 @@
+package resilience
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	cb := NewCircuitBreaker(2, time.Minute)
+	cb.now = func() time.Time { return now }
+
+	upstream := errors.New("upstream down")
+	calls := 0
+	failing := func() error { calls++; return upstream }
+	ok := func() error { calls++; return nil }
+
+	_ = cb.Do(failing)
+	_ = cb.Do(failing)
+	if !cb.Open() {
+		t.Fatal("want breaker to be open after 2 failures")
+	}
+
+	if err := cb.Do(ok); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("want ErrCircuitOpen, got %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("want 2 calls, got %d", calls)
+	}
+
+	// trial call after cooldown fails, breaker opens again
+	now = now.Add(time.Minute)
+	if err := cb.Do(failing); !errors.Is(err, upstream) {
+		t.Fatalf("want trial call to run, got %v", err)
+	}
+	if err := cb.Do(ok); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("want ErrCircuitOpen, got %v", err)
+	}
+
+	// trial call after cooldown succeeds, breaker closes
+	now = now.Add(time.Minute)
+	if err := cb.Do(ok); err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	if cb.Open() {
+		t.Fatal("want breaker to be closed")
+	}
+}
+
+func TestCircuitBreaker_PermanentErrorsDontTrip(t *testing.T) {
+	cb := NewCircuitBreaker(2, time.Minute)
+	for i := 0; i < 5; i++ {
+		_ = cb.Do(func() error { return Permanent(errors.New("not found")) })
+	}
+	if cb.Open() {
+		t.Fatal("want breaker to stay closed on permanent errors")
+	}
+}
@@ -0,0 +1,55 @@
+package resilience
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// HTTPError describes a non-2xx response from an upstream API.
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	Err        error // error decoded from the response body, if any
+}
+
+func (e *HTTPError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s", e.Status, e.Err)
+	}
+	return e.Status
+}
+
+func (e *HTTPError) Unwrap() error { return e.Err }
+
+// Retryable reports whether the request may succeed if sent again: request
+// timeouts, rate limiting and server errors.
+func (e *HTTPError) Retryable() bool {
+	switch {
+	case e.StatusCode == http.StatusRequestTimeout,
+		e.StatusCode == http.StatusTooManyRequests,
+		e.StatusCode >= 500:
+		return true
+	default:
+		return false
+	}
+}
+
+// CheckResponse classifies the outcome of an HTTP call. Transport errors (no
+// response) are retryable, non-2xx responses become an *HTTPError which is
+// marked Permanent unless it is Retryable, and any other error on a 2xx
+// response (e.g. a body that could not be decoded) is permanent.
+func CheckResponse(resp *http.Response, err error) error {
+	if resp == nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		httpErr := &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status, Err: err}
+		if httpErr.Retryable() {
+			return httpErr
+		}
+		return Permanent(httpErr)
+	}
+
+	return Permanent(err)
+}
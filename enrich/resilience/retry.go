@@ -0,0 +1,80 @@
+// Package resilience provides helpers for functions calling external APIs:
+// retries with exponential backoff, classification of HTTP errors and a
+// circuit breaker.
+package resilience
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff configures Retry. Delays grow exponentially from Initial by
+// Multiplier, are capped at Max and jittered.
+type Backoff struct {
+	Attempts   int // total number of attempts, including the first one
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+}
+
+// DefaultBackoff makes up to 4 attempts over roughly a second.
+var DefaultBackoff = Backoff{
+	Attempts:   4,
+	Initial:    200 * time.Millisecond,
+	Max:        5 * time.Second,
+	Multiplier: 2,
+}
+
+// Delay returns the time to wait after the given (zero based) failed attempt.
+// It uses "full jitter": a random duration between zero and the capped
+// exponential delay.
+func (b Backoff) Delay(attempt int) time.Duration {
+	d := float64(b.Initial) * math.Pow(b.Multiplier, float64(attempt))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// Retry calls fn until it succeeds, returns a permanent error or the attempts
+// configured in b are used up. The last error from fn is returned.
+func Retry(b Backoff, fn func() error) error {
+	var err error
+	for attempt := 0; attempt < b.Attempts || attempt == 0; attempt++ {
+		if attempt > 0 {
+			time.Sleep(b.Delay(attempt - 1))
+		}
+		err = fn()
+		if err == nil || IsPermanent(err) {
+			return err
+		}
+	}
+	return err
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying. The original error is still
+// reachable with errors.Is and errors.As.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent.
+func IsPermanent(err error) bool {
+	var pe *permanentError
+	return errors.As(err, &pe)
+}
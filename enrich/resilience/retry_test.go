@@ -0,0 +1,109 @@
+package resilience
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var testBackoff = Backoff{Attempts: 3, Initial: time.Millisecond, Max: 2 * time.Millisecond, Multiplier: 2}
+
+func TestRetry(t *testing.T) {
+	calls := 0
+	err := Retry(testBackoff, func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("temporary")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	if calls != 3 {
+		t.Fatalf("want 3 calls, got %d", calls)
+	}
+}
+
+func TestRetry_Exhausted(t *testing.T) {
+	calls := 0
+	want := errors.New("temporary")
+	err := Retry(testBackoff, func() error {
+		calls++
+		return want
+	})
+	if !errors.Is(err, want) {
+		t.Fatalf("want %s, got %v", want, err)
+	}
+	if calls != 3 {
+		t.Fatalf("want 3 calls, got %d", calls)
+	}
+}
+
+func TestRetry_Permanent(t *testing.T) {
+	calls := 0
+	want := errors.New("bad request")
+	err := Retry(testBackoff, func() error {
+		calls++
+		return Permanent(want)
+	})
+	if !errors.Is(err, want) || !IsPermanent(err) {
+		t.Fatalf("want permanent %s, got %v", want, err)
+	}
+	if calls != 1 {
+		t.Fatalf("want 1 call, got %d", calls)
+	}
+}
+
+func TestBackoff_Delay(t *testing.T) {
+	b := Backoff{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2}
+	for attempt, max := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second} {
+		for i := 0; i < 20; i++ {
+			if d := b.Delay(attempt); d < 0 || d > max {
+				t.Fatalf("attempt %d: want delay in [0, %s], got %s", attempt, max, d)
+			}
+		}
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	decodeErr := errors.New("decode error")
+	tests := []struct {
+		name      string
+		resp      *http.Response
+		err       error
+		wantNil   bool
+		permanent bool
+	}{
+		{name: "ok", resp: &http.Response{StatusCode: 200}, wantNil: true},
+		{name: "transport error", err: errors.New("connection refused")},
+		{name: "decode error", resp: &http.Response{StatusCode: 200}, err: decodeErr, permanent: true},
+		{name: "server error", resp: &http.Response{StatusCode: 503, Status: "503 Service Unavailable"}},
+		{name: "rate limited", resp: &http.Response{StatusCode: 429, Status: "429 Too Many Requests"}},
+		{name: "not found", resp: &http.Response{StatusCode: 404, Status: "404 Not Found"}, permanent: true},
+		{name: "unauthorized", resp: &http.Response{StatusCode: 401, Status: "401 Unauthorized"}, err: decodeErr, permanent: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckResponse(tt.resp, tt.err)
+			if tt.wantNil {
+				if err != nil {
+					t.Fatalf("want no error, got %s", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("want error, got nil")
+			}
+			if IsPermanent(err) != tt.permanent {
+				t.Fatalf("want permanent=%t, got %t (%s)", tt.permanent, IsPermanent(err), err)
+			}
+			var httpErr *HTTPError
+			if tt.resp != nil && tt.resp.StatusCode != 200 && !errors.As(err, &httpErr) {
+				t.Fatalf("want *HTTPError, got %T", err)
+			}
+		})
+	}
+}
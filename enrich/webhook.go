@@ -0,0 +1,368 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/meroxa/turbine-go"
+	"github.com/tidwall/gjson"
+
+	"meroxa/turbine-go-examples/enrich/cache"
+)
+
+const (
+	// clearbitPendingTimeout bounds how long Process waits for queued lookups.
+	clearbitPendingTimeout = 30 * time.Second
+	clearbitPollInterval   = 5 * time.Second
+	maxWebhookBodySize     = 1 << 20
+)
+
+var (
+	// ErrLookupPending is returned by an Enricher when the lookup was queued
+	// upstream and its result will be delivered later.
+	ErrLookupPending = errors.New("lookup pending")
+
+	// ErrLookupTimeout is the error parked records are dead-lettered with when
+	// their lookup result didn't arrive in time. They are passed on unenriched.
+	ErrLookupTimeout = errors.New("timed out waiting for lookup result")
+)
+
+// startWebhookReceiver returns the PendingLookups for the app, serving it on
+// CLEARBIT_WEBHOOK_ADDR if set. Without a webhook, pending lookups are only
+// completed by polling. The receiver isn't started without CLEARBIT_API_KEY,
+// which webhook signatures are verified with.
+func startWebhookReceiver() (*PendingLookups, error) {
+	p := NewPendingLookups(clearbitPendingTimeout, os.Getenv("CLEARBIT_API_KEY"))
+	addr := os.Getenv("CLEARBIT_WEBHOOK_ADDR")
+	if addr == "" {
+		return p, nil
+	}
+	if p.secret == "" {
+		return nil, errors.New("CLEARBIT_WEBHOOK_ADDR is set but CLEARBIT_API_KEY isn't: webhooks can't be verified")
+	}
+
+	go func() {
+		err := http.ListenAndServe(addr, p)
+		log.Println("error serving Clearbit webhook: ", err)
+	}()
+	return p, nil
+}
+
+// PendingLookups parks records whose lookup Clearbit has queued, and is the
+// http.Handler receiving Clearbit's webhook for them. Records are parked in a
+// Batch, one per Process call, and Await blocks until the records of its
+// batch are completed, by the webhook or by polling, or have been parked for
+// longer than timeout; they are then returned as failed with
+// ErrLookupTimeout. Concurrent calls each get their own records back.
+//
+// Nothing stays parked across calls to Await, so no record is lost when the
+// local runner calls Process once, when traffic stops, or when the webhook is
+// delivered to another replica: polling completes the lookup regardless.
+type PendingLookups struct {
+	mu      sync.Mutex
+	changed *sync.Cond // broadcast when parked records complete or time out
+	timeout time.Duration
+	poll    time.Duration
+	secret  string // key webhook signatures are verified with
+	parked  map[string][]parkedRecord
+	early   map[string]lookupResult // results that arrived before their records were parked
+	batches map[Batch]*batchResult
+	last    Batch
+	now     func() time.Time
+}
+
+// Batch identifies the records parked by one Process call.
+type Batch uint64
+
+type parkedRecord struct {
+	turbine.Record
+	batch    Batch
+	parkedAt time.Time
+}
+
+type lookupResult struct {
+	ud         *UserDetails
+	err        error
+	receivedAt time.Time
+}
+
+type batchResult struct {
+	ready  []turbine.Record
+	failed []turbine.RecordWithError
+}
+
+func NewPendingLookups(timeout time.Duration, secret string) *PendingLookups {
+	p := &PendingLookups{
+		timeout: timeout,
+		poll:    clearbitPollInterval,
+		secret:  secret,
+		parked:  make(map[string][]parkedRecord),
+		early:   make(map[string]lookupResult),
+		batches: make(map[Batch]*batchResult),
+		now:     time.Now,
+	}
+	p.changed = sync.NewCond(&p.mu)
+	return p
+}
+
+// Batch starts a new batch of parked records.
+func (p *PendingLookups) Batch() Batch {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.last++
+	p.batches[p.last] = &batchResult{}
+	return p.last
+}
+
+// Park holds r in batch b until the lookup for email completes or times out.
+func (p *PendingLookups) Park(b Batch, email string, r turbine.Record) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if res, ok := p.early[email]; ok {
+		p.finish(b, r, res.ud, res.err)
+		return
+	}
+	p.parked[email] = append(p.parked[email], parkedRecord{Record: r, batch: b, parkedAt: p.now()})
+}
+
+// Complete enriches the records parked for email, in any batch, with ud and
+// makes them ready for release. A nil ud releases them unchanged (nothing was
+// found); a non-nil err fails them. If nothing is parked for email yet the
+// result is kept, until the timeout, for records parked later.
+func (p *PendingLookups) Complete(email string, ud *UserDetails, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	prs, ok := p.parked[email]
+	if !ok {
+		p.early[email] = lookupResult{ud: ud, err: err, receivedAt: p.now()}
+		return
+	}
+	for _, pr := range prs {
+		p.finish(pr.batch, pr.Record, ud, err)
+	}
+	delete(p.parked, email)
+	p.changed.Broadcast()
+}
+
+// finish adds r to the results of batch b. p.mu must be held.
+func (p *PendingLookups) finish(b Batch, r turbine.Record, ud *UserDetails, err error) {
+	res, ok := p.batches[b]
+	if !ok {
+		res = &batchResult{}
+		p.batches[b] = res
+	}
+	if err == nil && ud != nil {
+		err = setUserDetails(&r, ud)
+	}
+	if err != nil {
+		res.failed = append(res.failed, turbine.RecordWithError{Error: err, Record: r})
+		return
+	}
+	res.ready = append(res.ready, r)
+}
+
+// Await waits until no records of batch b are parked, then returns those that
+// completed and those that failed or timed out, and ends the batch. Every
+// poll interval, lookups of b still parked are retried with lookup, which
+// returns ErrLookupPending while the result isn't ready.
+func (p *PendingLookups) Await(b Batch, lookup func(email string) (*UserDetails, error)) (ready []turbine.Record, failed []turbine.RecordWithError) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pollDue := false
+	timer := time.AfterFunc(p.poll, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		pollDue = true
+		p.changed.Broadcast()
+	})
+	defer timer.Stop()
+
+	for {
+		p.expire()
+		emails := p.parkedEmails(b)
+		if len(emails) == 0 {
+			ready, failed = p.release(b)
+			delete(p.batches, b)
+			return ready, failed
+		}
+		if !pollDue {
+			p.changed.Wait()
+			continue
+		}
+
+		pollDue = false
+		p.mu.Unlock()
+		for _, email := range emails {
+			ud, err := lookup(email)
+			if errors.Is(err, ErrLookupPending) {
+				continue
+			}
+			if errors.Is(err, cache.ErrNotFound) {
+				ud, err = nil, nil
+			}
+			p.Complete(email, ud, err)
+		}
+		p.mu.Lock()
+		timer.Reset(p.poll)
+	}
+}
+
+// Release returns the records of batch b completed since the last call, and
+// those that failed or timed out, without waiting for the others.
+func (p *PendingLookups) Release(b Batch) (ready []turbine.Record, failed []turbine.RecordWithError) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.expire()
+	return p.release(b)
+}
+
+// release returns and clears the results of batch b. p.mu must be held.
+func (p *PendingLookups) release(b Batch) (ready []turbine.Record, failed []turbine.RecordWithError) {
+	res, ok := p.batches[b]
+	if !ok {
+		return nil, nil
+	}
+	ready, failed = res.ready, res.failed
+	res.ready, res.failed = nil, nil
+	return ready, failed
+}
+
+// parkedEmails returns the emails records of batch b are parked for. p.mu must
+// be held.
+func (p *PendingLookups) parkedEmails(b Batch) []string {
+	var emails []string
+	for email, prs := range p.parked {
+		for _, pr := range prs {
+			if pr.batch == b {
+				emails = append(emails, email)
+				break
+			}
+		}
+	}
+	return emails
+}
+
+// expire fails parked records that timed out and forgets early results that
+// nothing was parked for in time. p.mu must be held.
+func (p *PendingLookups) expire() {
+	now := p.now()
+	for email, prs := range p.parked {
+		var waiting []parkedRecord
+		for _, pr := range prs {
+			if now.Sub(pr.parkedAt) >= p.timeout {
+				p.finish(pr.batch, pr.Record, nil, ErrLookupTimeout)
+				continue
+			}
+			waiting = append(waiting, pr)
+		}
+		if len(waiting) == len(prs) {
+			continue
+		}
+		p.changed.Broadcast()
+		if len(waiting) == 0 {
+			delete(p.parked, email)
+			continue
+		}
+		p.parked[email] = waiting
+	}
+	for email, res := range p.early {
+		if now.Sub(res.receivedAt) >= p.timeout {
+			delete(p.early, email)
+		}
+	}
+}
+
+// Len returns the number of parked records.
+func (p *PendingLookups) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, prs := range p.parked {
+		n += len(prs)
+	}
+	return n
+}
+
+// clearbitWebhook is the body Clearbit posts to the webhook URL.
+// https://dashboard.clearbit.com/docs#webhooks
+type clearbitWebhook struct {
+	ID     string          `json:"id"`
+	Status int             `json:"status"`
+	Type   string          `json:"type"`
+	Body   json.RawMessage `json:"body"`
+}
+
+func (p *PendingLookups) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	b, err := io.ReadAll(io.LimitReader(req.Body, maxWebhookBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if p.secret == "" || !validSignature(p.secret, b, req.Header.Get("X-Request-Signature")) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var hook clearbitWebhook
+	err = json.Unmarshal(b, &hook)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// lookups are tagged with the email as webhook ID, see webhookTransport
+	email := hook.ID
+	if email == "" {
+		email = gjson.GetBytes(hook.Body, "person.email").String()
+	}
+	if email == "" {
+		http.Error(w, "unable to match webhook to a lookup", http.StatusBadRequest)
+		return
+	}
+
+	switch hook.Status {
+	case http.StatusOK:
+		ud, err := decodeUserDetails(hook.Body)
+		if err != nil {
+			p.Complete(email, nil, err)
+			break
+		}
+		userCache.Set(email, ud)
+		p.Complete(email, ud, nil)
+	case http.StatusNotFound:
+		userCache.SetNotFound(email)
+		p.Complete(email, nil, nil)
+	default:
+		p.Complete(email, nil, fmt.Errorf("lookup failed with status %d", hook.Status))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// validSignature checks Clearbit's X-Request-Signature header, a hex HMAC-SHA1
+// of the body keyed with the API key.
+func validSignature(key string, body []byte, signature string) bool {
+	mac := hmac.New(sha1.New, []byte(key))
+	mac.Write(body)
+	want := "sha1=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(want), []byte(signature))
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/meroxa/turbine-go"
+
+	"meroxa/turbine-go-examples/enrich/cache"
+)
+
+const testWebhookKey = "test-key"
+
+func TestEnrichUserData_ProcessPendingLookup(t *testing.T) {
+	pending := NewPendingLookups(time.Minute, testWebhookKey)
+	pending.poll = time.Hour
+	receiver := httptest.NewServer(pending)
+	defer receiver.Close()
+
+	// fake Clearbit queues every lookup and posts the result afterwards
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		w.WriteHeader(http.StatusAccepted)
+
+		status, body := http.StatusOK, `{"person":{"name":{"fullName":"User Eight"}},"company":{"name":"Example"}}`
+		if q.Get("email") == "nobody@example.com" {
+			status, body = http.StatusNotFound, `null`
+		}
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			err := sendWebhook(q.Get("webhook_url"), testWebhookKey,
+				fmt.Sprintf(`{"id":%q,"status":%d,"type":"person_company","body":%s}`, q.Get("webhook_id"), status, body))
+			if err != nil {
+				t.Errorf("want no error sending webhook, got %s", err)
+			}
+		}()
+	}))
+	defer srv.Close()
+
+	enricher := setupClearbitTest(srv.URL)
+	enricher.APIKey = testWebhookKey
+	enricher.WebhookURL = receiver.URL
+
+	f := EnrichUserData{
+		Enricher:   enricher,
+		Pending:    pending,
+		DeadLetter: func(r turbine.RecordWithError) { t.Errorf("want no dead letters, got %s: %s", r.Key, r.Error) },
+	}
+
+	// the local runner calls Process once, so results must arrive within it
+	out := f.Process([]turbine.Record{
+		testRecord("1", "user8@example.com"),
+		testRecord("2", "nobody@example.com"),
+	})
+	if len(out) != 2 {
+		t.Fatalf("want 2 records, got %d", len(out))
+	}
+	for _, r := range out {
+		want := "User Eight"
+		if r.Key == "2" {
+			want = ""
+		}
+		if got, _ := r.Payload.Get("full_name").(string); got != want {
+			t.Fatalf("want full_name of record %s to be %q, got %q", r.Key, want, got)
+		}
+	}
+	if pending.Len() != 0 {
+		t.Fatalf("want no parked records, got %d", pending.Len())
+	}
+}
+
+func TestEnrichUserData_ProcessPendingLookupPolled(t *testing.T) {
+	// fake Clearbit queues the first lookup and never calls the webhook, as
+	// when it is delivered to another replica
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.Write([]byte(`{"person":{"name":{"fullName":"User Eight"}},"company":{"name":"Example"}}`))
+	}))
+	defer srv.Close()
+
+	pending := NewPendingLookups(time.Minute, "")
+	pending.poll = 10 * time.Millisecond
+	f := EnrichUserData{
+		Enricher:   setupClearbitTest(srv.URL),
+		Pending:    pending,
+		DeadLetter: func(r turbine.RecordWithError) { t.Errorf("want no dead letters, got %s: %s", r.Key, r.Error) },
+	}
+
+	out := f.Process([]turbine.Record{testRecord("1", "user8@example.com")})
+	if len(out) != 1 {
+		t.Fatalf("want 1 record, got %d", len(out))
+	}
+	if got := out[0].Payload.Get("full_name"); got != "User Eight" {
+		t.Fatalf("want full_name to be User Eight, got %v", got)
+	}
+}
+
+func TestEnrichUserData_ProcessPendingLookupTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	pending := NewPendingLookups(50*time.Millisecond, "")
+	pending.poll = 10 * time.Millisecond
+
+	var dlq []turbine.RecordWithError
+	f := EnrichUserData{
+		Enricher:   setupClearbitTest(srv.URL),
+		Pending:    pending,
+		DeadLetter: func(r turbine.RecordWithError) { dlq = append(dlq, r) },
+	}
+
+	out := f.Process([]turbine.Record{testRecord("1", "user8@example.com")})
+	if len(dlq) != 1 || !errors.Is(dlq[0].Error, ErrLookupTimeout) {
+		t.Fatalf("want record to time out, got %+v", dlq)
+	}
+	if len(out) != 1 || out[0].Key != "1" {
+		t.Fatalf("want timed out record to be passed on, got %d records", len(out))
+	}
+	if got := out[0].Payload.Get("full_name"); got != nil {
+		t.Fatalf("want timed out record to be unenriched, got full_name %v", got)
+	}
+}
+
+func TestPendingLookups_CompleteBeforePark(t *testing.T) {
+	pending := NewPendingLookups(time.Minute, "")
+	pending.Complete("user8@example.com", &UserDetails{FullName: "User Eight"}, nil)
+	b := pending.Batch()
+	pending.Park(b, "user8@example.com", testRecord("1", "user8@example.com"))
+
+	ready, failed := pending.Release(b)
+	if len(ready) != 1 || len(failed) != 0 {
+		t.Fatalf("want 1 ready record, got %d ready and %d failed", len(ready), len(failed))
+	}
+	if got := ready[0].Payload.Get("full_name"); got != "User Eight" {
+		t.Fatalf("want full_name to be User Eight, got %v", got)
+	}
+}
+
+func TestPendingLookups_InvalidSignature(t *testing.T) {
+	pending := NewPendingLookups(time.Minute, testWebhookKey)
+	pending.Park(pending.Batch(), "user8@example.com", testRecord("1", "user8@example.com"))
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"id":"user8@example.com","status":200,"body":{}}`))
+	req.Header.Set("X-Request-Signature", "sha1=0000")
+	w := httptest.NewRecorder()
+	pending.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("want status 401, got %d", w.Code)
+	}
+	if pending.Len() != 1 {
+		t.Fatalf("want record to stay parked, got %d parked", pending.Len())
+	}
+}
+
+func TestPendingLookups_NoKey(t *testing.T) {
+	pending := NewPendingLookups(time.Minute, "")
+	pending.Park(pending.Batch(), "user8@example.com", testRecord("1", "user8@example.com"))
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"id":"user8@example.com","status":200,"body":{}}`))
+	w := httptest.NewRecorder()
+	pending.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("want status 401 without a key, got %d", w.Code)
+	}
+
+	t.Setenv("CLEARBIT_WEBHOOK_ADDR", "127.0.0.1:0")
+	t.Setenv("CLEARBIT_API_KEY", "")
+	if _, err := startWebhookReceiver(); err == nil {
+		t.Fatal("want the receiver to refuse to start without CLEARBIT_API_KEY")
+	}
+}
+
+// pendingEnricher queues every lookup.
+type pendingEnricher struct{}
+
+func (pendingEnricher) Enrich(string) (*UserDetails, error) { return nil, ErrLookupPending }
+
+func TestEnrichUserData_ProcessConcurrentPendingLookups(t *testing.T) {
+	userCache = cache.New[*UserDetails](userCacheSize, userCacheTTL, userCacheNegativeTTL)
+	pending := NewPendingLookups(time.Minute, "")
+	pending.poll = time.Hour
+	f := EnrichUserData{Enricher: pendingEnricher{}, Pending: pending}
+
+	// both calls park a record of their own and one for a shared email
+	var wg sync.WaitGroup
+	outs := make([][]turbine.Record, 2)
+	for i := range outs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			outs[i] = f.Process([]turbine.Record{
+				testRecord(fmt.Sprint(i), fmt.Sprintf("user%d@example.com", i)),
+				testRecord(fmt.Sprint(i+10), "shared@example.com"),
+			})
+		}(i)
+	}
+	deadline := time.Now().Add(time.Second)
+	for pending.Len() < 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("want 4 parked records, got %d", pending.Len())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	pending.Complete("shared@example.com", &UserDetails{FullName: "Shared"}, nil)
+	pending.Complete("user1@example.com", &UserDetails{FullName: "User One"}, nil)
+	pending.Complete("user0@example.com", &UserDetails{FullName: "User Zero"}, nil)
+	wg.Wait()
+
+	for i, out := range outs {
+		keys := map[string]bool{}
+		for _, r := range out {
+			keys[r.Key] = true
+		}
+		if len(out) != 2 || !keys[fmt.Sprint(i)] || !keys[fmt.Sprint(i+10)] {
+			t.Fatalf("want records %d and %d from call %d, got %v", i, i+10, i, out)
+		}
+	}
+	if pending.Len() != 0 {
+		t.Fatalf("want no parked records, got %d", pending.Len())
+	}
+}
+
+func sendWebhook(url, key, body string) error {
+	mac := hmac.New(sha1.New, []byte(key))
+	mac.Write([]byte(body))
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBufferString(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Request-Signature", "sha1="+hex.EncodeToString(mac.Sum(nil)))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
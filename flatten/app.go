@@ -1,12 +1,11 @@
 package main
 
 import (
-	"log"
+	"embed"
 
 	// Dependencies of Turbine
 	"github.com/meroxa/turbine-go"
 	"github.com/meroxa/turbine-go/runner"
-	"github.com/meroxa/turbine-go/transforms"
 )
 
 func main() {
@@ -15,9 +14,19 @@ func main() {
 
 var _ turbine.App = (*App)(nil)
 
+// appConfig holds app.json, whose "transforms" section defines the pipeline.
+//
+//go:embed app.json
+var appConfig embed.FS
+
 type App struct{}
 
 func (a App) Run(v turbine.Turbine) error {
+	pipeline, err := ReadPipeline(appConfig, "app.json")
+	if err != nil {
+		return err
+	}
+
 	source, err := v.Resources("mongo")
 	if err != nil {
 		return err
@@ -28,7 +37,7 @@ func (a App) Run(v turbine.Turbine) error {
 		return err
 	}
 
-	res := v.Process(rr, Flatten{})
+	res := v.Process(rr, pipeline)
 
 	dest, err := v.Resources("destination_name")
 	if err != nil {
@@ -42,17 +51,3 @@ func (a App) Run(v turbine.Turbine) error {
 
 	return nil
 }
-
-type Flatten struct{}
-
-func (f Flatten) Process(stream []turbine.Record) []turbine.Record {
-	for i, r := range stream {
-		err := transforms.Flatten(&r.Payload)
-		if err != nil {
-			log.Printf("error: %s", err.Error())
-		}
-
-		stream[i] = r
-	}
-	return stream
-}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/meroxa/turbine-go"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// DeleteHandling is how ExtractNewState handles delete events.
+type DeleteHandling string
+
+const (
+	// DeletesDrop drops delete events.
+	DeletesDrop DeleteHandling = "drop"
+	// DeletesRewrite keeps delete events as the row's last state, with the
+	// DeletedField flag set. Other rows get the flag unset, so it can be used
+	// as a soft-delete column.
+	DeletesRewrite DeleteHandling = "rewrite"
+)
+
+// DeletedField is the soft-delete flag added by DeletesRewrite.
+const DeletedField = "__deleted"
+
+// ChangeOptions configures ExtractNewState and Envelope. Envelope undoes
+// ExtractNewState with the same options.
+type ChangeOptions struct {
+	// Deletes defaults to DeletesDrop.
+	Deletes DeleteHandling `json:"deletes"`
+	// AddFields are envelope fields copied next to the row fields, named
+	// like Debezium's add.fields: "op" and "ts_ms" are the event's, anything
+	// else is a field of source, optionally prefixed with "source.". They
+	// are added as "__" followed by the name with "." replaced by "_", so
+	// "table" becomes "__table" and "source.ts_ms" "__source_ts_ms".
+	AddFields []string `json:"add_fields"`
+	// Name is given to the envelope schema by Envelope, when set.
+	Name string `json:"name"`
+}
+
+// ExtractNewState replaces Debezium style change events ({"before": ...,
+// "after": ..., "source": ..., "op": ...}) with the state of the row after
+// the change, like Debezium's ExtractNewRecordState. Events with a Kafka
+// Connect schema get the schema of the row; the envelope schema describes
+// the fields in AddFields. Records that aren't change events are passed on
+// unchanged, records that can't be extracted are dropped.
+type ExtractNewState struct {
+	Options ChangeOptions
+}
+
+func (f ExtractNewState) Process(rr []turbine.Record) []turbine.Record {
+	out := rr[:0]
+	for _, r := range rr {
+		keep, err := ExtractNewStatePayload(&r.Payload, f.Options)
+		if err != nil {
+			log.Printf("error extracting record %s: %s", r.Key, err)
+			continue
+		}
+		if keep {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// ExtractNewStatePayload replaces the change event in p with the new state of
+// the row. It reports false for events that are dropped: deletes with
+// DeletesDrop and events without a row, such as truncates.
+func ExtractNewStatePayload(p *turbine.Payload, opts ChangeOptions) (bool, error) {
+	val := []byte(*p)
+	payloadPath, schemaPrefix := "", ""
+	if hasSchema(val) {
+		payloadPath, schemaPrefix = "payload.", "schema"
+	}
+	event := gjson.GetBytes(val, strings.TrimSuffix(payloadPath, "."))
+	if payloadPath == "" {
+		event = gjson.ParseBytes(val)
+	}
+	if !isChangeEvent(event) {
+		return true, nil
+	}
+
+	op := event.Get("op").String()
+	state := "after"
+	if op == "d" {
+		if opts.Deletes != DeletesRewrite {
+			return false, nil
+		}
+		state = "before"
+	}
+	row := event.Get(state)
+	if !row.IsObject() {
+		if op == "t" || op == "m" {
+			return false, nil
+		}
+		return false, fmt.Errorf("%s event without %s state", op, state)
+	}
+
+	out := []byte(row.Raw)
+	if schemaPrefix != "" {
+		var err error
+		out, err = sjson.SetRawBytes([]byte(`{}`), "payload", out)
+		if err != nil {
+			return false, err
+		}
+		out, err = setRowSchema(out, val, state)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	for _, name := range opts.AddFields {
+		path, col := changeField(name)
+		v := event.Get(path)
+		if !v.Exists() {
+			continue
+		}
+		var err error
+		out, err = sjson.SetRawBytes(out, payloadPath+escapePath(col), []byte(v.Raw))
+		if err != nil {
+			return false, err
+		}
+		if schemaPrefix == "" {
+			continue
+		}
+		fieldPath, ok := schemaFieldPath(val, path)
+		if !ok {
+			return false, fmt.Errorf("%s missing from the envelope schema", path)
+		}
+		field, err := sjson.SetBytes([]byte(gjson.GetBytes(val, fieldPath).Raw), "field", col)
+		if err != nil {
+			return false, err
+		}
+		field, err = sjson.SetBytes(field, "optional", true)
+		if err != nil {
+			return false, err
+		}
+		out, err = sjson.SetRawBytes(out, "schema.fields.-1", field)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if opts.Deletes == DeletesRewrite {
+		var err error
+		out, err = sjson.SetBytes(out, payloadPath+DeletedField, op == "d")
+		if err != nil {
+			return false, err
+		}
+		if schemaPrefix != "" {
+			out, err = sjson.SetBytes(out, "schema.fields.-1", map[string]interface{}{
+				"field":    DeletedField,
+				"optional": false,
+				"type":     "boolean",
+			})
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+
+	*p = out
+	return true, nil
+}
+
+// setRowSchema sets the schema of out to the schema of the state field of the
+// event in p.
+func setRowSchema(out, p []byte, state string) ([]byte, error) {
+	fieldPath, ok := schemaFieldPath(p, state)
+	if !ok {
+		return nil, fmt.Errorf("%s missing from the envelope schema", state)
+	}
+	schema, err := sjson.DeleteBytes([]byte(gjson.GetBytes(p, fieldPath).Raw), "field")
+	if err != nil {
+		return nil, err
+	}
+	schema, err = sjson.SetBytes(schema, "optional", false)
+	if err != nil {
+		return nil, err
+	}
+	return sjson.SetRawBytes(out, "schema", schema)
+}
+
+// Envelope is the inverse of ExtractNewState: it puts the payload of every
+// record in a Debezium style change event as the after state of a create. The
+// fields in AddFields are moved back to the envelope, so "__op" sets the
+// operation, and with DeletesRewrite rows flagged as deleted become deletes
+// with the row as before state. Records with a Kafka Connect schema get an
+// envelope schema. Records that are already change events are passed on
+// unchanged, records that can't be wrapped are dropped.
+type Envelope struct {
+	Options ChangeOptions
+}
+
+func (f Envelope) Process(rr []turbine.Record) []turbine.Record {
+	out := rr[:0]
+	for _, r := range rr {
+		err := EnvelopePayload(&r.Payload, f.Options)
+		if err != nil {
+			log.Printf("error enveloping record %s: %s", r.Key, err)
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// EnvelopePayload puts the row in p in a change event envelope.
+func EnvelopePayload(p *turbine.Payload, opts ChangeOptions) error {
+	val := []byte(*p)
+	withSchema := hasSchema(val)
+	rowPath := ""
+	if withSchema {
+		rowPath = "payload"
+	}
+	row := gjson.GetBytes(val, rowPath)
+	if !withSchema {
+		row = gjson.ParseBytes(val)
+	}
+	if !row.IsObject() {
+		return fmt.Errorf("payload is not an object")
+	}
+	if isChangeEvent(row) {
+		return nil
+	}
+
+	rowVal := []byte(row.Raw)
+	rowSchema := []byte(gjson.GetBytes(val, "schema").Raw)
+	event := []byte(`{}`)
+	var sourceFields []interface{}
+	var err error
+
+	moved := append([]string(nil), opts.AddFields...)
+	if opts.Deletes == DeletesRewrite {
+		moved = append(moved, DeletedField)
+	}
+	op := "c"
+	for _, name := range moved {
+		path, col := changeField(name)
+		if name == DeletedField {
+			col = DeletedField
+		}
+		v := row.Get(escapePath(col))
+		if !v.Exists() {
+			continue
+		}
+		rowVal, err = sjson.DeleteBytes(rowVal, escapePath(col))
+		if err != nil {
+			return err
+		}
+
+		var field gjson.Result
+		if withSchema {
+			if fieldPath, ok := schemaFieldPath(val, col); ok {
+				field = gjson.GetBytes(val, fieldPath)
+				rowSchema, err = deleteStructField(rowSchema, col)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		switch {
+		case name == DeletedField:
+			if v.Bool() {
+				op = "d"
+			}
+			continue
+		case path == "op":
+			op = v.String()
+			continue
+		}
+		event, err = sjson.SetRawBytes(event, path, []byte(v.Raw))
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(path, "source.") && field.Exists() {
+			f := field.Value().(map[string]interface{})
+			f["field"] = strings.TrimPrefix(path, "source.")
+			sourceFields = append(sourceFields, f)
+		}
+	}
+	state := "after"
+	if op == "d" {
+		state = "before"
+	}
+
+	event, err = sjson.SetRawBytes(event, "before", []byte("null"))
+	if err != nil {
+		return err
+	}
+	event, err = sjson.SetRawBytes(event, "after", []byte("null"))
+	if err != nil {
+		return err
+	}
+	event, err = sjson.SetRawBytes(event, state, rowVal)
+	if err != nil {
+		return err
+	}
+	event, err = sjson.SetBytes(event, "op", op)
+	if err != nil {
+		return err
+	}
+
+	if !withSchema {
+		*p = event
+		return nil
+	}
+
+	schema, err := envelopeSchema(rowSchema, sourceFields, gjson.GetBytes(event, "ts_ms").Exists(), opts.Name)
+	if err != nil {
+		return err
+	}
+	out, err := sjson.SetRawBytes([]byte(`{}`), "schema", schema)
+	if err != nil {
+		return err
+	}
+	out, err = sjson.SetRawBytes(out, "payload", event)
+	if err != nil {
+		return err
+	}
+	*p = out
+	return nil
+}
+
+// envelopeSchema returns the schema of a change event of rows described by
+// rowSchema.
+func envelopeSchema(rowSchema []byte, sourceFields []interface{}, tsMS bool, name string) ([]byte, error) {
+	schema := []byte(`{"type": "struct", "optional": false}`)
+	var err error
+	if name != "" {
+		schema, err = sjson.SetBytes(schema, "name", name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, state := range []string{"before", "after"} {
+		field, err := sjson.SetBytes(rowSchema, "field", state)
+		if err != nil {
+			return nil, err
+		}
+		field, err = sjson.SetBytes(field, "optional", true)
+		if err != nil {
+			return nil, err
+		}
+		schema, err = sjson.SetRawBytes(schema, "fields.-1", field)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(sourceFields) > 0 {
+		schema, err = sjson.SetBytes(schema, "fields.-1", map[string]interface{}{
+			"field":    "source",
+			"type":     "struct",
+			"optional": true,
+			"fields":   sourceFields,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	schema, err = sjson.SetBytes(schema, "fields.-1", map[string]interface{}{"field": "op", "type": "string", "optional": false})
+	if err != nil {
+		return nil, err
+	}
+	if tsMS {
+		schema, err = sjson.SetBytes(schema, "fields.-1", map[string]interface{}{"field": "ts_ms", "type": "int64", "optional": true})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return schema, nil
+}
+
+// isChangeEvent reports whether v looks like a Debezium change event.
+func isChangeEvent(v gjson.Result) bool {
+	res := v.Get("op")
+	return res.Type == gjson.String && (v.Get("after").Exists() || v.Get("before").Exists())
+}
+
+// changeField returns the path in the change event and the row column of the
+// AddFields entry name.
+func changeField(name string) (path, col string) {
+	switch {
+	case name == "op" || name == "ts_ms":
+		path = name
+	case strings.HasPrefix(name, "source."):
+		path = name
+	default:
+		path = "source." + name
+	}
+	return path, "__" + strings.ReplaceAll(name, ".", "_")
+}
+
+// deleteStructField removes the field name from the struct schema.
+func deleteStructField(schema []byte, name string) ([]byte, error) {
+	idx := -1
+	gjson.GetBytes(schema, "fields").ForEach(func(i, f gjson.Result) bool {
+		if f.Get("field").String() == name {
+			idx = int(i.Int())
+			return false
+		}
+		return true
+	})
+	if idx < 0 {
+		return schema, nil
+	}
+	return sjson.DeleteBytes(schema, fmt.Sprintf("fields.%d", idx))
+}
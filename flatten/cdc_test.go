@@ -0,0 +1,159 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/meroxa/turbine-go"
+	"github.com/tidwall/gjson"
+)
+
+const userSchema = `{"type":"struct","optional":true,"name":"pg.public.users.Value","fields":[` +
+	`{"type":"int32","optional":false,"field":"id"},` +
+	`{"type":"string","optional":true,"field":"email"}]}`
+
+func changeEvent(op, before, after string) string {
+	return `{"schema": {"type": "struct", "optional": false, "name": "pg.public.users.Envelope", "fields": [` +
+		fieldSchema(userSchema, "before") + `,` + fieldSchema(userSchema, "after") + `,` +
+		`{"type":"struct","optional":false,"field":"source","fields":[` +
+		`{"type":"string","optional":false,"field":"table"},` +
+		`{"type":"int64","optional":true,"field":"lsn"},` +
+		`{"type":"int64","optional":false,"field":"ts_ms"}]},` +
+		`{"type":"string","optional":false,"field":"op"},` +
+		`{"type":"int64","optional":true,"field":"ts_ms"}]},` +
+		`"payload": {"before": ` + before + `, "after": ` + after + `, ` +
+		`"source": {"table": "users", "lsn": 24023128, "ts_ms": 1643214353000}, "op": "` + op + `", "ts_ms": 1643214353100}}`
+}
+
+func fieldSchema(schema, field string) string {
+	return schema[:len(schema)-1] + `,"field":"` + field + `"}`
+}
+
+func TestExtractNewState_Process(t *testing.T) {
+	rr := []turbine.Record{
+		{Key: "1", Payload: []byte(changeEvent("u", `{"id": 1, "email": "old@example.com"}`, `{"id": 1, "email": "new@example.com"}`))},
+		{Key: "2", Payload: []byte(changeEvent("d", `{"id": 2, "email": "gone@example.com"}`, `null`))},
+		{Key: "3", Payload: []byte(`{"id": 3}`)},
+	}
+
+	out := ExtractNewState{Options: ChangeOptions{AddFields: []string{"op", "table", "source.ts_ms"}}}.Process(rr)
+
+	if len(out) != 2 || out[0].Key != "1" || out[1].Key != "3" {
+		t.Fatalf("want records 1 and 3, got %v", out)
+	}
+	if string(out[1].Payload) != `{"id": 3}` {
+		t.Fatalf("want records that aren't change events to be unchanged, got %s", out[1].Payload)
+	}
+
+	p := out[0].Payload
+	want := `{"id": 1, "email": "new@example.com", "__op": "u", "__table": "users", "__source_ts_ms": 1643214353000}`
+	if got := gjson.GetBytes(p, "payload").Raw; !jsonEqual(got, want) {
+		t.Fatalf("want payload %s, got %s", want, got)
+	}
+	wantSchema := `{"type":"struct","optional":false,"name":"pg.public.users.Value","fields":[` +
+		`{"type":"int32","optional":false,"field":"id"},` +
+		`{"type":"string","optional":true,"field":"email"},` +
+		`{"type":"string","optional":true,"field":"__op"},` +
+		`{"type":"string","optional":true,"field":"__table"},` +
+		`{"type":"int64","optional":true,"field":"__source_ts_ms"}]}`
+	if got := gjson.GetBytes(p, "schema").Raw; !jsonEqual(got, wantSchema) {
+		t.Fatalf("want schema %s, got %s", wantSchema, got)
+	}
+}
+
+func TestExtractNewState_SoftDelete(t *testing.T) {
+	rr := []turbine.Record{
+		{Key: "1", Payload: []byte(changeEvent("c", `null`, `{"id": 1, "email": "new@example.com"}`))},
+		{Key: "2", Payload: []byte(changeEvent("d", `{"id": 2, "email": "gone@example.com"}`, `null`))},
+	}
+
+	out := ExtractNewState{Options: ChangeOptions{Deletes: DeletesRewrite}}.Process(rr)
+
+	if len(out) != 2 {
+		t.Fatalf("want 2 records, got %d", len(out))
+	}
+	for i, want := range []bool{false, true} {
+		p := out[i].Payload
+		if got := gjson.GetBytes(p, "payload."+DeletedField); got.Type == gjson.Null || got.Bool() != want {
+			t.Fatalf("want %s of record %s to be %t, got %s", DeletedField, out[i].Key, want, p)
+		}
+		if f, _ := schemaFieldPath(p, DeletedField); gjson.GetBytes(p, f+".type").String() != "boolean" {
+			t.Fatalf("want %s in the schema, got %s", DeletedField, p)
+		}
+	}
+	if got := out[1].Payload.Get("email"); got != "gone@example.com" {
+		t.Fatalf("want the deleted row's last state, got %v", got)
+	}
+}
+
+func TestExtractNewState_Truncate(t *testing.T) {
+	rr := []turbine.Record{{Key: "1", Payload: []byte(`{"before": null, "after": null, "op": "t"}`)}}
+
+	if out := (ExtractNewState{}).Process(rr); len(out) != 0 {
+		t.Fatalf("want truncates to be dropped, got %d records", len(out))
+	}
+}
+
+func TestEnvelope_RoundTrip(t *testing.T) {
+	opts := ChangeOptions{Deletes: DeletesRewrite, AddFields: []string{"op", "table", "ts_ms"}}
+
+	tests := []struct {
+		name  string
+		event string
+	}{
+		{"update", `{"before": null, "after": {"id": 1}, "source": {"table": "users"}, "op": "u", "ts_ms": 5}`},
+		{"delete", `{"before": {"id": 1}, "after": null, "source": {"table": "users"}, "op": "d", "ts_ms": 5}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := turbine.Payload(tt.event)
+			keep, err := ExtractNewStatePayload(&p, opts)
+			if err != nil || !keep {
+				t.Fatalf("want the row to be kept, got %t (%v)", keep, err)
+			}
+
+			err = EnvelopePayload(&p, opts)
+			if err != nil {
+				t.Fatalf("want no error, got %s", err)
+			}
+			if !jsonEqual(string(p), tt.event) {
+				t.Fatalf("want %s, got %s", tt.event, p)
+			}
+		})
+	}
+}
+
+func TestEnvelope_Schema(t *testing.T) {
+	opts := ChangeOptions{AddFields: []string{"table"}, Name: "pg.public.users.Envelope"}
+	r := turbine.Record{Key: "1", Payload: []byte(`{"schema": {"type": "struct", "optional": false, "fields": [` +
+		`{"type":"int32","optional":false,"field":"id"},` +
+		`{"type":"string","optional":true,"field":"__table"}]}, "payload": {"id": 1, "__table": "users"}}`)}
+
+	out := Envelope{Options: opts}.Process([]turbine.Record{r})
+
+	if len(out) != 1 {
+		t.Fatalf("want 1 record, got %d", len(out))
+	}
+	p := out[0].Payload
+	want := `{"before": null, "after": {"id": 1}, "source": {"table": "users"}, "op": "c"}`
+	if got := gjson.GetBytes(p, "payload").Raw; !jsonEqual(got, want) {
+		t.Fatalf("want payload %s, got %s", want, got)
+	}
+	row := `{"type":"struct","optional":true,"fields":[{"type":"int32","optional":false,"field":"id"}]}`
+	wantSchema := `{"type":"struct","optional":false,"name":"pg.public.users.Envelope","fields":[` +
+		fieldSchema(row, "before") + `,` + fieldSchema(row, "after") + `,` +
+		`{"field":"source","type":"struct","optional":true,"fields":[{"type":"string","optional":true,"field":"table"}]},` +
+		`{"field":"op","type":"string","optional":false}]}`
+	if got := gjson.GetBytes(p, "schema").Raw; !jsonEqual(got, wantSchema) {
+		t.Fatalf("want schema %s, got %s", wantSchema, got)
+	}
+
+	// the envelope extracts back to the original record
+	keep, err := ExtractNewStatePayload(&p, opts)
+	if err != nil || !keep {
+		t.Fatalf("want the row to be kept, got %t (%v)", keep, err)
+	}
+	if got := gjson.GetBytes(p, "payload").Raw; !jsonEqual(got, `{"id": 1, "__table": "users"}`) {
+		t.Fatalf("want the original payload, got %s", got)
+	}
+}
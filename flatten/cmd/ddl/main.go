@@ -0,0 +1,135 @@
+// Command ddl prints the CREATE TABLE statements for the records an app wrote
+// in a local run, one table per destination collection:
+//
+//	go run . | go run ./cmd/ddl -dialect snowflake
+//
+// Records must have a Kafka Connect schema (see Wrap); the table gets the
+// fields of all of them. With -existing, collections whose schema was saved
+// to <dir>/<collection>.json get ALTER TABLE statements for the new fields
+// instead. JSON records without the local runner's headers go to -table.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/meroxa/flatten/ddl"
+	"github.com/tidwall/gjson"
+)
+
+// header starts the records written to a collection in the local runner's
+// output.
+var header = regexp.MustCompile(`^=+to .* \((.*)\) resource=+$`)
+
+func main() {
+	dialect := flag.String("dialect", ddl.Postgres.Name, "postgres, mysql, redshift, snowflake or sqlserver")
+	table := flag.String("table", "records", "table for records outside of a collection")
+	existing := flag.String("existing", "", "directory of existing <collection>.json schemas")
+	flag.Parse()
+
+	d, ok := ddl.Dialects[*dialect]
+	if !ok {
+		log.Fatalf("unknown dialect %q", *dialect)
+	}
+
+	collections, err := readCollections(os.Stdin, *table)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	names := make([]string, 0, len(collections))
+	for name := range collections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		stmts, err := statements(d, name, collections[name], *existing)
+		if err != nil {
+			log.Fatalf("%s: %s", name, err)
+		}
+		for _, s := range stmts {
+			fmt.Println(s)
+		}
+	}
+}
+
+// readCollections returns the schemas of the records in r by collection.
+func readCollections(r io.Reader, table string) (map[string][][]byte, error) {
+	bodies := make(map[string]*bytes.Buffer)
+	collection := table
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		line := s.Text()
+		if m := header.FindStringSubmatch(line); m != nil {
+			collection = m[1]
+			continue
+		}
+		if strings.HasSuffix(line, "record(s) written") {
+			continue
+		}
+		if bodies[collection] == nil {
+			bodies[collection] = &bytes.Buffer{}
+		}
+		bodies[collection].WriteString(line + "\n")
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	out := make(map[string][][]byte)
+	for name, body := range bodies {
+		dec := json.NewDecoder(body)
+		for {
+			var raw json.RawMessage
+			err := dec.Decode(&raw)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			schema := gjson.GetBytes(raw, "schema")
+			if !schema.IsObject() {
+				return nil, fmt.Errorf("%s: record without a schema, add a wrap step: %s", name, raw)
+			}
+			out[name] = append(out[name], []byte(schema.Raw))
+		}
+	}
+	return out, nil
+}
+
+func statements(d ddl.Dialect, table string, schemas [][]byte, existing string) ([]string, error) {
+	schema, err := ddl.MergeSchemas(schemas...)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing != "" {
+		old, err := os.ReadFile(filepath.Join(existing, table+".json"))
+		if err == nil {
+			return ddl.AlterTable(d, table, old, schema)
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	stmt, err := ddl.CreateTable(d, table, schema)
+	if err != nil {
+		return nil, err
+	}
+	return []string{stmt}, nil
+}
@@ -0,0 +1,195 @@
+// Package ddl derives CREATE TABLE and ALTER TABLE statements from the Kafka
+// Connect schemas of records, for relational destinations.
+//
+// Every top-level field of a struct schema becomes a column, named after the
+// field as is: run records through Sanitize first so names are valid for the
+// destination. Required fields are NOT NULL. Arrays, maps and nested structs
+// are stored in the dialect's JSON-like type, logical types (Decimal, Date,
+// Time, Timestamp) in their native types and unknown logical types as their
+// underlying type.
+package ddl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// ErrTypeChanged is returned by AlterTable for columns whose type differs
+// between the existing and the new schema, which adding columns can't fix.
+var ErrTypeChanged = errors.New("column type changed")
+
+// Column is a table column derived from a schema field.
+type Column struct {
+	Name     string
+	Type     string
+	Nullable bool
+}
+
+// Columns returns the columns for the fields of the struct schema.
+func Columns(d Dialect, schema []byte) ([]Column, error) {
+	s := gjson.ParseBytes(schema)
+	if t := s.Get("type").String(); t != "struct" {
+		return nil, fmt.Errorf("schema must be a struct, got %q", t)
+	}
+
+	var cols []Column
+	for _, f := range s.Get("fields").Array() {
+		name := f.Get("field").String()
+		typ, err := columnType(d, f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		cols = append(cols, Column{Name: name, Type: typ, Nullable: f.Get("optional").Bool()})
+	}
+	if len(cols) == 0 {
+		return nil, errors.New("schema has no fields")
+	}
+	return cols, nil
+}
+
+// CreateTable returns the statement creating table for the struct schema.
+// table may be qualified with a schema name, as in "public.events".
+func CreateTable(d Dialect, table string, schema []byte) (string, error) {
+	cols, err := Columns(d, schema)
+	if err != nil {
+		return "", err
+	}
+
+	defs := make([]string, len(cols))
+	for i, c := range cols {
+		defs[i] = d.QuoteIdent(c.Name) + " " + c.Type
+		if !c.Nullable {
+			defs[i] += " NOT NULL"
+		}
+	}
+	return fmt.Sprintf("CREATE TABLE %s (\n  %s\n);", quoteTable(d, table), strings.Join(defs, ",\n  ")), nil
+}
+
+// AlterTable returns the statements adding the fields of schema missing from
+// existing, the schema table was created for, as columns. Added columns are
+// nullable, since the table may already have rows.
+func AlterTable(d Dialect, table string, existing, schema []byte) ([]string, error) {
+	old, err := Columns(d, existing)
+	if err != nil {
+		return nil, fmt.Errorf("existing schema: %w", err)
+	}
+	cols, err := Columns(d, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	types := make(map[string]string, len(old))
+	for _, c := range old {
+		types[c.Name] = c.Type
+	}
+
+	var stmts []string
+	for _, c := range cols {
+		typ, ok := types[c.Name]
+		if !ok {
+			stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s %s %s %s;", quoteTable(d, table), d.AddColumn, d.QuoteIdent(c.Name), c.Type))
+			continue
+		}
+		if typ != c.Type {
+			return nil, fmt.Errorf("%w: %s from %s to %s", ErrTypeChanged, c.Name, typ, c.Type)
+		}
+	}
+	return stmts, nil
+}
+
+// MergeSchemas returns a struct schema with the fields of all schemas, such as
+// those of a sample of records. Fields missing from some schemas are optional.
+func MergeSchemas(schemas ...[]byte) ([]byte, error) {
+	var names []string
+	fields := make(map[string]map[string]interface{})
+	seen := make(map[string]int)
+	for i, schema := range schemas {
+		s := gjson.ParseBytes(schema)
+		if t := s.Get("type").String(); t != "struct" {
+			return nil, fmt.Errorf("schema %d must be a struct, got %q", i, t)
+		}
+		for _, f := range s.Get("fields").Array() {
+			name := f.Get("field").String()
+			m, ok := f.Value().(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("schema %d: invalid field %s", i, f.Raw)
+			}
+			seen[name]++
+
+			prev, ok := fields[name]
+			if !ok {
+				names = append(names, name)
+				fields[name] = m
+				continue
+			}
+			if !sameType(prev, m) {
+				return nil, fmt.Errorf("%s: conflicting types in schema %d", name, i)
+			}
+			if m["optional"] == true {
+				prev["optional"] = true
+			}
+		}
+	}
+
+	merged := make([]map[string]interface{}, len(names))
+	for i, name := range names {
+		merged[i] = fields[name]
+		if seen[name] < len(schemas) {
+			merged[i]["optional"] = true
+		}
+	}
+	return json.Marshal(map[string]interface{}{
+		"type":     "struct",
+		"optional": false,
+		"fields":   merged,
+	})
+}
+
+func sameType(a, b map[string]interface{}) bool {
+	for _, attr := range []string{"type", "name", "parameters", "items", "keys", "values", "fields"} {
+		ja, _ := json.Marshal(a[attr])
+		jb, _ := json.Marshal(b[attr])
+		if string(ja) != string(jb) {
+			return false
+		}
+	}
+	return true
+}
+
+func columnType(d Dialect, f gjson.Result) (string, error) {
+	name := f.Get("name").String()
+	if name == Decimal {
+		scale := int(f.Get("parameters.scale").Int())
+		if scale < 0 {
+			return "", fmt.Errorf("unsupported decimal scale %d", scale)
+		}
+		return d.decimal(int(f.Get(`parameters.connect\.decimal\.precision`).Int()), scale)
+	}
+	if t, ok := d.Types[name]; ok && name != "" {
+		return t, nil
+	}
+
+	switch typ := f.Get("type").String(); typ {
+	case "array", "map", "struct":
+		return d.Types["struct"], nil
+	default:
+		t, ok := d.Types[typ]
+		if !ok {
+			return "", fmt.Errorf("unsupported type %q", typ)
+		}
+		return t, nil
+	}
+}
+
+// quoteTable quotes every part of a qualified table name.
+func quoteTable(d Dialect, table string) string {
+	parts := strings.Split(table, ".")
+	for i, p := range parts {
+		parts[i] = d.QuoteIdent(p)
+	}
+	return strings.Join(parts, ".")
+}
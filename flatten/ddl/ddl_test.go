@@ -0,0 +1,186 @@
+package ddl
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const eventSchema = `{"type": "struct", "optional": false, "fields": [
+	{"field": "id", "type": "int64", "optional": false},
+	{"field": "user_email", "type": "string", "optional": true},
+	{"field": "active", "type": "boolean", "optional": true},
+	{"field": "amount", "type": "bytes", "optional": true, "name": "org.apache.kafka.connect.data.Decimal", "version": 1,
+		"parameters": {"scale": "2", "connect.decimal.precision": "10"}},
+	{"field": "created_at", "type": "int64", "optional": false, "name": "org.apache.kafka.connect.data.Timestamp", "version": 1},
+	{"field": "birthday", "type": "int32", "optional": true, "name": "org.apache.kafka.connect.data.Date", "version": 1},
+	{"field": "updated_at", "type": "string", "optional": true, "name": "io.debezium.time.ZonedTimestamp"},
+	{"field": "actions", "type": "array", "optional": true, "items": {"type": "string", "optional": false}}
+]}`
+
+func TestCreateTable(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		want    string
+	}{
+		{Postgres, `CREATE TABLE "public"."events" (
+  "id" BIGINT NOT NULL,
+  "user_email" TEXT,
+  "active" BOOLEAN,
+  "amount" NUMERIC(10,2),
+  "created_at" TIMESTAMP(3) NOT NULL,
+  "birthday" DATE,
+  "updated_at" TEXT,
+  "actions" JSONB
+);`},
+		{MySQL, "CREATE TABLE `public`.`events` (\n" +
+			"  `id` BIGINT NOT NULL,\n" +
+			"  `user_email` LONGTEXT,\n" +
+			"  `active` BOOLEAN,\n" +
+			"  `amount` DECIMAL(10,2),\n" +
+			"  `created_at` DATETIME(3) NOT NULL,\n" +
+			"  `birthday` DATE,\n" +
+			"  `updated_at` LONGTEXT,\n" +
+			"  `actions` JSON\n" +
+			");"},
+		{Redshift, `CREATE TABLE "public"."events" (
+  "id" BIGINT NOT NULL,
+  "user_email" VARCHAR(65535),
+  "active" BOOLEAN,
+  "amount" DECIMAL(10,2),
+  "created_at" TIMESTAMP NOT NULL,
+  "birthday" DATE,
+  "updated_at" VARCHAR(65535),
+  "actions" SUPER
+);`},
+		{Snowflake, `CREATE TABLE "public"."events" (
+  "id" BIGINT NOT NULL,
+  "user_email" VARCHAR,
+  "active" BOOLEAN,
+  "amount" NUMBER(10,2),
+  "created_at" TIMESTAMP_NTZ(3) NOT NULL,
+  "birthday" DATE,
+  "updated_at" VARCHAR,
+  "actions" VARIANT
+);`},
+		{SQLServer, `CREATE TABLE [public].[events] (
+  [id] BIGINT NOT NULL,
+  [user_email] NVARCHAR(MAX),
+  [active] BIT,
+  [amount] DECIMAL(10,2),
+  [created_at] DATETIME2(3) NOT NULL,
+  [birthday] DATE,
+  [updated_at] NVARCHAR(MAX),
+  [actions] NVARCHAR(MAX)
+);`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dialect.Name, func(t *testing.T) {
+			got, err := CreateTable(tt.dialect, "public.events", []byte(eventSchema))
+			if err != nil {
+				t.Fatalf("want no error, got %s", err)
+			}
+			if got != tt.want {
+				t.Fatalf("want\n%s\ngot\n%s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestColumns_Decimal(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		params  string
+		want    string
+	}{
+		{Postgres, `{"scale": "2"}`, "NUMERIC"},
+		{MySQL, `{"scale": "2"}`, "DECIMAL(65,2)"},
+		{Snowflake, `{"scale": "0"}`, "NUMBER(38,0)"},
+		{Redshift, `{"scale": "2", "connect.decimal.precision": "50"}`, ""},
+		{SQLServer, `{"scale": "-2"}`, ""},
+	}
+
+	for _, tt := range tests {
+		schema := `{"type": "struct", "fields": [{"field": "v", "type": "bytes", "name": "org.apache.kafka.connect.data.Decimal", "parameters": ` + tt.params + `}]}`
+		cols, err := Columns(tt.dialect, []byte(schema))
+		if tt.want == "" {
+			if err == nil {
+				t.Fatalf("%s %s: want error, got %s", tt.dialect.Name, tt.params, cols[0].Type)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s %s: want no error, got %s", tt.dialect.Name, tt.params, err)
+		}
+		if cols[0].Type != tt.want {
+			t.Fatalf("%s %s: want %s, got %s", tt.dialect.Name, tt.params, tt.want, cols[0].Type)
+		}
+	}
+}
+
+func TestAlterTable(t *testing.T) {
+	existing := `{"type": "struct", "fields": [
+		{"field": "id", "type": "int64", "optional": false},
+		{"field": "user_email", "type": "string", "optional": true}
+	]}`
+
+	got, err := AlterTable(SQLServer, "events", []byte(existing), []byte(eventSchema))
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	if len(got) != 6 {
+		t.Fatalf("want 6 statements, got %d: %s", len(got), got)
+	}
+	if want := "ALTER TABLE [events] ADD [created_at] DATETIME2(3);"; got[2] != want {
+		t.Fatalf("want %s, got %s", want, got[2])
+	}
+
+	got, err = AlterTable(Postgres, "events", []byte(existing), []byte(existing))
+	if err != nil || len(got) != 0 {
+		t.Fatalf("want no statements for an unchanged schema, got %s (%v)", got, err)
+	}
+
+	changed := strings.Replace(existing, `"int64"`, `"string"`, 1)
+	if _, err := AlterTable(Postgres, "events", []byte(existing), []byte(changed)); !errors.Is(err, ErrTypeChanged) {
+		t.Fatalf("want ErrTypeChanged, got %v", err)
+	}
+}
+
+func TestMergeSchemas(t *testing.T) {
+	a := `{"type": "struct", "fields": [{"field": "id", "type": "int64", "optional": false}, {"field": "a", "type": "string", "optional": false}]}`
+	b := `{"type": "struct", "fields": [{"field": "id", "type": "int64", "optional": false}, {"field": "b", "type": "boolean", "optional": false}]}`
+
+	merged, err := MergeSchemas([]byte(a), []byte(b))
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	cols, err := Columns(Postgres, merged)
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+
+	want := []Column{{"id", "BIGINT", false}, {"a", "TEXT", true}, {"b", "BOOLEAN", true}}
+	if len(cols) != len(want) {
+		t.Fatalf("want %v, got %v", want, cols)
+	}
+	for i := range want {
+		if cols[i] != want[i] {
+			t.Fatalf("want %v, got %v", want, cols)
+		}
+	}
+
+	conflict := strings.Replace(b, `"b"`, `"a"`, 1) // field a as a boolean
+	if _, err := MergeSchemas([]byte(a), []byte(b), []byte(conflict)); err == nil {
+		t.Fatal("want error for conflicting types, got nil")
+	}
+}
+
+func TestQuoteIdent(t *testing.T) {
+	if got := Postgres.QuoteIdent(`a"b`); got != `"a""b"` {
+		t.Fatalf(`want "a""b", got %s`, got)
+	}
+	if got := SQLServer.QuoteIdent("a]b"); got != "[a]]b]" {
+		t.Fatalf("want [a]]b], got %s", got)
+	}
+}
@@ -0,0 +1,176 @@
+package ddl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kafka Connect logical type names.
+const (
+	Decimal   = "org.apache.kafka.connect.data.Decimal"
+	Date      = "org.apache.kafka.connect.data.Date"
+	Time      = "org.apache.kafka.connect.data.Time"
+	Timestamp = "org.apache.kafka.connect.data.Timestamp"
+)
+
+// Dialect describes how a SQL database spells DDL.
+type Dialect struct {
+	Name string
+	// Quote encloses identifiers, Quote[1] is doubled to escape it.
+	Quote [2]string
+	// AddColumn is the ALTER TABLE clause adding a column.
+	AddColumn string
+	// Types maps Kafka Connect types and logical type names, other than
+	// Decimal, to column types. Arrays, maps and structs are stored as the
+	// type of "struct".
+	Types map[string]string
+	// Decimal is the column type of decimals, formatted with their precision
+	// and scale. Decimals without a precision get MaxPrecision, or plain
+	// DecimalAny if that is set.
+	Decimal      string
+	DecimalAny   string
+	MaxPrecision int
+}
+
+var (
+	Postgres = Dialect{
+		Name:      "postgres",
+		Quote:     [2]string{`"`, `"`},
+		AddColumn: "ADD COLUMN",
+		Types: map[string]string{
+			"int8":    "SMALLINT",
+			"int16":   "SMALLINT",
+			"int32":   "INTEGER",
+			"int64":   "BIGINT",
+			"float32": "REAL",
+			"float64": "DOUBLE PRECISION",
+			"boolean": "BOOLEAN",
+			"string":  "TEXT",
+			"bytes":   "BYTEA",
+			"struct":  "JSONB",
+			Date:      "DATE",
+			Time:      "TIME(3)",
+			Timestamp: "TIMESTAMP(3)",
+		},
+		Decimal:      "NUMERIC(%d,%d)",
+		DecimalAny:   "NUMERIC",
+		MaxPrecision: 1000,
+	}
+	MySQL = Dialect{
+		Name:      "mysql",
+		Quote:     [2]string{"`", "`"},
+		AddColumn: "ADD COLUMN",
+		Types: map[string]string{
+			"int8":    "TINYINT",
+			"int16":   "SMALLINT",
+			"int32":   "INT",
+			"int64":   "BIGINT",
+			"float32": "FLOAT",
+			"float64": "DOUBLE",
+			"boolean": "BOOLEAN",
+			"string":  "LONGTEXT",
+			"bytes":   "LONGBLOB",
+			"struct":  "JSON",
+			Date:      "DATE",
+			Time:      "TIME(3)",
+			Timestamp: "DATETIME(3)",
+		},
+		Decimal:      "DECIMAL(%d,%d)",
+		MaxPrecision: 65,
+	}
+	Redshift = Dialect{
+		Name:      "redshift",
+		Quote:     [2]string{`"`, `"`},
+		AddColumn: "ADD COLUMN",
+		Types: map[string]string{
+			"int8":    "SMALLINT",
+			"int16":   "SMALLINT",
+			"int32":   "INTEGER",
+			"int64":   "BIGINT",
+			"float32": "REAL",
+			"float64": "DOUBLE PRECISION",
+			"boolean": "BOOLEAN",
+			"string":  "VARCHAR(65535)",
+			"bytes":   "VARBYTE",
+			"struct":  "SUPER",
+			Date:      "DATE",
+			Time:      "TIME",
+			Timestamp: "TIMESTAMP",
+		},
+		Decimal:      "DECIMAL(%d,%d)",
+		MaxPrecision: 38,
+	}
+	Snowflake = Dialect{
+		Name:      "snowflake",
+		Quote:     [2]string{`"`, `"`},
+		AddColumn: "ADD COLUMN",
+		Types: map[string]string{
+			"int8":    "SMALLINT",
+			"int16":   "SMALLINT",
+			"int32":   "INTEGER",
+			"int64":   "BIGINT",
+			"float32": "FLOAT",
+			"float64": "FLOAT",
+			"boolean": "BOOLEAN",
+			"string":  "VARCHAR",
+			"bytes":   "BINARY",
+			"struct":  "VARIANT",
+			Date:      "DATE",
+			Time:      "TIME(3)",
+			Timestamp: "TIMESTAMP_NTZ(3)",
+		},
+		Decimal:      "NUMBER(%d,%d)",
+		MaxPrecision: 38,
+	}
+	SQLServer = Dialect{
+		Name:      "sqlserver",
+		Quote:     [2]string{"[", "]"},
+		AddColumn: "ADD",
+		Types: map[string]string{
+			"int8":    "SMALLINT", // TINYINT is unsigned
+			"int16":   "SMALLINT",
+			"int32":   "INT",
+			"int64":   "BIGINT",
+			"float32": "REAL",
+			"float64": "FLOAT",
+			"boolean": "BIT",
+			"string":  "NVARCHAR(MAX)",
+			"bytes":   "VARBINARY(MAX)",
+			"struct":  "NVARCHAR(MAX)",
+			Date:      "DATE",
+			Time:      "TIME(3)",
+			Timestamp: "DATETIME2(3)",
+		},
+		Decimal:      "DECIMAL(%d,%d)",
+		MaxPrecision: 38,
+	}
+)
+
+// Dialects are the supported dialects by name.
+var Dialects = map[string]Dialect{
+	Postgres.Name:  Postgres,
+	MySQL.Name:     MySQL,
+	Redshift.Name:  Redshift,
+	Snowflake.Name: Snowflake,
+	SQLServer.Name: SQLServer,
+}
+
+// QuoteIdent quotes the identifier name.
+func (d Dialect) QuoteIdent(name string) string {
+	return d.Quote[0] + strings.ReplaceAll(name, d.Quote[1], d.Quote[1]+d.Quote[1]) + d.Quote[1]
+}
+
+// decimal returns the column type of decimals with the given precision, 0 if
+// unknown, and scale.
+func (d Dialect) decimal(precision, scale int) (string, error) {
+	if precision == 0 {
+		if d.DecimalAny != "" {
+			return d.DecimalAny, nil
+		}
+		precision = d.MaxPrecision
+	}
+	if precision > d.MaxPrecision || scale > precision {
+		return "", fmt.Errorf("%s can't store decimals with precision %d and scale %d", d.Name, precision, scale)
+	}
+	return fmt.Sprintf(d.Decimal, precision, scale), nil
+}
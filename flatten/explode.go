@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/meroxa/turbine-go"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// IndexSuffix is appended to the name of an exploded field to get the field
+// holding the index of the element in the original array.
+const IndexSuffix = "_index"
+
+// Explode emits one record per element of the array at Path, which may be a
+// nested path such as "user.actions". Each record is a copy of its parent with
+// the array replaced by a single element, the element's index written to
+// Path+IndexSuffix and the key set to "{key}-{index}".
+//
+// Payloads with a Kafka Connect style schema are supported: the array field
+// is retyped to its item schema and the index field is added as an int32.
+// Records without an array at Path are passed on unchanged and records with an
+// empty array are dropped.
+type Explode struct {
+	Path string
+}
+
+func (f Explode) Process(rr []turbine.Record) []turbine.Record {
+	var out []turbine.Record
+	for _, r := range rr {
+		exploded, err := f.explode(r)
+		if err != nil {
+			log.Printf("error exploding record %s: %s", r.Key, err)
+			continue
+		}
+		out = append(out, exploded...)
+	}
+	return out
+}
+
+func (f Explode) explode(r turbine.Record) ([]turbine.Record, error) {
+	p := []byte(r.Payload)
+	path := f.Path
+	if hasSchema(p) {
+		path = "payload." + f.Path
+	}
+
+	arr := gjson.GetBytes(p, path)
+	if !arr.IsArray() {
+		return []turbine.Record{r}, nil
+	}
+
+	if hasSchema(p) {
+		var err error
+		p, err = explodeSchema(p, f.Path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var out []turbine.Record
+	for i, el := range arr.Array() {
+		val, err := sjson.SetRawBytes(p, path, []byte(el.Raw))
+		if err != nil {
+			return nil, err
+		}
+		val, err = sjson.SetBytes(val, path+IndexSuffix, i)
+		if err != nil {
+			return nil, err
+		}
+
+		rec := r
+		rec.Key = fmt.Sprintf("%s-%d", r.Key, i)
+		rec.Payload = val
+		out = append(out, rec)
+	}
+	return out, nil
+}
+
+// explodeSchema replaces the schema of the array field at path with the schema
+// of its items and adds the index field next to it.
+func explodeSchema(p []byte, path string) ([]byte, error) {
+	fieldPath, ok := schemaFieldPath(p, path)
+	if !ok {
+		return p, nil
+	}
+	field := gjson.GetBytes(p, fieldPath)
+	items := field.Get("items")
+	if field.Get("type").String() != "array" || !items.Exists() {
+		return nil, fmt.Errorf("schema field %s is not an array", path)
+	}
+
+	p, err := sjson.SetRawBytes(p, fieldPath, []byte(items.Raw))
+	if err != nil {
+		return nil, err
+	}
+	p, err = sjson.SetBytes(p, fieldPath+".field", field.Get("field").String())
+	if err != nil {
+		return nil, err
+	}
+	p, err = sjson.SetBytes(p, fieldPath+".optional", field.Get("optional").Bool())
+	if err != nil {
+		return nil, err
+	}
+
+	parentPath := fieldPath[:strings.LastIndex(fieldPath, ".fields.")]
+	return sjson.SetBytes(p, parentPath+".fields.-1", map[string]interface{}{
+		"field":    field.Get("field").String() + IndexSuffix,
+		"optional": false,
+		"type":     "int32",
+	})
+}
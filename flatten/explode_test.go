@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/meroxa/turbine-go"
+	"github.com/meroxa/turbine-go/platform"
+	"github.com/meroxa/turbine-go/proto"
+	"github.com/tidwall/gjson"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const nestedEvent = `{"id": 1, "user": {"id": 100, "name": "alice", "email": "alice@example.com"}, "actions": ["register", "purchase"]}`
+
+func TestExplode_Process(t *testing.T) {
+	rr := []turbine.Record{
+		{Key: "1", Payload: []byte(nestedEvent)},
+		{Key: "2", Payload: []byte(`{"id": 2, "actions": []}`)},
+		{Key: "3", Payload: []byte(`{"id": 3}`)},
+	}
+
+	out := Explode{Path: "actions"}.Process(rr)
+
+	if len(out) != 3 {
+		t.Fatalf("want 3 records, got %d", len(out))
+	}
+	for i, want := range []struct{ key, action string }{{"1-0", "register"}, {"1-1", "purchase"}} {
+		r := out[i]
+		if r.Key != want.key {
+			t.Fatalf("want key %s, got %s", want.key, r.Key)
+		}
+		if got := gjson.GetBytes(r.Payload, "actions").String(); got != want.action {
+			t.Fatalf("want actions %s, got %s", want.action, got)
+		}
+		if got := gjson.GetBytes(r.Payload, "actions_index").Int(); got != int64(i) {
+			t.Fatalf("want actions_index %d, got %d", i, got)
+		}
+		if got := gjson.GetBytes(r.Payload, "user.email").String(); got != "alice@example.com" {
+			t.Fatalf("want parent fields to be copied, got %s", r.Payload)
+		}
+	}
+	if out[2].Key != "3" {
+		t.Fatalf("want record without array to be passed on, got key %s", out[2].Key)
+	}
+}
+
+func TestExplode_ProcessWithSchema(t *testing.T) {
+	r := turbine.Record{
+		Key: "1",
+		Payload: []byte(`{"schema": {"type": "struct", "fields": [
+			{"field": "id", "type": "int32", "optional": false},
+			{"field": "user", "type": "struct", "optional": false, "fields": [
+				{"field": "tags", "type": "array", "optional": true, "items": {"type": "string", "optional": false}}
+			]}
+		]}, "payload": {"id": 1, "user": {"tags": ["a", "b", "c"]}}}`),
+	}
+
+	out := Explode{Path: "user.tags"}.Process([]turbine.Record{r})
+
+	if len(out) != 3 {
+		t.Fatalf("want 3 records, got %d", len(out))
+	}
+	p := out[2].Payload
+	if got := gjson.GetBytes(p, "payload.user.tags").String(); got != "c" {
+		t.Fatalf("want user.tags c, got %s", got)
+	}
+	if got := gjson.GetBytes(p, "payload.user.tags_index").Int(); got != 2 {
+		t.Fatalf("want user.tags_index 2, got %d", got)
+	}
+
+	fields := gjson.GetBytes(p, "schema.fields.1.fields").Array()
+	if len(fields) != 2 {
+		t.Fatalf("want 2 user schema fields, got %s", gjson.GetBytes(p, "schema"))
+	}
+	if fields[0].Get("type").String() != "string" || !fields[0].Get("optional").Bool() {
+		t.Fatalf("want tags to be an optional string, got %s", fields[0].Raw)
+	}
+	if fields[1].Get("field").String() != "tags_index" || fields[1].Get("type").String() != "int32" {
+		t.Fatalf("want tags_index int32 field, got %s", fields[1].Raw)
+	}
+}
+
+// TestExplode_Serve runs Explode behind the gRPC server used on the platform,
+// which has to return more records than it received.
+func TestExplode_Serve(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	t.Setenv("MEROXA_FUNCTION_ADDR", addr)
+	go func() {
+		_ = platform.ServeFunc(Explode{Path: "actions"})
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithBlock())
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	defer conn.Close()
+
+	resp, err := proto.NewFunctionClient(conn).Process(ctx, &proto.ProcessRecordRequest{
+		Records: []*proto.Record{{Key: "1", Value: nestedEvent, Timestamp: 1663200000}},
+	})
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+
+	if len(resp.Records) != 2 {
+		t.Fatalf("want 2 records, got %d", len(resp.Records))
+	}
+	for i, want := range []string{"1-0", "1-1"} {
+		r := resp.Records[i]
+		if r.Key != want {
+			t.Fatalf("want key %s, got %s", want, r.Key)
+		}
+		if r.Timestamp != 1663200000 {
+			t.Fatalf("want timestamp to be kept, got %d", r.Timestamp)
+		}
+	}
+	if got := gjson.Get(resp.Records[1].Value, "actions").String(); got != "purchase" {
+		t.Fatalf("want actions purchase, got %s", got)
+	}
+}
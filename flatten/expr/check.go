@@ -0,0 +1,619 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// evalFunc evaluates a checked expression on a payload.
+type evalFunc func(payload gjson.Result) (interface{}, error)
+
+// checker checks expressions and compiles them to evalFuncs.
+type checker struct {
+	src     string
+	payload Type
+}
+
+func (c *checker) errorf(n Node, format string, args ...interface{}) error {
+	return &Error{Src: c.src, Pos: n.Pos(), Msg: fmt.Sprintf(format, args...)}
+}
+
+func (c *checker) check(n Node) (Type, evalFunc, error) {
+	switch n := n.(type) {
+	case *Literal:
+		v := n.Value
+		return typeOf(v), func(gjson.Result) (interface{}, error) { return v, nil }, nil
+	case *Ident, *Select:
+		if path, names, ok := fieldPath(n); ok {
+			return c.checkPath(n, path, names)
+		}
+		return c.checkSelect(n.(*Select))
+	case *Index:
+		return c.checkIndex(n)
+	case *Unary:
+		return c.checkUnary(n)
+	case *Binary:
+		return c.checkBinary(n)
+	case *Cond:
+		return c.checkCond(n)
+	case *Call:
+		return c.checkCall(n)
+	case *ListLit:
+		return c.checkList(n)
+	}
+	return Type{}, nil, c.errorf(n, "unexpected %T", n)
+}
+
+// fieldPath returns the gjson path and the names of the payload field n
+// reads, if n is a chain of field selections.
+func fieldPath(n Node) (string, []string, bool) {
+	var names []string
+	for {
+		switch x := n.(type) {
+		case *Ident:
+			names = append(names, x.Name)
+			for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+				names[i], names[j] = names[j], names[i]
+			}
+			escaped := make([]string, len(names))
+			for i, name := range names {
+				escaped[i] = escapePath(name)
+			}
+			return strings.Join(escaped, "."), names, true
+		case *Select:
+			names = append(names, x.Field)
+			n = x.X
+		default:
+			return "", nil, false
+		}
+	}
+}
+
+func (c *checker) checkPath(n Node, path string, names []string) (Type, evalFunc, error) {
+	t := c.payload
+	for i, name := range names {
+		ft, err := fieldType(t, name)
+		if err != nil {
+			return Type{}, nil, c.errorf(n, "%s: %s", strings.Join(names[:i+1], "."), err)
+		}
+		t = ft
+	}
+	return t, func(payload gjson.Result) (interface{}, error) {
+		v, err := fromJSON(payload.Get(path), t)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", strings.Join(names, "."), err)
+		}
+		return v, nil
+	}, nil
+}
+
+// fieldType returns the type of the field name of values of type t.
+func fieldType(t Type, name string) (Type, error) {
+	switch t.Kind {
+	case Dyn:
+		return dynType, nil
+	case Map:
+		if t.Fields == nil {
+			return t.elem(), nil
+		}
+		if ft, ok := t.Fields[name]; ok {
+			return ft, nil
+		}
+		return Type{}, fmt.Errorf("unknown field %s", name)
+	}
+	return Type{}, fmt.Errorf("%s has no fields", t)
+}
+
+func (c *checker) checkSelect(n *Select) (Type, evalFunc, error) {
+	xt, x, err := c.check(n.X)
+	if err != nil {
+		return Type{}, nil, err
+	}
+	t, err := fieldType(xt, n.Field)
+	if err != nil {
+		return Type{}, nil, c.errorf(n, "%s", err)
+	}
+	return t, func(payload gjson.Result) (interface{}, error) {
+		xv, err := x(payload)
+		if xv == nil || err != nil {
+			return nil, err
+		}
+		m, ok := xv.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s has no fields", typeOf(xv))
+		}
+		return m[n.Field], nil
+	}, nil
+}
+
+func (c *checker) checkIndex(n *Index) (Type, evalFunc, error) {
+	xt, x, err := c.check(n.X)
+	if err != nil {
+		return Type{}, nil, err
+	}
+	it, index, err := c.check(n.Index)
+	if err != nil {
+		return Type{}, nil, err
+	}
+	var t Type
+	switch xt.Kind {
+	case List:
+		if !accepts(it, Int) {
+			return Type{}, nil, c.errorf(n.Index, "list index is %s, want int", it)
+		}
+		t = xt.elem()
+	case Map:
+		if !accepts(it, String) {
+			return Type{}, nil, c.errorf(n.Index, "map key is %s, want string", it)
+		}
+		t = xt.elem()
+		if xt.Fields != nil {
+			t = dynType
+		}
+	case Dyn, Null:
+		t = dynType
+	default:
+		return Type{}, nil, c.errorf(n, "can't index %s", xt)
+	}
+	return t, func(payload gjson.Result) (interface{}, error) {
+		xv, err := x(payload)
+		if xv == nil || err != nil {
+			return nil, err
+		}
+		iv, err := index(payload)
+		if iv == nil || err != nil {
+			return nil, err
+		}
+		switch xv := xv.(type) {
+		case []interface{}:
+			i, ok := iv.(int64)
+			if !ok {
+				return nil, fmt.Errorf("list index is %s, want int", typeOf(iv))
+			}
+			if i < 0 || i >= int64(len(xv)) {
+				return nil, nil
+			}
+			return xv[i], nil
+		case map[string]interface{}:
+			k, ok := iv.(string)
+			if !ok {
+				return nil, fmt.Errorf("map key is %s, want string", typeOf(iv))
+			}
+			return xv[k], nil
+		}
+		return nil, fmt.Errorf("can't index %s", typeOf(xv))
+	}, nil
+}
+
+func (c *checker) checkUnary(n *Unary) (Type, evalFunc, error) {
+	xt, x, err := c.check(n.X)
+	if err != nil {
+		return Type{}, nil, err
+	}
+	if n.Op == "!" {
+		if !accepts(xt, Bool) {
+			return Type{}, nil, c.errorf(n, "can't negate %s", xt)
+		}
+		return boolType, func(payload gjson.Result) (interface{}, error) {
+			v, err := x(payload)
+			if v == nil || err != nil {
+				return nil, err
+			}
+			b, ok := v.(bool)
+			if !ok {
+				return nil, fmt.Errorf("can't negate %s", typeOf(v))
+			}
+			return !b, nil
+		}, nil
+	}
+
+	if !accepts(xt, Int, Float, Duration) {
+		return Type{}, nil, c.errorf(n, "can't negate %s", xt)
+	}
+	return xt, func(payload gjson.Result) (interface{}, error) {
+		v, err := x(payload)
+		if v == nil || err != nil {
+			return nil, err
+		}
+		switch v := v.(type) {
+		case int64:
+			return -v, nil
+		case float64:
+			return -v, nil
+		case time.Duration:
+			return -v, nil
+		}
+		return nil, fmt.Errorf("can't negate %s", typeOf(v))
+	}, nil
+}
+
+func (c *checker) checkBinary(n *Binary) (Type, evalFunc, error) {
+	xt, x, err := c.check(n.X)
+	if err != nil {
+		return Type{}, nil, err
+	}
+	yt, y, err := c.check(n.Y)
+	if err != nil {
+		return Type{}, nil, err
+	}
+
+	switch n.Op {
+	case "&&", "||":
+		if !accepts(xt, Bool) || !accepts(yt, Bool) {
+			return Type{}, nil, c.errorf(n, "%s needs bools, got %s and %s", n.Op, xt, yt)
+		}
+		return boolType, logical(n.Op == "&&", x, y), nil
+
+	case "==", "!=":
+		if _, ok := unify(xt, yt); !ok {
+			return Type{}, nil, c.errorf(n, "can't compare %s and %s", xt, yt)
+		}
+		want := n.Op == "=="
+		return boolType, func(payload gjson.Result) (interface{}, error) {
+			xv, err := x(payload)
+			if err != nil {
+				return nil, err
+			}
+			yv, err := y(payload)
+			if err != nil {
+				return nil, err
+			}
+			return equal(xv, yv) == want, nil
+		}, nil
+
+	case "<", "<=", ">", ">=":
+		t, ok := unify(xt, yt)
+		if !ok || !accepts(t, Int, Float, String, Timestamp, Duration) {
+			return Type{}, nil, c.errorf(n, "can't compare %s and %s", xt, yt)
+		}
+		op := n.Op
+		return boolType, func(payload gjson.Result) (interface{}, error) {
+			xv, yv, err := operands(payload, x, y)
+			if xv == nil || yv == nil || err != nil {
+				return nil, err
+			}
+			c, err := compare(xv, yv)
+			if err != nil {
+				return nil, err
+			}
+			switch op {
+			case "<":
+				return c < 0, nil
+			case "<=":
+				return c <= 0, nil
+			case ">":
+				return c > 0, nil
+			}
+			return c >= 0, nil
+		}, nil
+
+	case "in":
+		var elem Type
+		switch yt.Kind {
+		case List:
+			elem = yt.elem()
+		case Map:
+			elem = stringType
+		case Dyn, Null:
+			elem = dynType
+		default:
+			return Type{}, nil, c.errorf(n.Y, "in needs a list or map, got %s", yt)
+		}
+		if _, ok := unify(xt, elem); !ok {
+			return Type{}, nil, c.errorf(n, "can't look for %s in %s", xt, yt)
+		}
+		return boolType, func(payload gjson.Result) (interface{}, error) {
+			xv, yv, err := operands(payload, x, y)
+			if yv == nil || err != nil {
+				return nil, err
+			}
+			switch yv := yv.(type) {
+			case []interface{}:
+				for _, e := range yv {
+					if equal(xv, e) {
+						return true, nil
+					}
+				}
+				return false, nil
+			case map[string]interface{}:
+				k, ok := xv.(string)
+				if !ok {
+					return false, nil
+				}
+				_, found := yv[k]
+				return found, nil
+			}
+			return nil, fmt.Errorf("in needs a list or map, got %s", typeOf(yv))
+		}, nil
+	}
+
+	t, err := arithmeticType(n.Op, xt, yt)
+	if err != nil {
+		return Type{}, nil, c.errorf(n, "%s", err)
+	}
+	op := n.Op
+	return t, func(payload gjson.Result) (interface{}, error) {
+		xv, yv, err := operands(payload, x, y)
+		if xv == nil || yv == nil || err != nil {
+			return nil, err
+		}
+		return arithmetic(op, xv, yv)
+	}, nil
+}
+
+// logical returns the evalFunc of x && y, or x || y when and is false, which
+// only evaluates y when x doesn't decide the result.
+func logical(and bool, x, y evalFunc) evalFunc {
+	return func(payload gjson.Result) (interface{}, error) {
+		xv, err := x(payload)
+		if err != nil {
+			return nil, err
+		}
+		if xb, ok := xv.(bool); ok && xb != and {
+			return xb, nil
+		}
+		yv, err := y(payload)
+		if err != nil {
+			return nil, err
+		}
+		if yb, ok := yv.(bool); ok && yb != and {
+			return yb, nil
+		}
+		if xv == nil || yv == nil {
+			return nil, nil
+		}
+		if _, ok := xv.(bool); !ok {
+			return nil, fmt.Errorf("want bool, got %s", typeOf(xv))
+		}
+		if _, ok := yv.(bool); !ok {
+			return nil, fmt.Errorf("want bool, got %s", typeOf(yv))
+		}
+		return and, nil
+	}
+}
+
+func operands(payload gjson.Result, x, y evalFunc) (interface{}, interface{}, error) {
+	xv, err := x(payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	yv, err := y(payload)
+	return xv, yv, err
+}
+
+// arithmeticType returns the type of x op y for the operator op, one of
+// + - * / %.
+func arithmeticType(op string, x, y Type) (Type, error) {
+	if x.Kind == Null || y.Kind == Null {
+		x, _ = unify(x, y)
+		y = x
+	}
+	switch {
+	case x.Kind == Dyn || y.Kind == Dyn:
+		return dynType, nil
+	case x.numeric() && y.numeric() && (op != "%" || x.Kind == Int && y.Kind == Int):
+		if x.Kind == Int && y.Kind == Int {
+			return intType, nil
+		}
+		return floatType, nil
+	case op == "+" && x.Kind == String && y.Kind == String:
+		return stringType, nil
+	case op == "+" && x.Kind == List && y.Kind == List:
+		if t, ok := unify(x, y); ok {
+			return t, nil
+		}
+	case op == "+" && x.Kind == Timestamp && y.Kind == Duration,
+		op == "+" && x.Kind == Duration && y.Kind == Timestamp,
+		op == "-" && x.Kind == Timestamp && y.Kind == Duration:
+		return timestampType, nil
+	case op == "-" && x.Kind == Timestamp && y.Kind == Timestamp,
+		(op == "+" || op == "-") && x.Kind == Duration && y.Kind == Duration:
+		return durationType, nil
+	}
+	return Type{}, fmt.Errorf("can't apply %s to %s and %s", op, x, y)
+}
+
+// arithmetic returns x op y for non-null x and y.
+func arithmetic(op string, x, y interface{}) (interface{}, error) {
+	switch x := x.(type) {
+	case int64:
+		switch y := y.(type) {
+		case int64:
+			switch op {
+			case "+":
+				return x + y, nil
+			case "-":
+				return x - y, nil
+			case "*":
+				return x * y, nil
+			case "/", "%":
+				if y == 0 {
+					return nil, fmt.Errorf("division by zero")
+				}
+				if op == "/" {
+					return x / y, nil
+				}
+				return x % y, nil
+			}
+		case float64:
+			return floatArithmetic(op, float64(x), y)
+		}
+	case float64:
+		switch y := y.(type) {
+		case int64:
+			return floatArithmetic(op, x, float64(y))
+		case float64:
+			return floatArithmetic(op, x, y)
+		}
+	case string:
+		if y, ok := y.(string); ok && op == "+" {
+			return x + y, nil
+		}
+	case []interface{}:
+		if y, ok := y.([]interface{}); ok && op == "+" {
+			return append(append([]interface{}{}, x...), y...), nil
+		}
+	case time.Time:
+		switch y := y.(type) {
+		case time.Duration:
+			switch op {
+			case "+":
+				return x.Add(y), nil
+			case "-":
+				return x.Add(-y), nil
+			}
+		case time.Time:
+			if op == "-" {
+				return x.Sub(y), nil
+			}
+		}
+	case time.Duration:
+		switch y := y.(type) {
+		case time.Duration:
+			switch op {
+			case "+":
+				return x + y, nil
+			case "-":
+				return x - y, nil
+			}
+		case time.Time:
+			if op == "+" {
+				return y.Add(x), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("can't apply %s to %s and %s", op, typeOf(x), typeOf(y))
+}
+
+func floatArithmetic(op string, x, y float64) (interface{}, error) {
+	switch op {
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	case "/":
+		if y == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return x / y, nil
+	}
+	return math.Mod(x, y), nil
+}
+
+func (c *checker) checkCond(n *Cond) (Type, evalFunc, error) {
+	ct, cond, err := c.check(n.Cond)
+	if err != nil {
+		return Type{}, nil, err
+	}
+	if !accepts(ct, Bool) {
+		return Type{}, nil, c.errorf(n.Cond, "condition is %s, want bool", ct)
+	}
+	tt, then, err := c.check(n.Then)
+	if err != nil {
+		return Type{}, nil, err
+	}
+	et, els, err := c.check(n.Else)
+	if err != nil {
+		return Type{}, nil, err
+	}
+	t, ok := unify(tt, et)
+	if !ok {
+		return Type{}, nil, c.errorf(n, "branches are %s and %s", tt, et)
+	}
+	return t, func(payload gjson.Result) (interface{}, error) {
+		cv, err := cond(payload)
+		if err != nil {
+			return nil, err
+		}
+		switch cv {
+		case true:
+			return then(payload)
+		case false, nil:
+			return els(payload)
+		}
+		return nil, fmt.Errorf("condition is %s, want bool", typeOf(cv))
+	}, nil
+}
+
+func (c *checker) checkList(n *ListLit) (Type, evalFunc, error) {
+	elem := nullType
+	elems := make([]evalFunc, len(n.Elems))
+	for i, e := range n.Elems {
+		et, eval, err := c.check(e)
+		if err != nil {
+			return Type{}, nil, err
+		}
+		t, ok := unify(elem, et)
+		if !ok {
+			return Type{}, nil, c.errorf(e, "list of %s can't hold %s", elem, et)
+		}
+		elem = t
+		elems[i] = eval
+	}
+	if elem.Kind == Null {
+		elem = dynType
+	}
+	return Type{Kind: List, Elem: &elem}, func(payload gjson.Result) (interface{}, error) {
+		l := make([]interface{}, len(elems))
+		for i, e := range elems {
+			v, err := e(payload)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = v
+		}
+		return l, nil
+	}, nil
+}
+
+// accepts reports whether values of type t may be of one of the kinds.
+func accepts(t Type, kinds ...Kind) bool {
+	if t.Kind == Dyn || t.Kind == Null {
+		return true
+	}
+	for _, k := range kinds {
+		if t.Kind == k {
+			return true
+		}
+	}
+	return false
+}
+
+// unify returns the type of values that are either of type a or b, and
+// whether there is one: ints and floats unify to floats, and null and dyn
+// unify with anything.
+func unify(a, b Type) (Type, bool) {
+	switch {
+	case a.Kind == Null:
+		return b, true
+	case b.Kind == Null:
+		return a, true
+	case a.Kind == Dyn || b.Kind == Dyn:
+		return dynType, true
+	case a.numeric() && b.numeric():
+		if a.Kind == Int && b.Kind == Int {
+			return a, true
+		}
+		return floatType, true
+	case a.Kind != b.Kind:
+		return Type{}, false
+	case a.Kind == List || a.Kind == Map && a.Fields == nil && b.Fields == nil:
+		elem, ok := unify(a.elem(), b.elem())
+		return Type{Kind: a.Kind, Elem: &elem}, ok
+	case a.Kind == Map:
+		return Type{Kind: Map}, true
+	}
+	return a, true
+}
+
+// escapePath escapes name for use as a single gjson path component.
+func escapePath(name string) string {
+	r := strings.NewReplacer(`\`, `\\`, ".", `\.`, "*", `\*`, "?", `\?`, "|", `\|`, "#", `\#`, "@", `\@`, ":", `\:`)
+	return r.Replace(name)
+}
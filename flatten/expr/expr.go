@@ -0,0 +1,88 @@
+// Package expr implements a small expression language over record payloads,
+// with a syntax close to CEL:
+//
+//	user.age >= 18 && !has(deleted_at)
+//	lower(user.email).endsWith("@example.com") ? "internal" : "external"
+//	coalesce(nickname, name) + " <" + email + ">"
+//	timestamp(created_at) > timestamp("2022-01-01T00:00:00Z") - duration("24h")
+//
+// Field names are read from the payload, nested fields with ".", list
+// elements and map values with "[...]". Names that aren't identifiers are
+// quoted with backticks: `user.email` is the flattened key "user.email".
+//
+// An expression is parsed once and checked against the type of the payloads
+// it will read, derived from their Kafka Connect schema with SchemaType or
+// dynamic for records without one. Checking resolves fields and functions and
+// reports type errors, with their line and column, before any record is read.
+// Evaluation then reads only the fields the expression uses.
+//
+// Null is a value of every type. Operators and functions given a null return
+// null, except ==, !=, coalesce and has, and && and || which only return null
+// when the other operand doesn't decide the result.
+package expr
+
+import (
+	"fmt"
+
+	"github.com/tidwall/gjson"
+)
+
+// Program is an expression checked against the type of the payloads it reads.
+type Program struct {
+	typ  Type
+	eval evalFunc
+}
+
+// Compile parses src and checks it against payloads of type payload. The
+// zero Type is dynamic, for payloads without a schema.
+func Compile(src string, payload Type) (*Program, error) {
+	n, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	return Check(n, src, payload)
+}
+
+// Check checks the parsed expression n against payloads of type payload.
+// Errors refer to positions in src, the source n was parsed from.
+func Check(n Node, src string, payload Type) (*Program, error) {
+	c := &checker{src: src, payload: payload}
+	t, eval, err := c.check(n)
+	if err != nil {
+		return nil, err
+	}
+	return &Program{typ: t, eval: eval}, nil
+}
+
+// Type returns the type of the values p evaluates to.
+func (p *Program) Type() Type { return p.typ }
+
+// Eval evaluates p on the JSON payload. The result is nil, a bool, int64,
+// float64, string, time.Time, time.Duration, []interface{} or
+// map[string]interface{}.
+func (p *Program) Eval(payload []byte) (interface{}, error) {
+	return p.eval(gjson.ParseBytes(payload))
+}
+
+// Error is a syntax or type error in an expression.
+type Error struct {
+	Src string
+	Pos int // byte offset in Src
+	Msg string
+}
+
+func (e *Error) Error() string {
+	line, col := 1, 1
+	for i, r := range e.Src {
+		if i >= e.Pos {
+			break
+		}
+		if r == '\n' {
+			line++
+			col = 1
+			continue
+		}
+		col++
+	}
+	return fmt.Sprintf("%d:%d: %s", line, col, e.Msg)
+}
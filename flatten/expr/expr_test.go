@@ -0,0 +1,184 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const testSchema = `{"type": "struct", "fields": [
+	{"field": "id", "type": "int32"},
+	{"field": "price", "type": "double"},
+	{"field": "email", "type": "string", "optional": true},
+	{"field": "tags", "type": "array", "items": {"type": "string"}},
+	{"field": "created_at", "type": "int64", "name": "org.apache.kafka.connect.data.Timestamp"},
+	{"field": "user", "type": "struct", "fields": [
+		{"field": "name", "type": "string"},
+		{"field": "age", "type": "int32", "optional": true}
+	]}
+]}`
+
+const testPayload = `{
+	"id": 7,
+	"price": 2.5,
+	"email": "Alice@Example.com",
+	"tags": ["new", "vip"],
+	"created_at": 1663200000000,
+	"user": {"name": "alice", "age": null},
+	"user.name": "flattened"
+}`
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		{`id * 2 + 1`, int64(15)},
+		{`id / 2`, int64(3)},
+		{`id % 4`, int64(3)},
+		{`price * id`, 17.5},
+		{`-price`, -2.5},
+		{`lower(email)`, "alice@example.com"},
+		{`email.lower().endsWith("@example.com")`, true},
+		{`"x-" + user.name + "-" + string(id)`, "x-alice-7"},
+		{`size(tags) == 2 && tags[1] == "vip"`, true},
+		{`tags[5]`, nil},
+		{`"vip" in tags`, true},
+		{`id in [1, 2, 3]`, false},
+		{`user.age + 1`, nil},
+		{`user.age > 18`, nil},
+		{`user.age == null`, true},
+		{`has(user.age)`, false},
+		{`has(user.name)`, true},
+		{`coalesce(user.age, id, 0)`, int64(7)},
+		{`user.age > 18 || id == 7`, true},
+		{`user.age > 18 && id == 8`, false},
+		{`user.age > 18 && id == 7`, nil},
+		{`id > 5 ? "big" : "small"`, "big"},
+		{`user.age > 5 ? "old" : "young"`, "young"},
+		{`like(email, "%@Example.___")`, true},
+		{`email.matches("^[A-Z]")`, true},
+		{`substring(email, 0, 5)`, "Alice"},
+		{`replace(user.name, "a", "A")`, "Alice"},
+		{`year(created_at)`, int64(2022)},
+		{`hour(created_at, "America/New_York")`, int64(20)},
+		{`formatTime(created_at, "2006-01-02")`, "2022-09-15"},
+		{`created_at > timestamp("2022-09-01T00:00:00Z")`, true},
+		{`created_at - timestamp("2022-09-14T00:00:00Z")`, 24 * time.Hour},
+		{`created_at + duration("1h") == timestamp("2022-09-15T01:00:00Z")`, true},
+		{`int("42") + int(3.9)`, int64(45)},
+		{`float(id) / 2.0`, 3.5},
+		{`round(price)`, 3.0},
+		{`abs(-id)`, int64(7)},
+	}
+
+	payload := SchemaType(gjson.Parse(testSchema))
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			p, err := Compile(tt.src, payload)
+			if err != nil {
+				t.Fatalf("want no error, got %s", err)
+			}
+			got, err := p.Eval([]byte(testPayload))
+			if err != nil {
+				t.Fatalf("want no error, got %s", err)
+			}
+			if !equal(got, tt.want) || typeOf(got).Kind != typeOf(tt.want).Kind {
+				t.Fatalf("want %#v, got %#v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEvalWithoutSchema(t *testing.T) {
+	p, err := Compile("user.name + \":\" + `user.name` + \":\" + string(id + 1)", Type{})
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	got, err := p.Eval([]byte(testPayload))
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	if got != "alice:flattened:8" {
+		t.Fatalf("want alice:flattened:8, got %#v", got)
+	}
+
+	p, err = Compile(`email + id`, Type{})
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	_, err = p.Eval([]byte(testPayload))
+	if err == nil || !strings.Contains(err.Error(), "can't apply + to string and int") {
+		t.Fatalf("want error adding string and int, got %v", err)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`id +`, "1:5: unexpected end of expression"},
+		{`id == "a`, "1:7: unterminated string"},
+		{`(id`, `1:4: want ")", got end of expression`},
+		{`id # 2`, `1:4: unexpected '#'`},
+		{`user.nam == "a"`, "1:6: user.nam: unknown field nam"},
+		{`emial`, "1:1: emial: unknown field emial"},
+		{"id > 1 &&\n  email > 2", "2:9: can't compare string and int"},
+		{`email.name`, "1:7: email.name: string has no fields"},
+		{`lowr(email)`, "1:1: unknown function lowr"},
+		{`lower(id)`, "1:7: argument 1 of lower is int, want string"},
+		{`substring(email)`, "1:1: substring needs 2 or 3 arguments, got 1"},
+		{`id ? 1 : 2`, "1:1: condition is int, want bool"},
+		{`id > 1 ? "a" : 2`, "1:8: branches are string and int"},
+		{`[1, "a"]`, `1:5: list of int can't hold string`},
+		{`has(lower(email))`, "1:5: has needs a field"},
+		{`created_at + 1`, "1:12: can't apply + to timestamp and int"},
+	}
+
+	payload := SchemaType(gjson.Parse(testSchema))
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			_, err := Compile(tt.src, payload)
+			if err == nil || err.Error() != tt.want {
+				t.Fatalf("want error %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	p, err := Compile(`id / (id - 7)`, SchemaType(gjson.Parse(testSchema)))
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	_, err = p.Eval([]byte(testPayload))
+	if err == nil || err.Error() != "division by zero" {
+		t.Fatalf("want division by zero, got %v", err)
+	}
+}
+
+func TestConnectSchema(t *testing.T) {
+	p, err := Compile(`[created_at]`, SchemaType(gjson.Parse(testSchema)))
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	s := ConnectSchema(p.Type())
+	items := s["items"].(map[string]interface{})
+	if s["type"] != "array" || items["type"] != "int64" || items["name"] != "org.apache.kafka.connect.data.Timestamp" {
+		t.Fatalf("want array of timestamps, got %v", s)
+	}
+
+	v, err := p.Eval([]byte(testPayload))
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	if got := Encode(v, true).([]interface{})[0]; got != int64(1663200000000) {
+		t.Fatalf("want 1663200000000, got %v", got)
+	}
+	if got := Encode(v, false).([]interface{})[0]; got != "2022-09-15T00:00:00Z" {
+		t.Fatalf("want 2022-09-15T00:00:00Z, got %v", got)
+	}
+}
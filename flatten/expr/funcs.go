@@ -0,0 +1,524 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/tidwall/gjson"
+)
+
+// function is a built-in function.
+type function struct {
+	// params are the kinds accepted by each parameter, the last one
+	// repeating for variadic functions.
+	params   [][]Kind
+	optional int // number of trailing params that may be left out
+	variadic bool
+
+	// result returns the type of a call with arguments of the given types.
+	result func(args []Type) (Type, error)
+
+	// call is called with the values of the arguments. Unless nullable is
+	// set, calls with a null argument return null without calling it.
+	call     func(args []interface{}) (interface{}, error)
+	nullable bool
+}
+
+func returns(t Type) func([]Type) (Type, error) {
+	return func([]Type) (Type, error) { return t, nil }
+}
+
+func sameAsFirst(args []Type) (Type, error) { return args[0], nil }
+
+var (
+	anything = []Kind{Dyn}
+	str      = []Kind{String}
+	integer  = []Kind{Int}
+	number   = []Kind{Int, Float}
+	instant  = []Kind{Timestamp}
+	sizeable = []Kind{String, List, Map}
+)
+
+var functions map[string]function
+
+func init() {
+	functions = map[string]function{
+		"lower":      {params: [][]Kind{str}, result: returns(stringType), call: stringFunc(strings.ToLower)},
+		"upper":      {params: [][]Kind{str}, result: returns(stringType), call: stringFunc(strings.ToUpper)},
+		"trim":       {params: [][]Kind{str}, result: returns(stringType), call: stringFunc(strings.TrimSpace)},
+		"size":       {params: [][]Kind{sizeable}, result: returns(intType), call: size},
+		"contains":   {params: [][]Kind{str, str}, result: returns(boolType), call: stringPredicate(strings.Contains)},
+		"startsWith": {params: [][]Kind{str, str}, result: returns(boolType), call: stringPredicate(strings.HasPrefix)},
+		"endsWith":   {params: [][]Kind{str, str}, result: returns(boolType), call: stringPredicate(strings.HasSuffix)},
+		"matches":    {params: [][]Kind{str, str}, result: returns(boolType), call: matcher(regexp.Compile)},
+		"like":       {params: [][]Kind{str, str}, result: returns(boolType), call: matcher(likeRegexp)},
+		"replace":    {params: [][]Kind{str, str, str}, result: returns(stringType), call: replace},
+		"substring":  {params: [][]Kind{str, integer, integer}, optional: 1, result: returns(stringType), call: substring},
+
+		"coalesce": {params: [][]Kind{anything}, variadic: true, result: coalesceType, call: coalesce, nullable: true},
+		"has":      {params: [][]Kind{anything}, result: returns(boolType), call: has, nullable: true},
+
+		"abs":   {params: [][]Kind{number}, result: sameAsFirst, call: abs},
+		"ceil":  {params: [][]Kind{number}, result: sameAsFirst, call: rounder(math.Ceil)},
+		"floor": {params: [][]Kind{number}, result: sameAsFirst, call: rounder(math.Floor)},
+		"round": {params: [][]Kind{number}, result: sameAsFirst, call: rounder(math.Round)},
+
+		"string":    {params: [][]Kind{anything}, result: returns(stringType), call: toString},
+		"bool":      {params: [][]Kind{{Bool, Int, String}}, result: returns(boolType), call: toBool},
+		"int":       {params: [][]Kind{{Bool, Int, Float, String, Timestamp, Duration}}, result: returns(intType), call: toInt},
+		"float":     {params: [][]Kind{{Int, Float, String}}, result: returns(floatType), call: toFloat},
+		"timestamp": {params: [][]Kind{{Int, String, Timestamp}}, result: returns(timestampType), call: toTimestamp},
+		"duration":  {params: [][]Kind{{Int, String, Duration}}, result: returns(durationType), call: toDuration},
+
+		"year":       {params: [][]Kind{instant, str}, optional: 1, result: returns(intType), call: timePart(func(t time.Time) int { return t.Year() })},
+		"month":      {params: [][]Kind{instant, str}, optional: 1, result: returns(intType), call: timePart(func(t time.Time) int { return int(t.Month()) })},
+		"day":        {params: [][]Kind{instant, str}, optional: 1, result: returns(intType), call: timePart(time.Time.Day)},
+		"hour":       {params: [][]Kind{instant, str}, optional: 1, result: returns(intType), call: timePart(time.Time.Hour)},
+		"minute":     {params: [][]Kind{instant, str}, optional: 1, result: returns(intType), call: timePart(time.Time.Minute)},
+		"second":     {params: [][]Kind{instant, str}, optional: 1, result: returns(intType), call: timePart(time.Time.Second)},
+		"weekday":    {params: [][]Kind{instant, str}, optional: 1, result: returns(intType), call: timePart(func(t time.Time) int { return int(t.Weekday()) })},
+		"formatTime": {params: [][]Kind{instant, str, str}, optional: 1, result: returns(stringType), call: formatTime},
+	}
+}
+
+// Functions returns the names of the built-in functions.
+func Functions() []string {
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *checker) checkCall(n *Call) (Type, evalFunc, error) {
+	f, ok := functions[n.Name]
+	if !ok {
+		return Type{}, nil, c.errorf(n, "unknown function %s", n.Name)
+	}
+	min, max := len(f.params)-f.optional, len(f.params)
+	switch {
+	case f.variadic && len(n.Args) < min:
+		return Type{}, nil, c.errorf(n, "%s needs at least %d arguments, got %d", n.Name, min, len(n.Args))
+	case !f.variadic && (len(n.Args) < min || len(n.Args) > max):
+		want := strconv.Itoa(max)
+		if min < max {
+			want = fmt.Sprintf("%d or %d", min, max)
+		}
+		return Type{}, nil, c.errorf(n, "%s needs %s arguments, got %d", n.Name, want, len(n.Args))
+	}
+	if n.Name == "has" {
+		if _, _, ok := fieldPath(n.Args[0]); !ok {
+			return Type{}, nil, c.errorf(n.Args[0], "has needs a field")
+		}
+	}
+
+	types := make([]Type, len(n.Args))
+	args := make([]evalFunc, len(n.Args))
+	for i, a := range n.Args {
+		t, eval, err := c.check(a)
+		if err != nil {
+			return Type{}, nil, err
+		}
+		kinds := f.params[len(f.params)-1]
+		if i < len(f.params) {
+			kinds = f.params[i]
+		}
+		if kinds[0] != Dyn && !accepts(t, kinds...) {
+			return Type{}, nil, c.errorf(a, "argument %d of %s is %s, want %s", i+1, n.Name, t, kindList(kinds))
+		}
+		types[i], args[i] = t, eval
+	}
+	t, err := f.result(types)
+	if err != nil {
+		return Type{}, nil, c.errorf(n, "%s: %s", n.Name, err)
+	}
+
+	name := n.Name
+	return t, func(payload gjson.Result) (interface{}, error) {
+		values := make([]interface{}, len(args))
+		for i, a := range args {
+			v, err := a(payload)
+			if err != nil {
+				return nil, err
+			}
+			if v == nil && !f.nullable {
+				return nil, nil
+			}
+			values[i] = v
+		}
+		v, err := f.call(values)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return v, nil
+	}, nil
+}
+
+func kindList(kinds []Kind) string {
+	names := make([]string, len(kinds))
+	for i, k := range kinds {
+		names[i] = k.String()
+	}
+	return strings.Join(names, " or ")
+}
+
+func stringFunc(f func(string) string) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		s, err := stringArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return f(s), nil
+	}
+}
+
+func stringPredicate(f func(s, t string) bool) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		s, err := stringArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		t, err := stringArg(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return f(s, t), nil
+	}
+}
+
+func stringArg(v interface{}) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("want string, got %s", typeOf(v))
+	}
+	return s, nil
+}
+
+func intArg(v interface{}) (int64, error) {
+	n, ok := v.(int64)
+	if !ok {
+		return 0, fmt.Errorf("want int, got %s", typeOf(v))
+	}
+	return n, nil
+}
+
+func timeArg(v interface{}) (time.Time, error) {
+	t, ok := v.(time.Time)
+	if !ok {
+		return time.Time{}, fmt.Errorf("want timestamp, got %s", typeOf(v))
+	}
+	return t, nil
+}
+
+func size(args []interface{}) (interface{}, error) {
+	switch v := args[0].(type) {
+	case string:
+		return int64(utf8.RuneCountInString(v)), nil
+	case []interface{}:
+		return int64(len(v)), nil
+	case map[string]interface{}:
+		return int64(len(v)), nil
+	}
+	return nil, fmt.Errorf("want string, list or map, got %s", typeOf(args[0]))
+}
+
+// matcher returns the implementation of a function matching a string with
+// a pattern compiled by compile. Compiled patterns are cached, as they
+// usually are constants.
+func matcher(compile func(string) (*regexp.Regexp, error)) func([]interface{}) (interface{}, error) {
+	var cache sync.Map
+	return func(args []interface{}) (interface{}, error) {
+		s, err := stringArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		pattern, err := stringArg(args[1])
+		if err != nil {
+			return nil, err
+		}
+		re, ok := cache.Load(pattern)
+		if !ok {
+			compiled, err := compile(pattern)
+			if err != nil {
+				return nil, err
+			}
+			re, _ = cache.LoadOrStore(pattern, compiled)
+		}
+		return re.(*regexp.Regexp).MatchString(s), nil
+	}
+}
+
+// likeRegexp compiles an SQL LIKE pattern, where % matches any string and _
+// any character.
+func likeRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("(?s)^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+func replace(args []interface{}) (interface{}, error) {
+	var s [3]string
+	for i := range s {
+		var err error
+		if s[i], err = stringArg(args[i]); err != nil {
+			return nil, err
+		}
+	}
+	return strings.ReplaceAll(s[0], s[1], s[2]), nil
+}
+
+// substring returns the characters of s from start to end, or to the end of
+// s, counting from 0. Out of range bounds are clamped.
+func substring(args []interface{}) (interface{}, error) {
+	s, err := stringArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+	r := []rune(s)
+	start, err := intArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+	end := int64(len(r))
+	if len(args) > 2 {
+		if end, err = intArg(args[2]); err != nil {
+			return nil, err
+		}
+	}
+	clamp := func(i int64) int64 {
+		if i < 0 {
+			return 0
+		}
+		if i > int64(len(r)) {
+			return int64(len(r))
+		}
+		return i
+	}
+	start, end = clamp(start), clamp(end)
+	if start >= end {
+		return "", nil
+	}
+	return string(r[start:end]), nil
+}
+
+func coalesceType(args []Type) (Type, error) {
+	t := nullType
+	for _, a := range args {
+		u, ok := unify(t, a)
+		if !ok {
+			return Type{}, fmt.Errorf("can't mix %s and %s", t, a)
+		}
+		t = u
+	}
+	return t, nil
+}
+
+func coalesce(args []interface{}) (interface{}, error) {
+	for _, v := range args {
+		if v != nil {
+			return v, nil
+		}
+	}
+	return nil, nil
+}
+
+func has(args []interface{}) (interface{}, error) { return args[0] != nil, nil }
+
+func abs(args []interface{}) (interface{}, error) {
+	switch v := args[0].(type) {
+	case int64:
+		if v < 0 {
+			return -v, nil
+		}
+		return v, nil
+	case float64:
+		return math.Abs(v), nil
+	}
+	return nil, fmt.Errorf("want number, got %s", typeOf(args[0]))
+}
+
+func rounder(f func(float64) float64) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		switch v := args[0].(type) {
+		case int64:
+			return v, nil
+		case float64:
+			return f(v), nil
+		}
+		return nil, fmt.Errorf("want number, got %s", typeOf(args[0]))
+	}
+}
+
+func toString(args []interface{}) (interface{}, error) {
+	switch v := args[0].(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case time.Duration:
+		return v.String(), nil
+	}
+	return nil, fmt.Errorf("can't convert %s to string", typeOf(args[0]))
+}
+
+func toBool(args []interface{}) (interface{}, error) {
+	switch v := args[0].(type) {
+	case bool:
+		return v, nil
+	case int64:
+		return v != 0, nil
+	case string:
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid bool %q", v)
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("can't convert %s to bool", typeOf(args[0]))
+}
+
+func toInt(args []interface{}) (interface{}, error) {
+	switch v := args[0].(type) {
+	case bool:
+		if v {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case int64:
+		return v, nil
+	case float64:
+		if math.IsNaN(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			return nil, fmt.Errorf("%g out of range", v)
+		}
+		return int64(v), nil
+	case string:
+		n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid int %q", v)
+		}
+		return n, nil
+	case time.Time:
+		return v.Unix(), nil
+	case time.Duration:
+		return int64(v / time.Second), nil
+	}
+	return nil, fmt.Errorf("can't convert %s to int", typeOf(args[0]))
+}
+
+func toFloat(args []interface{}) (interface{}, error) {
+	switch v := args[0].(type) {
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid float %q", v)
+		}
+		return f, nil
+	}
+	return nil, fmt.Errorf("can't convert %s to float", typeOf(args[0]))
+}
+
+// toTimestamp converts RFC 3339 strings and seconds since the epoch to
+// timestamps.
+func toTimestamp(args []interface{}) (interface{}, error) {
+	switch v := args[0].(type) {
+	case time.Time:
+		return v, nil
+	case int64:
+		return time.Unix(v, 0).UTC(), nil
+	case string:
+		return parseTimestamp(v)
+	}
+	return nil, fmt.Errorf("can't convert %s to timestamp", typeOf(args[0]))
+}
+
+// toDuration converts strings such as "1h30m" and seconds to durations.
+func toDuration(args []interface{}) (interface{}, error) {
+	switch v := args[0].(type) {
+	case time.Duration:
+		return v, nil
+	case int64:
+		return time.Duration(v) * time.Second, nil
+	case string:
+		return time.ParseDuration(v)
+	}
+	return nil, fmt.Errorf("can't convert %s to duration", typeOf(args[0]))
+}
+
+// timePart returns the implementation of a function returning a part of a
+// timestamp, in UTC or in the time zone given as second argument.
+func timePart(part func(time.Time) int) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		t, err := inZone(args)
+		if err != nil {
+			return nil, err
+		}
+		return int64(part(t)), nil
+	}
+}
+
+// formatTime formats a timestamp with a Go time layout, in UTC or in the
+// time zone given as third argument.
+func formatTime(args []interface{}) (interface{}, error) {
+	layout, err := stringArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+	t, err := inZone(append([]interface{}{args[0]}, args[2:]...))
+	if err != nil {
+		return nil, err
+	}
+	return t.Format(layout), nil
+}
+
+var zones sync.Map
+
+// inZone returns the timestamp args[0] in UTC, or in the IANA time zone
+// args[1] if there is one.
+func inZone(args []interface{}) (time.Time, error) {
+	t, err := timeArg(args[0])
+	if err != nil || len(args) < 2 {
+		return t.UTC(), err
+	}
+	name, err := stringArg(args[1])
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, ok := zones.Load(name)
+	if !ok {
+		l, err := time.LoadLocation(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		loc, _ = zones.LoadOrStore(name, l)
+	}
+	return t.In(loc.(*time.Location)), nil
+}
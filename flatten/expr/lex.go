@@ -0,0 +1,158 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokInt
+	tokFloat
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string // operator, identifier, number or unquoted string
+	pos  int
+
+	quoted bool // a quoted identifier, never a keyword
+}
+
+// operators lists the operators and punctuation, longest first.
+var operators = []string{
+	"==", "!=", "<=", ">=", "&&", "||",
+	"+", "-", "*", "/", "%", "<", ">", "!", "?", ":", "(", ")", "[", "]", ",", ".",
+}
+
+// lex splits src into tokens, ending with a tokEOF token.
+func lex(src string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for {
+		for i < len(src) {
+			r, n := utf8.DecodeRuneInString(src[i:])
+			if !unicode.IsSpace(r) {
+				break
+			}
+			i += n
+		}
+		if i == len(src) {
+			return append(tokens, token{kind: tokEOF, pos: i}), nil
+		}
+
+		start := i
+		c := src[i]
+		switch {
+		case c == '_' || isLetter(c):
+			for i < len(src) && (src[i] == '_' || isLetter(src[i]) || isDigit(src[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: src[start:i], pos: start})
+
+		case c == '`':
+			// Quoted identifiers hold field names that aren't identifiers,
+			// such as flattened keys: `user.email`.
+			end := strings.IndexByte(src[i+1:], '`')
+			if end < 0 {
+				return nil, &Error{Src: src, Pos: start, Msg: "unterminated quoted identifier"}
+			}
+			i += end + 2
+			tokens = append(tokens, token{kind: tokIdent, text: src[start+1 : i-1], pos: start, quoted: true})
+
+		case isDigit(c):
+			kind := tokInt
+			for i < len(src) && isDigit(src[i]) {
+				i++
+			}
+			if i+1 < len(src) && src[i] == '.' && isDigit(src[i+1]) {
+				kind = tokFloat
+				i++
+				for i < len(src) && isDigit(src[i]) {
+					i++
+				}
+			}
+			if i < len(src) && (src[i] == 'e' || src[i] == 'E') {
+				j := i + 1
+				if j < len(src) && (src[j] == '+' || src[j] == '-') {
+					j++
+				}
+				if j < len(src) && isDigit(src[j]) {
+					kind = tokFloat
+					i = j
+					for i < len(src) && isDigit(src[i]) {
+						i++
+					}
+				}
+			}
+			tokens = append(tokens, token{kind: kind, text: src[start:i], pos: start})
+
+		case c == '"' || c == '\'':
+			s, n, err := unquote(src[i:])
+			if err != nil {
+				return nil, &Error{Src: src, Pos: start, Msg: err.Error()}
+			}
+			i += n
+			tokens = append(tokens, token{kind: tokString, text: s, pos: start})
+
+		default:
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				r, _ := utf8.DecodeRuneInString(src[i:])
+				return nil, &Error{Src: src, Pos: start, Msg: fmt.Sprintf("unexpected %q", r)}
+			}
+			i += len(op)
+			tokens = append(tokens, token{kind: tokOp, text: op, pos: start})
+		}
+	}
+}
+
+// unquote reads the string literal at the start of s, quoted with ' or ",
+// and returns its value and length.
+func unquote(s string) (string, int, error) {
+	quote := s[0]
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case quote:
+			return b.String(), i + 1, nil
+		case '\\':
+			i++
+			if i == len(s) {
+				break
+			}
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '\\', '\'', '"':
+				b.WriteByte(s[i])
+			default:
+				return "", 0, fmt.Errorf("unknown escape \\%c", s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+func isLetter(c byte) bool { return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' }
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
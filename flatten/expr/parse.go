@@ -0,0 +1,340 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Node is a node of a parsed expression.
+type Node interface {
+	Pos() int
+}
+
+type (
+	// Literal is a null, boolean, number or string literal.
+	Literal struct {
+		At    int
+		Value interface{} // nil, bool, int64, float64 or string
+	}
+
+	// Ident is a top-level field of the payload.
+	Ident struct {
+		At   int
+		Name string
+	}
+
+	// Select is the field Field of X.
+	Select struct {
+		At    int
+		X     Node
+		Field string
+	}
+
+	// Index is the element Index of the list X, or the value at the key
+	// Index of the map X.
+	Index struct {
+		At       int
+		X, Index Node
+	}
+
+	// Unary is a "!" or "-" operation.
+	Unary struct {
+		At int
+		Op string
+		X  Node
+	}
+
+	// Binary is an arithmetic, comparison, logical or "in" operation.
+	Binary struct {
+		At   int
+		Op   string
+		X, Y Node
+	}
+
+	// Cond is a "Cond ? Then : Else" expression.
+	Cond struct {
+		At               int
+		Cond, Then, Else Node
+	}
+
+	// Call is a call of the function Name. Method calls such as
+	// name.startsWith("a") are calls with the receiver as first argument.
+	Call struct {
+		At   int
+		Name string
+		Args []Node
+	}
+
+	// ListLit is a list literal.
+	ListLit struct {
+		At    int
+		Elems []Node
+	}
+)
+
+func (n *Literal) Pos() int { return n.At }
+func (n *Ident) Pos() int   { return n.At }
+func (n *Select) Pos() int  { return n.At }
+func (n *Index) Pos() int   { return n.At }
+func (n *Unary) Pos() int   { return n.At }
+func (n *Binary) Pos() int  { return n.At }
+func (n *Cond) Pos() int    { return n.At }
+func (n *Call) Pos() int    { return n.At }
+func (n *ListLit) Pos() int { return n.At }
+
+// Binary operator precedences, loosest first.
+var precedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3, "<": 3, "<=": 3, ">": 3, ">=": 3, "in": 3,
+	"+": 4, "-": 4,
+	"*": 5, "/": 5, "%": 5,
+}
+
+// Parse parses the expression src.
+func Parse(src string) (Node, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{src: src, tokens: tokens}
+	n, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected %s", describe(t))
+	}
+	return n, nil
+}
+
+type parser struct {
+	src    string
+	tokens []token
+	i      int
+}
+
+func (p *parser) peek() token { return p.tokens[p.i] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) isOp(op string) bool {
+	t := p.peek()
+	return t.kind == tokOp && t.text == op
+}
+
+func (p *parser) expect(op string) error {
+	t := p.next()
+	if t.kind != tokOp || t.text != op {
+		return p.errorf(t, "want %q, got %s", op, describe(t))
+	}
+	return nil
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	return &Error{Src: p.src, Pos: t.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// expr parses a conditional expression, the loosest form.
+func (p *parser) expr() (Node, error) {
+	cond, err := p.binary(1)
+	if err != nil {
+		return nil, err
+	}
+	if !p.isOp("?") {
+		return cond, nil
+	}
+	at := p.next().pos
+	then, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	els, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	return &Cond{At: at, Cond: cond, Then: then, Else: els}, nil
+}
+
+// binary parses binary operations binding at least as tightly as prec.
+func (p *parser) binary(prec int) (Node, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		op := ""
+		if t.kind == tokOp || t.kind == tokIdent && !t.quoted && t.text == "in" {
+			op = t.text
+		}
+		opPrec, ok := precedence[op]
+		if !ok || opPrec < prec {
+			return x, nil
+		}
+		p.next()
+		y, err := p.binary(opPrec + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = &Binary{At: t.pos, Op: op, X: x, Y: y}
+	}
+}
+
+func (p *parser) unary() (Node, error) {
+	if p.isOp("!") || p.isOp("-") {
+		t := p.next()
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &Unary{At: t.pos, Op: t.text, X: x}, nil
+	}
+	return p.postfix()
+}
+
+func (p *parser) postfix() (Node, error) {
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.isOp("."):
+			p.next()
+			t := p.next()
+			if t.kind != tokIdent {
+				return nil, p.errorf(t, "want field name, got %s", describe(t))
+			}
+			if !t.quoted && p.isOp("(") {
+				args, err := p.args()
+				if err != nil {
+					return nil, err
+				}
+				x = &Call{At: t.pos, Name: t.text, Args: append([]Node{x}, args...)}
+				continue
+			}
+			x = &Select{At: t.pos, X: x, Field: t.text}
+
+		case p.isOp("["):
+			at := p.next().pos
+			index, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = &Index{At: at, X: x, Index: index}
+
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *parser) primary() (Node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokInt:
+		v, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			return nil, p.errorf(t, "integer %s out of range", t.text)
+		}
+		return &Literal{At: t.pos, Value: v}, nil
+
+	case tokFloat:
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf(t, "invalid number %s", t.text)
+		}
+		return &Literal{At: t.pos, Value: v}, nil
+
+	case tokString:
+		return &Literal{At: t.pos, Value: t.text}, nil
+
+	case tokIdent:
+		if t.quoted {
+			return &Ident{At: t.pos, Name: t.text}, nil
+		}
+		switch t.text {
+		case "null":
+			return &Literal{At: t.pos}, nil
+		case "true", "false":
+			return &Literal{At: t.pos, Value: t.text == "true"}, nil
+		}
+		if p.isOp("(") {
+			args, err := p.args()
+			if err != nil {
+				return nil, err
+			}
+			return &Call{At: t.pos, Name: t.text, Args: args}, nil
+		}
+		return &Ident{At: t.pos, Name: t.text}, nil
+
+	case tokOp:
+		switch t.text {
+		case "(":
+			x, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		case "[":
+			l := &ListLit{At: t.pos}
+			for !p.isOp("]") {
+				x, err := p.expr()
+				if err != nil {
+					return nil, err
+				}
+				l.Elems = append(l.Elems, x)
+				if !p.isOp(",") {
+					break
+				}
+				p.next()
+			}
+			return l, p.expect("]")
+		}
+	}
+	return nil, p.errorf(t, "unexpected %s", describe(t))
+}
+
+// args parses a parenthesized argument list.
+func (p *parser) args() ([]Node, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []Node
+	for !p.isOp(")") {
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, x)
+		if !p.isOp(",") {
+			break
+		}
+		p.next()
+	}
+	return args, p.expect(")")
+}
+
+func describe(t token) string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return strconv.Quote(t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
@@ -0,0 +1,181 @@
+package expr
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// Kind is the kind of a Type.
+type Kind int
+
+const (
+	// Dyn is the kind of values whose type is only known at run time, such
+	// as the fields of records without a schema.
+	Dyn Kind = iota
+	Null
+	Bool
+	Int
+	Float
+	String
+	Timestamp
+	Duration
+	List
+	Map
+)
+
+var kindNames = [...]string{"dyn", "null", "bool", "int", "float", "string", "timestamp", "duration", "list", "map"}
+
+func (k Kind) String() string { return kindNames[k] }
+
+// Type is the static type of an expression.
+type Type struct {
+	Kind Kind
+
+	// Elem is the type of the elements of a List or the values of a Map,
+	// nil for Dyn.
+	Elem *Type
+
+	// Fields are the types of the fields of a Map read from a struct schema,
+	// nil for maps whose keys aren't known.
+	Fields map[string]Type
+
+	// unit is the duration of 1 in integer timestamps, 0 for milliseconds.
+	unit int64
+}
+
+var (
+	dynType       = Type{Kind: Dyn}
+	nullType      = Type{Kind: Null}
+	boolType      = Type{Kind: Bool}
+	intType       = Type{Kind: Int}
+	floatType     = Type{Kind: Float}
+	stringType    = Type{Kind: String}
+	timestampType = Type{Kind: Timestamp}
+	durationType  = Type{Kind: Duration}
+)
+
+func (t Type) String() string {
+	switch t.Kind {
+	case List:
+		if t.Elem != nil {
+			return "list(" + t.Elem.String() + ")"
+		}
+	case Map:
+		if t.Fields != nil {
+			names := make([]string, 0, len(t.Fields))
+			for name, f := range t.Fields {
+				names = append(names, name+": "+f.String())
+			}
+			sort.Strings(names)
+			return "{" + strings.Join(names, ", ") + "}"
+		}
+		if t.Elem != nil {
+			return "map(" + t.Elem.String() + ")"
+		}
+	}
+	return t.Kind.String()
+}
+
+// elem returns the type of the elements of a list or map type.
+func (t Type) elem() Type {
+	if t.Elem == nil {
+		return dynType
+	}
+	return *t.Elem
+}
+
+func (t Type) numeric() bool { return t.Kind == Int || t.Kind == Float }
+
+// Logical types of Kafka Connect and Debezium read as timestamps, with the
+// unit of their integer values. String timestamps are RFC 3339.
+var timestampUnits = map[string]int64{
+	"org.apache.kafka.connect.data.Timestamp": 0,
+	"org.apache.kafka.connect.data.Date":      86400e9,
+	"io.debezium.time.Timestamp":              0,
+	"io.debezium.time.MicroTimestamp":         1e3,
+	"io.debezium.time.NanoTimestamp":          1,
+	"io.debezium.time.Date":                   86400e9,
+	"io.debezium.time.ZonedTimestamp":         0,
+}
+
+// SchemaType returns the type of the values described by the Kafka Connect
+// schema s, as found in the "schema" field of records.
+func SchemaType(s gjson.Result) Type {
+	name := s.Get("name").String()
+	if unit, ok := timestampUnits[name]; ok {
+		return Type{Kind: Timestamp, unit: unit}
+	}
+	if name == "org.apache.kafka.connect.data.Time" {
+		return durationType
+	}
+	switch s.Get("type").String() {
+	case "boolean":
+		return boolType
+	case "int8", "int16", "int32", "int64":
+		return intType
+	case "float", "float32", "double", "float64":
+		return floatType
+	case "string", "bytes":
+		return stringType
+	case "array":
+		elem := SchemaType(s.Get("items"))
+		return Type{Kind: List, Elem: &elem}
+	case "map":
+		elem := SchemaType(s.Get("values"))
+		return Type{Kind: Map, Elem: &elem}
+	case "struct":
+		t := Type{Kind: Map, Fields: map[string]Type{}}
+		s.Get("fields").ForEach(func(_, f gjson.Result) bool {
+			t.Fields[f.Get("field").String()] = SchemaType(f)
+			return true
+		})
+		return t
+	}
+	return dynType
+}
+
+// ConnectSchema returns the Kafka Connect schema of values of type t, as
+// encoded by Encode. Dyn and null values are written as strings.
+func ConnectSchema(t Type) map[string]interface{} {
+	s := map[string]interface{}{"optional": true}
+	switch t.Kind {
+	case Bool:
+		s["type"] = "boolean"
+	case Int:
+		s["type"] = "int64"
+	case Float:
+		s["type"] = "double"
+	case Timestamp:
+		s["type"] = "int64"
+		s["name"] = "org.apache.kafka.connect.data.Timestamp"
+		s["version"] = 1
+	case List:
+		s["type"] = "array"
+		s["items"] = ConnectSchema(t.elem())
+	case Map:
+		if t.Fields == nil {
+			s["type"] = "map"
+			s["keys"] = map[string]interface{}{"type": "string"}
+			s["values"] = ConnectSchema(t.elem())
+			break
+		}
+		names := make([]string, 0, len(t.Fields))
+		for name := range t.Fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fields := make([]interface{}, len(names))
+		for i, name := range names {
+			f := ConnectSchema(t.Fields[name])
+			f["field"] = name
+			fields[i] = f
+		}
+		s["type"] = "struct"
+		s["fields"] = fields
+	default:
+		s["type"] = "string"
+	}
+	return s
+}
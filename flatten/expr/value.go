@@ -0,0 +1,233 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// Values are nil, bool, int64, float64, string, time.Time, time.Duration,
+// []interface{} or map[string]interface{}.
+
+// fromJSON converts the JSON value r to a value of type t. Numbers are read
+// as timestamps or durations when the schema says so.
+func fromJSON(r gjson.Result, t Type) (interface{}, error) {
+	switch r.Type {
+	case gjson.Null:
+		return nil, nil
+	case gjson.True:
+		return true, nil
+	case gjson.False:
+		return false, nil
+	case gjson.String:
+		switch t.Kind {
+		case Timestamp:
+			return parseTimestamp(r.Str)
+		case Duration:
+			return time.ParseDuration(r.Str)
+		}
+		return r.Str, nil
+	case gjson.Number:
+		switch t.Kind {
+		case Timestamp:
+			unit := t.unit
+			if unit == 0 {
+				unit = 1e6
+			}
+			return time.Unix(0, r.Int()*unit).UTC(), nil
+		case Duration:
+			return time.Duration(r.Int()) * time.Millisecond, nil
+		case Float:
+			return r.Num, nil
+		case Int:
+			return r.Int(), nil
+		}
+		if strings.ContainsAny(r.Raw, ".eE") {
+			return r.Num, nil
+		}
+		n, err := strconv.ParseInt(r.Raw, 10, 64)
+		if err != nil {
+			return r.Num, nil
+		}
+		return n, nil
+	}
+
+	if r.IsArray() {
+		elem := t.elem()
+		var l []interface{}
+		var err error
+		r.ForEach(func(_, v gjson.Result) bool {
+			var e interface{}
+			e, err = fromJSON(v, elem)
+			l = append(l, e)
+			return err == nil
+		})
+		return l, err
+	}
+	m := map[string]interface{}{}
+	var err error
+	r.ForEach(func(k, v gjson.Result) bool {
+		ft, ok := t.Fields[k.Str]
+		if !ok {
+			ft = t.elem()
+		}
+		m[k.Str], err = fromJSON(v, ft)
+		return err == nil
+	})
+	return m, err
+}
+
+// Encode converts v to a value encoding/json writes as JSON. With connect
+// set, timestamps are written as Kafka Connect timestamps, in milliseconds
+// since the epoch, and otherwise as RFC 3339 strings. Durations are written
+// as strings such as "1h30m0s".
+func Encode(v interface{}, connect bool) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		if connect {
+			return v.UnixMilli()
+		}
+		return v.UTC().Format(time.RFC3339Nano)
+	case time.Duration:
+		return v.String()
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			l[i] = Encode(e, connect)
+		}
+		return l
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = Encode(e, connect)
+		}
+		return m
+	}
+	return v
+}
+
+// typeOf returns the type of the value v.
+func typeOf(v interface{}) Type {
+	switch v.(type) {
+	case nil:
+		return nullType
+	case bool:
+		return boolType
+	case int64:
+		return intType
+	case float64:
+		return floatType
+	case string:
+		return stringType
+	case time.Time:
+		return timestampType
+	case time.Duration:
+		return durationType
+	case []interface{}:
+		return Type{Kind: List}
+	}
+	return Type{Kind: Map}
+}
+
+func parseTimestamp(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02 15:04:05.999999999Z07:00", "2006-01-02 15:04:05.999999999", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+}
+
+// equal reports whether a and b are equal, comparing ints and floats by value.
+func equal(a, b interface{}) bool {
+	switch a := a.(type) {
+	case int64:
+		switch b := b.(type) {
+		case int64:
+			return a == b
+		case float64:
+			return float64(a) == b
+		}
+	case float64:
+		switch b := b.(type) {
+		case int64:
+			return a == float64(b)
+		case float64:
+			return a == b
+		}
+	case time.Time:
+		b, ok := b.(time.Time)
+		return ok && a.Equal(b)
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			w, ok := b[k]
+			if !ok || !equal(v, w) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+// compare returns the order of a and b, which must be two numbers, strings,
+// timestamps or durations.
+func compare(a, b interface{}) (int, error) {
+	switch a := a.(type) {
+	case int64:
+		switch b := b.(type) {
+		case int64:
+			return cmp(a < b, a > b), nil
+		case float64:
+			return cmp(float64(a) < b, float64(a) > b), nil
+		}
+	case float64:
+		switch b := b.(type) {
+		case int64:
+			return cmp(a < float64(b), a > float64(b)), nil
+		case float64:
+			return cmp(a < b, a > b), nil
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), nil
+		}
+	case time.Time:
+		if b, ok := b.(time.Time); ok {
+			return cmp(a.Before(b), a.After(b)), nil
+		}
+	case time.Duration:
+		if b, ok := b.(time.Duration); ok {
+			return cmp(a < b, a > b), nil
+		}
+	}
+	return 0, fmt.Errorf("can't compare %s and %s", typeOf(a), typeOf(b))
+}
+
+func cmp(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/meroxa/turbine-go"
+	"github.com/meroxa/turbine-go/transforms"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// Field paths below are gjson paths into the payload, or into its "payload"
+// field for records with a Kafka Connect style schema. Dots in field names,
+// such as those of flattened keys, are escaped: "user\.email".
+
+// Unwrap replaces every record by its payload, dropping the schema, like
+// transforms.Unwrap. Records that can't be unwrapped are dropped.
+type Unwrap struct{}
+
+func (f Unwrap) Process(rr []turbine.Record) []turbine.Record {
+	out := rr[:0]
+	for _, r := range rr {
+		err := transforms.Unwrap(&r.Payload)
+		if err != nil {
+			log.Printf("error unwrapping record %s: %s", r.Key, err)
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// Rename moves the payload fields at the keys of Fields to the paths they map
+// to, along with their schema fields. All fields are moved at once, so
+// {"a": "b", "b": "a"} swaps a and b. Missing fields are skipped and records
+// that can't be renamed, for instance because the new parent is not an
+// object, are dropped.
+type Rename struct {
+	Fields map[string]string
+}
+
+func (f Rename) Process(rr []turbine.Record) []turbine.Record {
+	out := rr[:0]
+	for _, r := range rr {
+		err := RenamePayload(&r.Payload, f.Fields)
+		if err != nil {
+			log.Printf("error renaming record %s: %s", r.Key, err)
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// RenamePayload moves the fields of p as described by Rename.
+func RenamePayload(p *turbine.Payload, fields map[string]string) error {
+	val := []byte(*p)
+	withSchema := hasSchema(val)
+	prefix := ""
+	if withSchema {
+		prefix = "payload."
+	}
+
+	from := make([]string, 0, len(fields))
+	for path := range fields {
+		from = append(from, path)
+	}
+	sort.Strings(from)
+
+	type moved struct {
+		to     string
+		value  gjson.Result
+		schema gjson.Result
+	}
+	var mm []moved
+	var err error
+	for _, path := range from {
+		v := gjson.GetBytes(val, prefix+path)
+		if !v.Exists() {
+			continue
+		}
+		m := moved{to: fields[path], value: v}
+		val, err = sjson.DeleteBytes(val, prefix+path)
+		if err != nil {
+			return err
+		}
+		if withSchema {
+			m.schema, val, err = deleteSchemaField(val, path)
+			if err != nil {
+				return err
+			}
+		}
+		mm = append(mm, m)
+	}
+
+	for _, m := range mm {
+		val, err = sjson.SetRawBytes(val, prefix+m.to, []byte(m.value.Raw))
+		if err != nil {
+			return err
+		}
+		if !withSchema || !m.schema.Exists() {
+			continue
+		}
+		parts := splitPath(m.to)
+		field, err := sjson.SetBytes([]byte(m.schema.Raw), "field", parts[len(parts)-1])
+		if err != nil {
+			return err
+		}
+		val, err = addSchemaField(val, parts[:len(parts)-1], field)
+		if err != nil {
+			return fmt.Errorf("%s: %w", m.to, err)
+		}
+	}
+
+	*p = val
+	return nil
+}
+
+// Hash replaces the payload fields at Fields with the hex SHA-256 of their
+// value, the string for strings and the JSON for anything else, and retypes
+// their schema fields to strings. Nulls and missing fields are skipped. The
+// hash isn't keyed, so values from a small set, such as phone numbers, can be
+// recovered by hashing all candidates; use a keyed pseudonym where that
+// matters.
+type Hash struct {
+	Fields []string
+}
+
+func (f Hash) Process(rr []turbine.Record) []turbine.Record {
+	out := rr[:0]
+	for _, r := range rr {
+		err := HashPayload(&r.Payload, f.Fields)
+		if err != nil {
+			log.Printf("error hashing record %s: %s", r.Key, err)
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// HashPayload hashes the fields of p as described by Hash.
+func HashPayload(p *turbine.Payload, fields []string) error {
+	val := []byte(*p)
+	withSchema := hasSchema(val)
+	prefix := ""
+	if withSchema {
+		prefix = "payload."
+	}
+
+	var err error
+	for _, path := range fields {
+		v := gjson.GetBytes(val, prefix+path)
+		if !v.Exists() || v.Type == gjson.Null {
+			continue
+		}
+		s := v.Raw
+		if v.Type == gjson.String {
+			s = v.Str
+		}
+		sum := sha256.Sum256([]byte(s))
+		val, err = sjson.SetBytes(val, prefix+path, hex.EncodeToString(sum[:]))
+		if err != nil {
+			return err
+		}
+		if !withSchema {
+			continue
+		}
+		fieldPath, ok := schemaFieldPath(val, path)
+		if !ok {
+			continue
+		}
+		field := gjson.GetBytes(val, fieldPath)
+		retyped := map[string]interface{}{
+			"field":    field.Get("field").String(),
+			"optional": field.Get("optional").Bool(),
+			"type":     "string",
+		}
+		val, err = sjson.SetBytes(val, fieldPath, retyped)
+		if err != nil {
+			return err
+		}
+	}
+
+	*p = val
+	return nil
+}
+
+// Remove deletes the payload fields at Fields, along with their schema
+// fields. Missing fields are skipped.
+type Remove struct {
+	Fields []string
+}
+
+func (f Remove) Process(rr []turbine.Record) []turbine.Record {
+	out := rr[:0]
+	for _, r := range rr {
+		err := RemovePayload(&r.Payload, f.Fields)
+		if err != nil {
+			log.Printf("error removing fields of record %s: %s", r.Key, err)
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// RemovePayload deletes the fields of p as described by Remove.
+func RemovePayload(p *turbine.Payload, fields []string) error {
+	val := []byte(*p)
+	withSchema := hasSchema(val)
+	prefix := ""
+	if withSchema {
+		prefix = "payload."
+	}
+
+	var err error
+	for _, path := range fields {
+		if !gjson.GetBytes(val, prefix+path).Exists() {
+			continue
+		}
+		val, err = sjson.DeleteBytes(val, prefix+path)
+		if err != nil {
+			return err
+		}
+		if withSchema {
+			_, val, err = deleteSchemaField(val, path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	*p = val
+	return nil
+}
+
+// deleteSchemaField removes the schema field of the payload field at path
+// from p, returning it. It returns a zero Result if there is none.
+func deleteSchemaField(p []byte, path string) (gjson.Result, []byte, error) {
+	fieldPath, ok := schemaFieldPath(p, path)
+	if !ok {
+		return gjson.Result{}, p, nil
+	}
+	field := gjson.Parse(gjson.GetBytes(p, fieldPath).Raw)
+	p, err := sjson.DeleteBytes(p, fieldPath)
+	return field, p, err
+}
+
+// addSchemaField appends field to the struct schema of the payload field at
+// the parent path components, or to the top-level schema when there are none.
+func addSchemaField(p []byte, parent []string, field []byte) ([]byte, error) {
+	parentPath := "schema"
+	if len(parent) > 0 {
+		escaped := make([]string, len(parent))
+		for i, name := range parent {
+			escaped[i] = escapePath(name)
+		}
+		var ok bool
+		parentPath, ok = schemaFieldPath(p, strings.Join(escaped, "."))
+		if !ok {
+			return nil, fmt.Errorf("no schema for %s", strings.Join(parent, "."))
+		}
+	}
+	if t := gjson.GetBytes(p, parentPath+".type").String(); t != "struct" {
+		return nil, fmt.Errorf("parent schema is a %s, not a struct", t)
+	}
+	return sjson.SetRawBytes(p, parentPath+".fields.-1", field)
+}
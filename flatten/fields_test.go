@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/meroxa/turbine-go"
+	"github.com/tidwall/gjson"
+)
+
+const fieldsSchemaPayload = `{
+	"schema": {"type": "struct", "fields": [
+		{"field": "id", "type": "int32"},
+		{"field": "user", "type": "struct", "fields": [
+			{"field": "email", "type": "string", "optional": true},
+			{"field": "age", "type": "int32", "name": "age", "default": 0}
+		]}
+	]},
+	"payload": {"id": 1, "user": {"email": "alice@example.com", "age": 30}}
+}`
+
+func TestRenameSwaps(t *testing.T) {
+	p := turbine.Payload(`{"a": 1, "b": 2, "c": 3}`)
+	err := RenamePayload(&p, map[string]string{"a": "b", "b": "a"})
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	got := gjson.ParseBytes(p)
+	if got.Get("a").Int() != 2 || got.Get("b").Int() != 1 || got.Get("c").Int() != 3 {
+		t.Fatalf("want a and b swapped, got %s", p)
+	}
+}
+
+func TestRenameMovesSchemaField(t *testing.T) {
+	p := turbine.Payload(fieldsSchemaPayload)
+	err := RenamePayload(&p, map[string]string{"user.email": "email"})
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	got := gjson.ParseBytes(p)
+	if got.Get("payload.email").String() != "alice@example.com" || got.Get("payload.user.email").Exists() {
+		t.Fatalf("want payload email moved, got %s", p)
+	}
+	if got.Get(`schema.fields.#(field=="email").type`).String() != "string" {
+		t.Fatalf("want top-level email schema field, got %s", got.Get("schema"))
+	}
+	if got.Get(`schema.fields.#(field=="user").fields.#(field=="email")`).Exists() {
+		t.Fatalf("want user.email schema field gone, got %s", got.Get("schema"))
+	}
+}
+
+func TestRenameDropsRecordWithoutParent(t *testing.T) {
+	out := Rename{Fields: map[string]string{"id": "id.value"}}.Process([]turbine.Record{
+		{Key: "1", Payload: []byte(fieldsSchemaPayload)},
+	})
+	if len(out) != 0 {
+		t.Fatalf("want record dropped, got %s", out[0].Payload)
+	}
+}
+
+func TestHashRetypesSchemaField(t *testing.T) {
+	p := turbine.Payload(fieldsSchemaPayload)
+	err := HashPayload(&p, []string{"user.age", "user.missing"})
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	got := gjson.ParseBytes(p)
+	// sha256("30")
+	if got.Get("payload.user.age").String() != "624b60c58c9d8bfb6ff1886c2fd605d2adeb6ea4da576068201b6c6958ce93f4" {
+		t.Fatalf("want hashed age, got %s", got.Get("payload.user.age"))
+	}
+	age := got.Get(`schema.fields.1.fields.1`)
+	if age.Get("type").String() != "string" || age.Get("name").Exists() || age.Get("default").Exists() {
+		t.Fatalf("want plain string schema field, got %s", age)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	p := turbine.Payload(fieldsSchemaPayload)
+	err := RemovePayload(&p, []string{"user.email"})
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	got := gjson.ParseBytes(p)
+	if got.Get("payload.user.email").Exists() {
+		t.Fatalf("want email removed, got %s", p)
+	}
+	if n := len(got.Get("schema.fields.1.fields").Array()); n != 1 {
+		t.Fatalf("want 1 user schema field, got %d", n)
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	out := Unwrap{}.Process([]turbine.Record{{Key: "1", Payload: []byte(fieldsSchemaPayload)}})
+	if len(out) != 1 || gjson.GetBytes(out[0].Payload, "schema").Exists() || gjson.GetBytes(out[0].Payload, "id").Int() != 1 {
+		t.Fatalf("want unwrapped payload, got %v", out)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/meroxa/flatten/expr"
+	"github.com/meroxa/turbine-go"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// Filter keeps the records for which the expression Where, in the language of
+// package expr, is true. Records for which it is false or null, or fails, are
+// dropped.
+//
+// Filter returns a new slice and leaves its input alone, so it can also route
+// records, with one Filter per destination:
+//
+//	us, _ := NewFilter(`country == "US"`)
+//	rest, _ := NewFilter(`country != "US"`)
+//	err = dest.Write(v.Process(rr, us), "orders_us")
+//	...
+//	err = dest.Write(v.Process(rr, rest), "orders_other")
+type Filter struct {
+	Where    string
+	programs *programs
+}
+
+// NewFilter parses and checks where, which must be a boolean expression.
+func NewFilter(where string) (Filter, error) {
+	p, err := newPrograms(where)
+	if err != nil {
+		return Filter{}, err
+	}
+	if k := p.dyn.Type().Kind; k != expr.Bool && k != expr.Dyn {
+		return Filter{}, fmt.Errorf("%s is %s, want bool", where, p.dyn.Type())
+	}
+	return Filter{Where: where, programs: p}, nil
+}
+
+func (f Filter) Process(rr []turbine.Record) []turbine.Record {
+	out := make([]turbine.Record, 0, len(rr))
+	for _, r := range rr {
+		v, _, err := f.programs.eval(r.Payload)
+		if err != nil {
+			log.Printf("error filtering record %s: %s", r.Key, err)
+			continue
+		}
+		if v == true {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// Set sets the payload field at Field, a gjson path, to the value of the
+// expression Expr. Records with a Kafka Connect schema get a schema field of
+// the expression's type, and timestamps are written as Kafka Connect
+// timestamps; records without one get RFC 3339 strings. Records for which
+// the expression fails are dropped.
+type Set struct {
+	Field    string
+	Expr     string
+	programs *programs
+}
+
+// NewSet parses and checks src, the expression computing field.
+func NewSet(field, src string) (Set, error) {
+	if field == "" {
+		return Set{}, fmt.Errorf("no field")
+	}
+	p, err := newPrograms(src)
+	if err != nil {
+		return Set{}, err
+	}
+	return Set{Field: field, Expr: src, programs: p}, nil
+}
+
+func (f Set) Process(rr []turbine.Record) []turbine.Record {
+	out := rr[:0]
+	for _, r := range rr {
+		err := f.set(&r.Payload)
+		if err != nil {
+			log.Printf("error computing %s of record %s: %s", f.Field, r.Key, err)
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func (f Set) set(p *turbine.Payload) error {
+	v, t, err := f.programs.eval(*p)
+	if err != nil {
+		return err
+	}
+
+	val := []byte(*p)
+	withSchema := hasSchema(val)
+	if !withSchema {
+		val, err = sjson.SetBytes(val, f.Field, expr.Encode(v, false))
+		if err != nil {
+			return err
+		}
+		*p = val
+		return nil
+	}
+
+	val, err = sjson.SetBytes(val, "payload."+f.Field, expr.Encode(v, true))
+	if err != nil {
+		return err
+	}
+	_, val, err = deleteSchemaField(val, f.Field)
+	if err != nil {
+		return err
+	}
+	parts := splitPath(f.Field)
+	schema := expr.ConnectSchema(t)
+	schema["field"] = parts[len(parts)-1]
+	field, err := json.Marshal(schema)
+	if err != nil {
+		return err
+	}
+	val, err = addSchemaField(val, parts[:len(parts)-1], field)
+	if err != nil {
+		return err
+	}
+	*p = val
+	return nil
+}
+
+// programs holds an expression checked against every record schema it has
+// been evaluated with, so each schema is checked once.
+type programs struct {
+	src  string
+	node expr.Node
+	dyn  *expr.Program // checked against records without a schema
+
+	mu       sync.Mutex
+	bySchema map[string]checked
+}
+
+type checked struct {
+	program *expr.Program
+	err     error
+}
+
+// newPrograms parses src and checks it against records without a schema,
+// which finds syntax errors, unknown functions and type errors that don't
+// depend on the schema.
+func newPrograms(src string) (*programs, error) {
+	n, err := expr.Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", src, err)
+	}
+	dyn, err := expr.Check(n, src, expr.Type{})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", src, err)
+	}
+	return &programs{src: src, node: n, dyn: dyn, bySchema: map[string]checked{}}, nil
+}
+
+// eval evaluates the expression on the payload of p, returning its value and
+// static type.
+func (ps *programs) eval(p turbine.Payload) (interface{}, expr.Type, error) {
+	if !hasSchema(p) {
+		v, err := ps.dyn.Eval(p)
+		return v, ps.dyn.Type(), err
+	}
+
+	res := gjson.GetManyBytes(p, "schema", "payload")
+	ps.mu.Lock()
+	c, ok := ps.bySchema[res[0].Raw]
+	if !ok {
+		c.program, c.err = expr.Check(ps.node, ps.src, expr.SchemaType(res[0]))
+		ps.bySchema[res[0].Raw] = c
+	}
+	ps.mu.Unlock()
+	if c.err != nil {
+		return nil, expr.Type{}, fmt.Errorf("%s: %w", ps.src, c.err)
+	}
+	v, err := c.program.Eval([]byte(res[1].Raw))
+	return v, c.program.Type(), err
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/meroxa/turbine-go"
+	"github.com/tidwall/gjson"
+)
+
+func TestFilterRoutes(t *testing.T) {
+	rr := []turbine.Record{
+		{Key: "1", Payload: []byte(`{"country": "US", "total": 10}`)},
+		{Key: "2", Payload: []byte(`{"country": "FR", "total": 20}`)},
+		{Key: "3", Payload: []byte(`{"total": 30}`)},
+	}
+
+	us, err := NewFilter(`country == "US"`)
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	rest, err := NewFilter(`country != "US"`)
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+
+	gotUS := us.Process(rr)
+	gotRest := rest.Process(rr)
+	if len(gotUS) != 1 || gotUS[0].Key != "1" {
+		t.Fatalf("want record 1, got %v", gotUS)
+	}
+	if len(gotRest) != 2 || gotRest[0].Key != "2" || gotRest[1].Key != "3" {
+		t.Fatalf("want records 2 and 3, got %v", gotRest)
+	}
+	if rr[0].Key != "1" || rr[1].Key != "2" || rr[2].Key != "3" {
+		t.Fatalf("want input unchanged, got %v", rr)
+	}
+}
+
+func TestFilterChecksSchema(t *testing.T) {
+	f, err := NewFilter(`total > 15`)
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	out := f.Process([]turbine.Record{
+		{Key: "1", Payload: []byte(`{"schema": {"type": "struct", "fields": [{"field": "total", "type": "int32"}]}, "payload": {"total": 20}}`)},
+		{Key: "2", Payload: []byte(`{"schema": {"type": "struct", "fields": [{"field": "total", "type": "string"}]}, "payload": {"total": "20"}}`)},
+	})
+	if len(out) != 1 || out[0].Key != "1" {
+		t.Fatalf("want record 1 only, got %v", out)
+	}
+}
+
+func TestNewFilterErrors(t *testing.T) {
+	if _, err := NewFilter(`lower(country)`); err == nil || !strings.Contains(err.Error(), "is string, want bool") {
+		t.Fatalf("want non-bool error, got %v", err)
+	}
+	if _, err := NewFilter(`country ==`); err == nil || !strings.Contains(err.Error(), "1:11: unexpected end of expression") {
+		t.Fatalf("want syntax error, got %v", err)
+	}
+}
+
+func TestSet(t *testing.T) {
+	s, err := NewSet("domain", `lower(email).substring(email.lower().size() - 11)`)
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	out := s.Process([]turbine.Record{{Key: "1", Payload: []byte(`{"email": "Bob@Example.COM"}`)}})
+	if got := gjson.GetBytes(out[0].Payload, "domain").String(); got != "example.com" {
+		t.Fatalf("want example.com, got %s", out[0].Payload)
+	}
+}
+
+func TestSetWithSchema(t *testing.T) {
+	s, err := NewSet(`user.adult`, `user.age >= 18`)
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	out := s.Process([]turbine.Record{{Key: "1", Payload: []byte(fieldsSchemaPayload)}})
+	if len(out) != 1 {
+		t.Fatalf("want 1 record, got %d", len(out))
+	}
+	got := gjson.ParseBytes(out[0].Payload)
+	if !got.Get("payload.user.adult").Bool() {
+		t.Fatalf("want adult, got %s", out[0].Payload)
+	}
+	if typ := got.Get(`schema.fields.1.fields.#(field=="adult").type`).String(); typ != "boolean" {
+		t.Fatalf("want boolean schema field, got %s", got.Get("schema"))
+	}
+
+	s, err = NewSet("ts", `timestamp(0) + duration("1s")`)
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	out = s.Process(out)
+	got = gjson.ParseBytes(out[0].Payload)
+	if got.Get("payload.ts").Int() != 1000 || got.Get(`schema.fields.#(field=="ts").name`).String() != "org.apache.kafka.connect.data.Timestamp" {
+		t.Fatalf("want Kafka Connect timestamp, got %s", out[0].Payload)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/meroxa/turbine-go"
+)
+
+// ErrKeyCollision is returned when two fields flatten to the same key, such as
+// "a.b" in {"a.b": 1, "a": {"b": 2}}.
+var ErrKeyCollision = errors.New("key collision")
+
+// ArrayStrategy is how Flatten handles arrays.
+type ArrayStrategy string
+
+const (
+	// ArraysIndex expands arrays into one key per element, e.g. "actions.0".
+	ArraysIndex ArrayStrategy = "index"
+	// ArraysJoin joins the elements into a single delimited string.
+	ArraysJoin ArrayStrategy = "join"
+	// ArraysJSON keeps the array as a JSON encoded string.
+	ArraysJSON ArrayStrategy = "json"
+	// ArraysNested leaves the array as it is.
+	ArraysNested ArrayStrategy = "nested"
+)
+
+// FlattenOptions configures Flatten. The zero value flattens like
+// transforms.Flatten: "." delimited keys, arrays expanded by index and no
+// depth limit.
+type FlattenOptions struct {
+	Delimiter     string        `json:"delimiter"`      // between key components, defaults to "."
+	Arrays        ArrayStrategy `json:"arrays"`         // defaults to ArraysIndex
+	JoinSeparator string        `json:"join_separator"` // between elements joined by ArraysJoin, defaults to ","
+	// MaxDepth is the maximum number of components in a key. Objects and
+	// arrays found at that depth are stored as JSON strings. 0 means no limit.
+	MaxDepth int `json:"max_depth"`
+}
+
+// Flatten flattens the payload of every record with its Options. Records that
+// can't be flattened, for instance because of a key collision, are dropped.
+type Flatten struct {
+	Options FlattenOptions
+}
+
+func (f Flatten) Process(stream []turbine.Record) []turbine.Record {
+	out := stream[:0]
+	for _, r := range stream {
+		err := FlattenPayload(&r.Payload, f.Options)
+		if err != nil {
+			log.Printf("error flattening record %s: %s", r.Key, err)
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// FlattenPayload flattens the JSON object in p. Empty objects and arrays are
+// kept as they are.
+func FlattenPayload(p *turbine.Payload, opts FlattenOptions) error {
+	if opts.Delimiter == "" {
+		opts.Delimiter = "."
+	}
+	if opts.Arrays == "" {
+		opts.Arrays = ArraysIndex
+	}
+	if opts.JoinSeparator == "" {
+		opts.JoinSeparator = ","
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(*p))
+	dec.UseNumber()
+	var nested map[string]interface{}
+	err := dec.Decode(&nested)
+	if err != nil {
+		return err
+	}
+
+	fl := flattener{opts: opts, out: make(map[string]interface{})}
+	err = fl.object(nested, "", 0)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(fl.out)
+	if err != nil {
+		return err
+	}
+	*p = b
+	return nil
+}
+
+type flattener struct {
+	opts FlattenOptions
+	out  map[string]interface{}
+}
+
+func (fl flattener) object(m map[string]interface{}, prefix string, depth int) error {
+	for k, v := range m {
+		key := k
+		if depth > 0 {
+			key = prefix + fl.opts.Delimiter + k
+		}
+		err := fl.value(key, v, depth+1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fl flattener) value(key string, v interface{}, depth int) error {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return fl.set(key, v)
+		}
+		if fl.atMaxDepth(depth) {
+			return fl.setJSON(key, v)
+		}
+		return fl.object(v, key, depth)
+	case []interface{}:
+		return fl.array(key, v, depth)
+	default:
+		return fl.set(key, v)
+	}
+}
+
+func (fl flattener) array(key string, a []interface{}, depth int) error {
+	switch fl.opts.Arrays {
+	case ArraysJoin:
+		elems := make([]string, len(a))
+		for i, v := range a {
+			if s, ok := v.(string); ok {
+				elems[i] = s
+				continue
+			}
+			b, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			elems[i] = string(b)
+		}
+		return fl.set(key, strings.Join(elems, fl.opts.JoinSeparator))
+	case ArraysJSON:
+		return fl.setJSON(key, a)
+	case ArraysNested:
+		return fl.set(key, a)
+	case ArraysIndex:
+		if len(a) == 0 {
+			return fl.set(key, a)
+		}
+		if fl.atMaxDepth(depth) {
+			return fl.setJSON(key, a)
+		}
+		for i, v := range a {
+			err := fl.value(key+fl.opts.Delimiter+strconv.Itoa(i), v, depth+1)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown array strategy %q", fl.opts.Arrays)
+	}
+}
+
+func (fl flattener) atMaxDepth(depth int) bool {
+	return fl.opts.MaxDepth > 0 && depth >= fl.opts.MaxDepth
+}
+
+func (fl flattener) set(key string, v interface{}) error {
+	if _, ok := fl.out[key]; ok {
+		return fmt.Errorf("%w: %s", ErrKeyCollision, key)
+	}
+	fl.out[key] = v
+	return nil
+}
+
+func (fl flattener) setJSON(key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return fl.set(key, string(b))
+}
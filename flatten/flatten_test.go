@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/meroxa/turbine-go"
+)
+
+func TestFlattenPayload(t *testing.T) {
+	tests := []struct {
+		name string
+		opts FlattenOptions
+		want map[string]interface{}
+	}{
+		{
+			name: "defaults",
+			want: map[string]interface{}{
+				"id": 1.0, "user.id": 100.0, "user.name": "alice", "user.email": "alice@example.com",
+				"actions.0": "register", "actions.1": "purchase",
+			},
+		},
+		{
+			name: "join",
+			opts: FlattenOptions{Delimiter: "_", Arrays: ArraysJoin, JoinSeparator: "|"},
+			want: map[string]interface{}{
+				"id": 1.0, "user_id": 100.0, "user_name": "alice", "user_email": "alice@example.com",
+				"actions": "register|purchase",
+			},
+		},
+		{
+			name: "json",
+			opts: FlattenOptions{Arrays: ArraysJSON},
+			want: map[string]interface{}{
+				"id": 1.0, "user.id": 100.0, "user.name": "alice", "user.email": "alice@example.com",
+				"actions": `["register","purchase"]`,
+			},
+		},
+		{
+			name: "nested",
+			opts: FlattenOptions{Arrays: ArraysNested},
+			want: map[string]interface{}{
+				"id": 1.0, "user.id": 100.0, "user.name": "alice", "user.email": "alice@example.com",
+				"actions": []interface{}{"register", "purchase"},
+			},
+		},
+		{
+			name: "max depth",
+			opts: FlattenOptions{MaxDepth: 1},
+			want: map[string]interface{}{
+				"id":      1.0,
+				"user":    `{"email":"alice@example.com","id":100,"name":"alice"}`,
+				"actions": `["register","purchase"]`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := turbine.Payload(nestedEvent)
+			err := FlattenPayload(&p, tt.opts)
+			if err != nil {
+				t.Fatalf("want no error, got %s", err)
+			}
+
+			got, err := p.Map()
+			if err != nil {
+				t.Fatalf("want no error, got %s", err)
+			}
+			if !reflect.DeepEqual(tt.want, got) {
+				t.Fatalf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFlattenPayload_MaxDepthNested(t *testing.T) {
+	p := turbine.Payload(`{"a": {"b": {"c": {"d": 1}}, "e": [{"f": 2}]}}`)
+	err := FlattenPayload(&p, FlattenOptions{MaxDepth: 2})
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+
+	want := `{"a.b":"{\"c\":{\"d\":1}}","a.e":"[{\"f\":2}]"}`
+	if string(p) != want {
+		t.Fatalf("want %s, got %s", want, p)
+	}
+}
+
+func TestFlattenPayload_KeyCollision(t *testing.T) {
+	p := turbine.Payload(`{"a.b": 1, "a": {"b": 2}}`)
+	err := FlattenPayload(&p, FlattenOptions{})
+	if !errors.Is(err, ErrKeyCollision) {
+		t.Fatalf("want ErrKeyCollision, got %v", err)
+	}
+	if string(p) != `{"a.b": 1, "a": {"b": 2}}` {
+		t.Fatalf("want payload to be unchanged, got %s", p)
+	}
+}
+
+func TestFlatten_ProcessKeyCollision(t *testing.T) {
+	rr := []turbine.Record{
+		{Key: "1", Payload: []byte(`{"a.b": 1, "a": {"b": 2}}`)},
+		{Key: "2", Payload: []byte(`{"a": {"b": 2}}`)},
+	}
+
+	out := Flatten{}.Process(rr)
+
+	if len(out) != 1 || out[0].Key != "2" {
+		t.Fatalf("want only record 2, got %v", out)
+	}
+}
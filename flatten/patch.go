@@ -0,0 +1,547 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/meroxa/turbine-go"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// ErrTestFailed is returned for records failing a test operation of a JSON
+// Patch.
+var ErrTestFailed = errors.New("test operation failed")
+
+// PatchOperation is an operation of an RFC 6902 JSON Patch.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Patch is either an RFC 6902 JSON Patch or an RFC 7396 JSON Merge Patch.
+type Patch struct {
+	ops   []PatchOperation
+	merge json.RawMessage
+}
+
+// NewJSONPatch parses doc, an array of JSON Patch operations.
+func NewJSONPatch(doc []byte) (*Patch, error) {
+	var ops []PatchOperation
+	err := json.Unmarshal(doc, &ops)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON Patch: %w", err)
+	}
+	for i, op := range ops {
+		err := op.validate()
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+	return &Patch{ops: ops}, nil
+}
+
+// NewMergePatch parses doc, a JSON Merge Patch.
+func NewMergePatch(doc []byte) (*Patch, error) {
+	if !gjson.ValidBytes(doc) {
+		return nil, errors.New("invalid JSON Merge Patch")
+	}
+	return &Patch{merge: append(json.RawMessage(nil), doc...)}, nil
+}
+
+// NewPatch parses doc as a JSON Patch when it is an array, as a JSON Merge
+// Patch otherwise.
+func NewPatch(doc []byte) (*Patch, error) {
+	if gjson.ParseBytes(doc).IsArray() {
+		return NewJSONPatch(doc)
+	}
+	return NewMergePatch(doc)
+}
+
+// NewFilePatch reads a patch from the file at path, in either format (see
+// NewPatch).
+func NewFilePatch(path string) (*Patch, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewPatch(b)
+}
+
+// ApplyPatch applies Patch to the data of every record: the payload, or its
+// "payload" field for records with a Kafka Connect style schema. Fields added
+// to or removed from a struct are added to or removed from the schema too;
+// added fields are optional and typed like Wrap does, unless the schema
+// already has a field of the same primitive type.
+//
+// Records the patch can't be applied to, such as those failing a test
+// operation (see ErrTestFailed), are dropped and handed to DeadLetter along
+// with the error; when DeadLetter is nil they are logged.
+type ApplyPatch struct {
+	Patch      *Patch
+	DeadLetter func(turbine.RecordWithError)
+}
+
+func (f ApplyPatch) Process(rr []turbine.Record) []turbine.Record {
+	out := rr[:0]
+	for _, r := range rr {
+		err := PatchPayload(&r.Payload, f.Patch)
+		if err != nil {
+			f.deadLetter(turbine.RecordWithError{Error: err, Record: r})
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func (f ApplyPatch) deadLetter(r turbine.RecordWithError) {
+	if f.DeadLetter != nil {
+		f.DeadLetter(r)
+		return
+	}
+	log.Printf("error patching record %s: %s", r.Key, r.Error)
+}
+
+// patchChange is a location of the data changed by a patch.
+type patchChange struct {
+	tokens  []string
+	removed bool
+}
+
+// PatchPayload applies patch to the data in p. p is left unchanged on error.
+func PatchPayload(p *turbine.Payload, patch *Patch) error {
+	val := []byte(*p)
+	withSchema := hasSchema(val)
+	doc := val
+	if withSchema {
+		doc = []byte(gjson.GetBytes(val, "payload").Raw)
+	}
+
+	var changes []patchChange
+	var err error
+	if patch.merge != nil {
+		doc, changes, err = mergePatch(gjson.ParseBytes(doc), gjson.ParseBytes(patch.merge), nil)
+	} else {
+		doc, changes, err = jsonPatch(doc, patch.ops)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !withSchema {
+		*p = doc
+		return nil
+	}
+	val, err = sjson.SetRawBytes(val, "payload", doc)
+	if err != nil {
+		return err
+	}
+	val, err = patchSchema(val, changes)
+	if err != nil {
+		return err
+	}
+	*p = val
+	return nil
+}
+
+func (op PatchOperation) validate() error {
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return fmt.Errorf("%s without a value", op.Op)
+		}
+	case "move", "copy":
+		_, err := parsePointer(op.From)
+		if err != nil {
+			return err
+		}
+	case "remove":
+	default:
+		return fmt.Errorf("unknown operation %q", op.Op)
+	}
+	_, err := parsePointer(op.Path)
+	return err
+}
+
+// jsonPatch applies the operations to doc in order.
+func jsonPatch(doc []byte, ops []PatchOperation) ([]byte, []patchChange, error) {
+	var changes []patchChange
+	for i, op := range ops {
+		path, _ := parsePointer(op.Path)
+		from, _ := parsePointer(op.From)
+
+		var err error
+		switch op.Op {
+		case "add":
+			doc, err = pointerAdd(doc, path, op.Value)
+			changes = append(changes, patchChange{tokens: path})
+		case "remove":
+			doc, err = pointerRemove(doc, path)
+			changes = append(changes, patchChange{tokens: path, removed: true})
+		case "replace":
+			if !pointerGet(doc, path).Exists() {
+				err = fmt.Errorf("%s not found", op.Path)
+				break
+			}
+			doc, err = pointerSet(doc, path, op.Value)
+			changes = append(changes, patchChange{tokens: path})
+		case "move":
+			if strings.HasPrefix(op.Path, op.From+"/") {
+				err = fmt.Errorf("unable to move %s into itself", op.From)
+				break
+			}
+			v := pointerGet(doc, from)
+			if !v.Exists() {
+				err = fmt.Errorf("%s not found", op.From)
+				break
+			}
+			doc, err = pointerRemove(doc, from)
+			if err == nil {
+				doc, err = pointerAdd(doc, path, []byte(v.Raw))
+			}
+			changes = append(changes, patchChange{tokens: from, removed: true}, patchChange{tokens: path})
+		case "copy":
+			v := pointerGet(doc, from)
+			if !v.Exists() {
+				err = fmt.Errorf("%s not found", op.From)
+				break
+			}
+			doc, err = pointerAdd(doc, path, []byte(v.Raw))
+			changes = append(changes, patchChange{tokens: path})
+		case "test":
+			v := pointerGet(doc, path)
+			if !v.Exists() || !valuesEqual(v, gjson.ParseBytes(op.Value)) {
+				err = fmt.Errorf("%w: %s", ErrTestFailed, op.Path)
+			}
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+	return doc, changes, nil
+}
+
+// mergePatch merges patch into target as described in RFC 7396. tokens is the
+// location of target in the data.
+func mergePatch(target, patch gjson.Result, tokens []string) ([]byte, []patchChange, error) {
+	if !patch.IsObject() {
+		return []byte(patch.Raw), []patchChange{{tokens: tokens}}, nil
+	}
+
+	var changes []patchChange
+	out := []byte(target.Raw)
+	if !target.IsObject() {
+		out = []byte(`{}`)
+		changes = append(changes, patchChange{tokens: tokens})
+	}
+
+	var err error
+	patch.ForEach(func(k, v gjson.Result) bool {
+		name := k.String()
+		path := escapePath(name)
+		field := append(append([]string(nil), tokens...), name)
+		if v.Type == gjson.Null {
+			if gjson.GetBytes(out, path).Exists() {
+				out, err = sjson.DeleteBytes(out, path)
+				changes = append(changes, patchChange{tokens: field, removed: true})
+			}
+			return err == nil
+		}
+
+		var merged []byte
+		var fieldChanges []patchChange
+		merged, fieldChanges, err = mergePatch(gjson.GetBytes(out, path), v, field)
+		if err != nil {
+			return false
+		}
+		out, err = sjson.SetRawBytes(out, path, merged)
+		changes = append(changes, fieldChanges...)
+		return err == nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, changes, nil
+}
+
+// parsePointer returns the reference tokens of an RFC 6901 JSON Pointer.
+func parsePointer(ptr string) ([]string, error) {
+	if ptr == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(ptr, "/") {
+		return nil, fmt.Errorf("invalid JSON Pointer %q", ptr)
+	}
+	tokens := strings.Split(ptr[1:], "/")
+	r := strings.NewReplacer("~1", "/", "~0", "~")
+	for i, t := range tokens {
+		tokens[i] = r.Replace(t)
+	}
+	return tokens, nil
+}
+
+// pointerPath returns the gjson and sjson path of tokens.
+func pointerPath(tokens []string) string {
+	escaped := make([]string, len(tokens))
+	for i, t := range tokens {
+		escaped[i] = escapePath(t)
+	}
+	return strings.Join(escaped, ".")
+}
+
+func pointerGet(doc []byte, tokens []string) gjson.Result {
+	if len(tokens) == 0 {
+		return gjson.ParseBytes(doc)
+	}
+	v := gjson.ParseBytes(doc)
+	for _, t := range tokens {
+		if v.IsArray() {
+			i, ok := arrayIndex(t, len(v.Array()))
+			if !ok {
+				return gjson.Result{}
+			}
+			v = v.Array()[i]
+			continue
+		}
+		if !v.IsObject() {
+			return gjson.Result{}
+		}
+		v = v.Get(escapePath(t))
+	}
+	return v
+}
+
+// pointerSet replaces the value at tokens, which must exist unless its parent
+// is an object.
+func pointerSet(doc []byte, tokens []string, raw []byte) ([]byte, error) {
+	if len(tokens) == 0 {
+		return raw, nil
+	}
+	return sjson.SetRawBytes(doc, pointerPath(tokens), raw)
+}
+
+// pointerAdd adds raw at tokens: array elements are inserted, object members
+// are set.
+func pointerAdd(doc []byte, tokens []string, raw []byte) ([]byte, error) {
+	if len(tokens) == 0 {
+		return raw, nil
+	}
+	parent := pointerGet(doc, tokens[:len(tokens)-1])
+	last := tokens[len(tokens)-1]
+	switch {
+	case parent.IsObject():
+		return pointerSet(doc, tokens, raw)
+	case parent.IsArray():
+		elems := parent.Array()
+		i := len(elems)
+		if last != "-" {
+			var ok bool
+			i, ok = arrayIndex(last, len(elems)+1)
+			if !ok {
+				return nil, fmt.Errorf("invalid index %q", last)
+			}
+		}
+		raws := make([]string, 0, len(elems)+1)
+		for _, e := range elems[:i] {
+			raws = append(raws, e.Raw)
+		}
+		raws = append(raws, string(raw))
+		for _, e := range elems[i:] {
+			raws = append(raws, e.Raw)
+		}
+		return pointerSet(doc, tokens[:len(tokens)-1], []byte("["+strings.Join(raws, ",")+"]"))
+	default:
+		return nil, fmt.Errorf("no object or array to add %s to", last)
+	}
+}
+
+func pointerRemove(doc []byte, tokens []string) ([]byte, error) {
+	if len(tokens) == 0 {
+		return nil, errors.New("unable to remove the whole document")
+	}
+	if !pointerGet(doc, tokens).Exists() {
+		return nil, fmt.Errorf("/%s not found", strings.Join(tokens, "/"))
+	}
+	parent := pointerGet(doc, tokens[:len(tokens)-1])
+	if !parent.IsArray() {
+		return sjson.DeleteBytes(doc, pointerPath(tokens))
+	}
+	elems := parent.Array()
+	i, _ := arrayIndex(tokens[len(tokens)-1], len(elems))
+	raws := make([]string, 0, len(elems)-1)
+	for j, e := range elems {
+		if j != i {
+			raws = append(raws, e.Raw)
+		}
+	}
+	return pointerSet(doc, tokens[:len(tokens)-1], []byte("["+strings.Join(raws, ",")+"]"))
+}
+
+// arrayIndex parses t as an index of an array of n elements.
+func arrayIndex(t string, n int) (int, bool) {
+	if t == "" || (len(t) > 1 && t[0] == '0') || strings.TrimLeft(t, "0123456789") != "" {
+		return 0, false
+	}
+	i, err := strconv.Atoi(t)
+	return i, err == nil && i < n
+}
+
+// valuesEqual compares JSON values as described for the test operation:
+// numbers by value and objects regardless of member order.
+func valuesEqual(a, b gjson.Result) bool {
+	switch {
+	case a.IsObject() && b.IsObject():
+		am, bm := a.Map(), b.Map()
+		if len(am) != len(bm) {
+			return false
+		}
+		for k, av := range am {
+			bv, ok := bm[k]
+			if !ok || !valuesEqual(av, bv) {
+				return false
+			}
+		}
+		return true
+	case a.IsArray() && b.IsArray():
+		aa, ba := a.Array(), b.Array()
+		if len(aa) != len(ba) {
+			return false
+		}
+		for i := range aa {
+			if !valuesEqual(aa[i], ba[i]) {
+				return false
+			}
+		}
+		return true
+	case a.IsObject() || a.IsArray() || b.IsObject() || b.IsArray():
+		return false
+	case a.Type == gjson.Number && b.Type == gjson.Number:
+		an, aok := new(big.Rat).SetString(a.Raw)
+		bn, bok := new(big.Rat).SetString(b.Raw)
+		return aok && bok && an.Cmp(bn) == 0
+	case a.Type == gjson.String && b.Type == gjson.String:
+		return a.Str == b.Str
+	default:
+		return a.Type == b.Type
+	}
+}
+
+// patchSchema updates the schema of the record val for the changed locations
+// of its payload.
+func patchSchema(val []byte, changes []patchChange) ([]byte, error) {
+	opts := WrapOptions{IntegerType: "int64", FloatType: "float64"}
+	var err error
+	for _, c := range changes {
+		if len(c.tokens) == 0 {
+			s, err := inferSchema(gjson.GetBytes(val, "payload"), opts)
+			if err != nil {
+				return nil, err
+			}
+			s.Name = gjson.GetBytes(val, "schema.name").String()
+			val, err = sjson.SetBytes(val, "schema", s)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		parentPath, ok := schemaPointerPath(val, c.tokens[:len(c.tokens)-1])
+		if !ok {
+			return nil, fmt.Errorf("/%s missing from the schema", strings.Join(c.tokens[:len(c.tokens)-1], "/"))
+		}
+		if gjson.GetBytes(val, parentPath+".type").String() != "struct" {
+			// array items and map values keep their schema
+			continue
+		}
+		name := c.tokens[len(c.tokens)-1]
+		idx := -1
+		gjson.GetBytes(val, parentPath+".fields").ForEach(func(i, f gjson.Result) bool {
+			if f.Get("field").String() == name {
+				idx = int(i.Int())
+				return false
+			}
+			return true
+		})
+		fieldPath := fmt.Sprintf("%s.fields.%d", parentPath, idx)
+
+		if c.removed {
+			if idx >= 0 {
+				val, err = sjson.DeleteBytes(val, fieldPath)
+				if err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		v := gjson.GetBytes(val, "payload."+pointerPath(c.tokens))
+		if !v.Exists() {
+			// removed by a later operation
+			continue
+		}
+		existing := gjson.GetBytes(val, fieldPath)
+		if idx >= 0 && v.Type == gjson.Null {
+			val, err = sjson.SetBytes(val, fieldPath+".optional", true)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		s, err := inferSchema(v, opts)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if idx >= 0 && s.Type != "struct" && s.Type != "array" && existing.Get("type").String() == s.Type {
+			continue
+		}
+		s.Field = name
+		s.Optional = true
+		if idx < 0 {
+			fieldPath = parentPath + ".fields.-1"
+		}
+		val, err = sjson.SetBytes(val, fieldPath, s)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return val, nil
+}
+
+// schemaPointerPath returns the sjson path of the schema of the payload value
+// at tokens.
+func schemaPointerPath(val []byte, tokens []string) (string, bool) {
+	path := "schema"
+	for _, t := range tokens {
+		switch gjson.GetBytes(val, path+".type").String() {
+		case "struct":
+			idx := -1
+			gjson.GetBytes(val, path+".fields").ForEach(func(i, f gjson.Result) bool {
+				if f.Get("field").String() == t {
+					idx = int(i.Int())
+					return false
+				}
+				return true
+			})
+			if idx < 0 {
+				return "", false
+			}
+			path = fmt.Sprintf("%s.fields.%d", path, idx)
+		case "array":
+			path += ".items"
+		case "map":
+			path += ".values"
+		default:
+			return "", false
+		}
+	}
+	return path, true
+}
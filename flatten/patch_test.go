@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/meroxa/turbine-go"
+	"github.com/tidwall/gjson"
+)
+
+func TestPatchPayload_JSONPatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		want  string
+	}{
+		{"add", `[{"op": "add", "path": "/source", "value": "mongo"}]`,
+			`{"id": 1, "user": {"id": 100, "name": "alice", "email": "alice@example.com"}, "actions": ["register", "purchase"], "source": "mongo"}`},
+		{"remove", `[{"op": "remove", "path": "/user/email"}]`,
+			`{"id": 1, "user": {"id": 100, "name": "alice"}, "actions": ["register", "purchase"]}`},
+		{"replace", `[{"op": "replace", "path": "/user", "value": 100}]`,
+			`{"id": 1, "user": 100, "actions": ["register", "purchase"]}`},
+		{"move", `[{"op": "move", "from": "/user/email", "path": "/email"}]`,
+			`{"id": 1, "user": {"id": 100, "name": "alice"}, "actions": ["register", "purchase"], "email": "alice@example.com"}`},
+		{"copy", `[{"op": "copy", "from": "/actions/1", "path": "/last_action"}]`,
+			`{"id": 1, "user": {"id": 100, "name": "alice", "email": "alice@example.com"}, "actions": ["register", "purchase"], "last_action": "purchase"}`},
+		{"insert", `[{"op": "add", "path": "/actions/0", "value": "visit"}, {"op": "add", "path": "/actions/-", "value": "logout"}]`,
+			`{"id": 1, "user": {"id": 100, "name": "alice", "email": "alice@example.com"}, "actions": ["visit", "register", "purchase", "logout"]}`},
+		{"remove element", `[{"op": "remove", "path": "/actions/0"}]`,
+			`{"id": 1, "user": {"id": 100, "name": "alice", "email": "alice@example.com"}, "actions": ["purchase"]}`},
+		{"test", `[{"op": "test", "path": "/user", "value": {"email": "alice@example.com", "name": "alice", "id": 100.0}}, {"op": "remove", "path": "/user"}]`,
+			`{"id": 1, "actions": ["register", "purchase"]}`},
+		{"escaped", `[{"op": "add", "path": "/a~1b~0c", "value": true}]`,
+			`{"id": 1, "user": {"id": 100, "name": "alice", "email": "alice@example.com"}, "actions": ["register", "purchase"], "a/b~c": true}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := NewJSONPatch([]byte(tt.patch))
+			if err != nil {
+				t.Fatalf("want no error, got %s", err)
+			}
+			p := turbine.Payload(nestedEvent)
+			err = PatchPayload(&p, patch)
+			if err != nil {
+				t.Fatalf("want no error, got %s", err)
+			}
+			if !jsonEqual(string(p), tt.want) {
+				t.Fatalf("want %s, got %s", tt.want, p)
+			}
+		})
+	}
+}
+
+func TestPatchPayload_JSONPatchErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+	}{
+		{"missing path", `[{"op": "remove", "path": "/user/phone"}]`},
+		{"replace missing", `[{"op": "replace", "path": "/phone", "value": 1}]`},
+		{"missing parent", `[{"op": "add", "path": "/account/plan", "value": "pro"}]`},
+		{"index out of range", `[{"op": "add", "path": "/actions/3", "value": "logout"}]`},
+		{"move into itself", `[{"op": "move", "from": "/user", "path": "/user/user"}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := NewJSONPatch([]byte(tt.patch))
+			if err != nil {
+				t.Fatalf("want no error, got %s", err)
+			}
+			p := turbine.Payload(nestedEvent)
+			if err := PatchPayload(&p, patch); err == nil {
+				t.Fatalf("want error, got %s", p)
+			}
+			if string(p) != nestedEvent {
+				t.Fatalf("want the payload unchanged, got %s", p)
+			}
+		})
+	}
+
+	for _, invalid := range []string{`{"op": "add"}`, `[{"op": "frobnicate", "path": "/id"}]`, `[{"op": "add", "path": "id", "value": 1}]`, `[{"op": "add", "path": "/id"}]`} {
+		if _, err := NewJSONPatch([]byte(invalid)); err == nil {
+			t.Fatalf("want error for %s, got nil", invalid)
+		}
+	}
+}
+
+func TestApplyPatch_DeadLetter(t *testing.T) {
+	patch, err := NewJSONPatch([]byte(`[{"op": "test", "path": "/status", "value": "active"}, {"op": "remove", "path": "/status"}]`))
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	rr := []turbine.Record{
+		{Key: "1", Payload: []byte(`{"id": 1, "status": "active"}`)},
+		{Key: "2", Payload: []byte(`{"id": 2, "status": "banned"}`)},
+	}
+
+	var dlq []turbine.RecordWithError
+	out := ApplyPatch{Patch: patch, DeadLetter: func(r turbine.RecordWithError) { dlq = append(dlq, r) }}.Process(rr)
+
+	if len(out) != 1 || out[0].Key != "1" || !jsonEqual(string(out[0].Payload), `{"id": 1}`) {
+		t.Fatalf("want only record 1 without status, got %v", out)
+	}
+	if len(dlq) != 1 || dlq[0].Key != "2" || !errors.Is(dlq[0].Error, ErrTestFailed) {
+		t.Fatalf("want record 2 dead lettered with ErrTestFailed, got %v", dlq)
+	}
+	if !jsonEqual(string(dlq[0].Payload), `{"id": 2, "status": "banned"}`) {
+		t.Fatalf("want the dead letter unpatched, got %s", dlq[0].Payload)
+	}
+}
+
+func TestPatchPayload_Schema(t *testing.T) {
+	patch, err := NewJSONPatch([]byte(`[
+		{"op": "add", "path": "/source", "value": "mongo"},
+		{"op": "remove", "path": "/user/email"},
+		{"op": "replace", "path": "/id", "value": 2},
+		{"op": "add", "path": "/actions/-", "value": "logout"},
+		{"op": "move", "from": "/user/name", "path": "/name"}
+	]`))
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	r := Wrap{}.Process([]turbine.Record{{Key: "1", Payload: []byte(nestedEvent)}})[0]
+
+	out := ApplyPatch{Patch: patch}.Process([]turbine.Record{r})
+
+	if len(out) != 1 {
+		t.Fatalf("want 1 record, got %d", len(out))
+	}
+	p := out[0].Payload
+	want := `{"id": 2, "user": {"id": 100}, "actions": ["register", "purchase", "logout"], "source": "mongo", "name": "alice"}`
+	if got := gjson.GetBytes(p, "payload").Raw; !jsonEqual(got, want) {
+		t.Fatalf("want payload %s, got %s", want, got)
+	}
+	wantSchema := `{"type":"struct","optional":false,"fields":[` +
+		`{"type":"int64","optional":false,"field":"id"},` +
+		`{"type":"struct","optional":false,"field":"user","fields":[` +
+		`{"type":"int64","optional":false,"field":"id"}]},` +
+		`{"type":"array","optional":false,"field":"actions","items":{"type":"string","optional":false}},` +
+		`{"type":"string","optional":true,"field":"source"},` +
+		`{"type":"string","optional":true,"field":"name"}]}`
+	if got := gjson.GetBytes(p, "schema").Raw; !jsonEqual(got, wantSchema) {
+		t.Fatalf("want schema %s, got %s", wantSchema, got)
+	}
+}
+
+func TestPatchPayload_MergePatch(t *testing.T) {
+	// examples from RFC 7396, appendix A
+	tests := []struct {
+		target string
+		patch  string
+		want   string
+	}{
+		{`{"a": "b"}`, `{"a": "c"}`, `{"a": "c"}`},
+		{`{"a": "b"}`, `{"b": "c"}`, `{"a": "b", "b": "c"}`},
+		{`{"a": "b"}`, `{"a": null}`, `{}`},
+		{`{"a": "b", "b": "c"}`, `{"a": null}`, `{"b": "c"}`},
+		{`{"a": ["b"]}`, `{"a": "c"}`, `{"a": "c"}`},
+		{`{"a": "c"}`, `{"a": ["b"]}`, `{"a": ["b"]}`},
+		{`{"a": {"b": "c"}}`, `{"a": {"b": "d", "c": null}}`, `{"a": {"b": "d"}}`},
+		{`{"a": [{"b": "c"}]}`, `{"a": [1]}`, `{"a": [1]}`},
+		{`["a", "b"]`, `["c", "d"]`, `["c", "d"]`},
+		{`{"a": "b"}`, `["c"]`, `["c"]`},
+		{`{"a": "foo"}`, `null`, `null`},
+		{`{"a": "foo"}`, `"bar"`, `"bar"`},
+		{`{"e": null}`, `{"a": 1}`, `{"e": null, "a": 1}`},
+		{`[1, 2]`, `{"a": "b", "c": null}`, `{"a": "b"}`},
+		{`{}`, `{"a": {"bb": {"ccc": null}}}`, `{"a": {"bb": {}}}`},
+	}
+
+	for _, tt := range tests {
+		patch, err := NewMergePatch([]byte(tt.patch))
+		if err != nil {
+			t.Fatalf("%s: want no error, got %s", tt.patch, err)
+		}
+		p := turbine.Payload(tt.target)
+		err = PatchPayload(&p, patch)
+		if err != nil {
+			t.Fatalf("%s to %s: want no error, got %s", tt.patch, tt.target, err)
+		}
+		if !jsonEqual(string(p), tt.want) {
+			t.Fatalf("%s to %s: want %s, got %s", tt.patch, tt.target, tt.want, p)
+		}
+	}
+}
+
+func TestPatchPayload_MergePatchSchema(t *testing.T) {
+	patch, err := NewMergePatch([]byte(`{"user": {"email": null, "plan": {"name": "pro"}}, "actions": null, "id": 3}`))
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	r := Wrap{}.Process([]turbine.Record{{Key: "1", Payload: []byte(nestedEvent)}})[0]
+
+	err = PatchPayload(&r.Payload, patch)
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+
+	p := r.Payload
+	want := `{"id": 3, "user": {"id": 100, "name": "alice", "plan": {"name": "pro"}}}`
+	if got := gjson.GetBytes(p, "payload").Raw; !jsonEqual(got, want) {
+		t.Fatalf("want payload %s, got %s", want, got)
+	}
+	wantSchema := `{"type":"struct","optional":false,"fields":[` +
+		`{"type":"int64","optional":false,"field":"id"},` +
+		`{"type":"struct","optional":false,"field":"user","fields":[` +
+		`{"type":"int64","optional":false,"field":"id"},` +
+		`{"type":"string","optional":false,"field":"name"},` +
+		`{"type":"struct","optional":true,"field":"plan","fields":[{"type":"string","optional":false,"field":"name"}]}]}]}`
+	if got := gjson.GetBytes(p, "schema").Raw; !jsonEqual(got, wantSchema) {
+		t.Fatalf("want schema %s, got %s", wantSchema, got)
+	}
+}
+
+func TestNewFilePatch(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		file  string
+		patch string
+		want  string
+	}{
+		{"add.json", `[{"op": "add", "path": "/source", "value": "mongo"}]`, `{"id": 1, "source": "mongo"}`},
+		{"merge.json", `{"source": "mongo", "id": null}`, `{"source": "mongo"}`},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.file)
+		err := os.WriteFile(path, []byte(tt.patch), 0o600)
+		if err != nil {
+			t.Fatalf("want no error, got %s", err)
+		}
+		patch, err := NewFilePatch(path)
+		if err != nil {
+			t.Fatalf("%s: want no error, got %s", tt.file, err)
+		}
+		p := turbine.Payload(`{"id": 1}`)
+		err = PatchPayload(&p, patch)
+		if err != nil {
+			t.Fatalf("%s: want no error, got %s", tt.file, err)
+		}
+		if !jsonEqual(string(p), tt.want) {
+			t.Fatalf("%s: want %s, got %s", tt.file, tt.want, p)
+		}
+	}
+
+	if _, err := NewFilePatch(filepath.Join(dir, "missing.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("want os.ErrNotExist, got %v", err)
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/meroxa/turbine-go"
+)
+
+// Pipeline is a Function applying its Steps in order, each to the records
+// returned by the previous one. It is built from the "transforms" section of
+// app.json, so field-level jobs need no Process loop of their own:
+//
+//	"transforms": [
+//	  {"type": "unwrap"},
+//	  {"type": "flatten"},
+//	  {"type": "rename", "fields": {"user\\.email": "email"}},
+//	  {"type": "hash", "fields": ["email"]},
+//	  {"type": "remove", "fields": ["user\\.password"]}
+//	]
+//
+// A Pipeline is an ordinary Function: the local runner applies it and the
+// platform serves it with platform.ServeFunc, under the name "pipeline".
+type Pipeline struct {
+	Steps []turbine.Function
+}
+
+func (p Pipeline) Process(rr []turbine.Record) []turbine.Record {
+	for _, s := range p.Steps {
+		if len(rr) == 0 {
+			break
+		}
+		rr = s.Process(rr)
+	}
+	return rr
+}
+
+// pipelineSteps maps the type of a step to the function building it.
+var pipelineSteps = map[string]func(s pipelineStep) (turbine.Function, error){
+	"unwrap": func(s pipelineStep) (turbine.Function, error) {
+		return Unwrap{}, s.decode(&struct{}{})
+	},
+	"explode": func(s pipelineStep) (turbine.Function, error) {
+		var params struct {
+			Path string `json:"path"`
+		}
+		if err := s.decode(&params); err != nil {
+			return nil, err
+		}
+		if params.Path == "" {
+			return nil, fmt.Errorf("no path")
+		}
+		return Explode{Path: params.Path}, nil
+	},
+	"flatten": func(s pipelineStep) (turbine.Function, error) {
+		var opts FlattenOptions
+		if err := s.decode(&opts); err != nil {
+			return nil, err
+		}
+		switch opts.Arrays {
+		case "", ArraysIndex, ArraysJoin, ArraysJSON, ArraysNested:
+		default:
+			return nil, fmt.Errorf("unknown arrays %q", opts.Arrays)
+		}
+		if opts.MaxDepth < 0 {
+			return nil, fmt.Errorf("negative max_depth")
+		}
+		return Flatten{Options: opts}, nil
+	},
+	"sanitize": func(s pipelineStep) (turbine.Function, error) {
+		var params struct {
+			Rules        string `json:"rules"`
+			MappingField string `json:"mapping_field"`
+		}
+		if err := s.decode(&params); err != nil {
+			return nil, err
+		}
+		for _, rules := range []NamingRules{PostgresRules, SnowflakeRules, RedshiftRules} {
+			if rules.Name == params.Rules {
+				return NewSanitize(rules, params.MappingField), nil
+			}
+		}
+		return nil, fmt.Errorf("unknown rules %q (want postgres, snowflake or redshift)", params.Rules)
+	},
+	"wrap": func(s pipelineStep) (turbine.Function, error) {
+		var opts WrapOptions
+		if err := s.decode(&opts); err != nil {
+			return nil, err
+		}
+		return Wrap{Options: opts}, nil
+	},
+	"extract_new_state": func(s pipelineStep) (turbine.Function, error) {
+		opts, err := changeOptions(s)
+		return ExtractNewState{Options: opts}, err
+	},
+	"envelope": func(s pipelineStep) (turbine.Function, error) {
+		opts, err := changeOptions(s)
+		return Envelope{Options: opts}, err
+	},
+	"patch": func(s pipelineStep) (turbine.Function, error) {
+		var params struct {
+			Patch json.RawMessage `json:"patch"`
+		}
+		if err := s.decode(&params); err != nil {
+			return nil, err
+		}
+		if len(params.Patch) == 0 {
+			return nil, fmt.Errorf("no patch")
+		}
+		patch, err := NewPatch(params.Patch)
+		if err != nil {
+			return nil, err
+		}
+		return ApplyPatch{Patch: patch}, nil
+	},
+	"rename": func(s pipelineStep) (turbine.Function, error) {
+		var params struct {
+			Fields map[string]string `json:"fields"`
+		}
+		if err := s.decode(&params); err != nil {
+			return nil, err
+		}
+		if len(params.Fields) == 0 {
+			return nil, fmt.Errorf("no fields")
+		}
+		to := make(map[string]string, len(params.Fields))
+		for from, path := range params.Fields {
+			if path == "" {
+				return nil, fmt.Errorf("empty new name for %s", from)
+			}
+			if other, ok := to[path]; ok {
+				return nil, fmt.Errorf("%s and %s both renamed to %s", other, from, path)
+			}
+			to[path] = from
+		}
+		return Rename{Fields: params.Fields}, nil
+	},
+	"hash": func(s pipelineStep) (turbine.Function, error) {
+		var params struct {
+			Fields []string `json:"fields"`
+		}
+		if err := s.decode(&params); err != nil {
+			return nil, err
+		}
+		if len(params.Fields) == 0 {
+			return nil, fmt.Errorf("no fields")
+		}
+		return Hash{Fields: params.Fields}, nil
+	},
+	"filter": func(s pipelineStep) (turbine.Function, error) {
+		var params struct {
+			Where string `json:"where"`
+		}
+		if err := s.decode(&params); err != nil {
+			return nil, err
+		}
+		return NewFilter(params.Where)
+	},
+	"set": func(s pipelineStep) (turbine.Function, error) {
+		var params struct {
+			Field string `json:"field"`
+			Expr  string `json:"expr"`
+		}
+		if err := s.decode(&params); err != nil {
+			return nil, err
+		}
+		return NewSet(params.Field, params.Expr)
+	},
+	"sql": func(s pipelineStep) (turbine.Function, error) {
+		var params struct {
+			Query string `json:"query"`
+		}
+		if err := s.decode(&params); err != nil {
+			return nil, err
+		}
+		return NewSQL(params.Query)
+	},
+	"remove": func(s pipelineStep) (turbine.Function, error) {
+		var params struct {
+			Fields []string `json:"fields"`
+		}
+		if err := s.decode(&params); err != nil {
+			return nil, err
+		}
+		if len(params.Fields) == 0 {
+			return nil, fmt.Errorf("no fields")
+		}
+		return Remove{Fields: params.Fields}, nil
+	},
+}
+
+// changeOptions decodes the parameters shared by the extract_new_state and
+// envelope steps.
+func changeOptions(s pipelineStep) (ChangeOptions, error) {
+	var opts ChangeOptions
+	if err := s.decode(&opts); err != nil {
+		return ChangeOptions{}, err
+	}
+	switch opts.Deletes {
+	case "", DeletesDrop, DeletesRewrite:
+	default:
+		return ChangeOptions{}, fmt.Errorf("unknown deletes %q", opts.Deletes)
+	}
+	return opts, nil
+}
+
+// pipelineStep is a step of the "transforms" section: its type and the JSON
+// object holding its parameters.
+type pipelineStep struct {
+	Type   string
+	params json.RawMessage
+}
+
+func (s *pipelineStep) UnmarshalJSON(b []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(fields["type"], &s.Type); err != nil || s.Type == "" {
+		return fmt.Errorf("missing type")
+	}
+	delete(fields, "type")
+	params, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	s.params = params
+	return nil
+}
+
+// decode decodes the parameters of s into v, rejecting unknown parameters so
+// that typos are reported at startup rather than ignored.
+func (s pipelineStep) decode(v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(s.params))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// NewPipeline builds a Pipeline from a JSON array of steps, each an object
+// with a "type" and the parameters of that type. The whole config is
+// validated, so the app fails at startup rather than on the first record.
+func NewPipeline(config []byte) (Pipeline, error) {
+	var steps []json.RawMessage
+	if err := json.Unmarshal(config, &steps); err != nil {
+		return Pipeline{}, fmt.Errorf("transforms: %w", err)
+	}
+
+	var p Pipeline
+	for i, raw := range steps {
+		var s pipelineStep
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return Pipeline{}, fmt.Errorf("transforms step %d: %w", i, err)
+		}
+		build, ok := pipelineSteps[s.Type]
+		if !ok {
+			return Pipeline{}, fmt.Errorf("transforms step %d: unknown type %q (want one of %s)", i, s.Type, strings.Join(pipelineStepTypes(), ", "))
+		}
+		fn, err := build(s)
+		if err != nil {
+			return Pipeline{}, fmt.Errorf("transforms step %d (%s): %w", i, s.Type, err)
+		}
+		p.Steps = append(p.Steps, fn)
+	}
+	return p, nil
+}
+
+// ReadPipeline builds a Pipeline from the "transforms" section of the app
+// config named name in fsys. A config without one gives an empty Pipeline,
+// passing records on unchanged.
+func ReadPipeline(fsys fs.FS, name string) (Pipeline, error) {
+	b, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return Pipeline{}, err
+	}
+	var config struct {
+		Transforms json.RawMessage `json:"transforms"`
+	}
+	if err := json.Unmarshal(b, &config); err != nil {
+		return Pipeline{}, fmt.Errorf("%s: %w", name, err)
+	}
+	if config.Transforms == nil {
+		return Pipeline{}, nil
+	}
+	p, err := NewPipeline(config.Transforms)
+	if err != nil {
+		return Pipeline{}, fmt.Errorf("%s: %w", name, err)
+	}
+	return p, nil
+}
+
+func pipelineStepTypes() []string {
+	types := make([]string, 0, len(pipelineSteps))
+	for t := range pipelineSteps {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
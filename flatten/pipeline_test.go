@@ -0,0 +1,158 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/meroxa/turbine-go"
+	"github.com/tidwall/gjson"
+)
+
+func TestPipeline(t *testing.T) {
+	p, err := NewPipeline([]byte(`[
+		{"type": "filter", "where": "user.id > 10"},
+		{"type": "set", "field": "adult", "expr": "user.age >= 18"},
+		{"type": "flatten"},
+		{"type": "rename", "fields": {"user\\.email": "email"}},
+		{"type": "hash", "fields": ["email"]},
+		{"type": "remove", "fields": ["user\\.name", "actions"]}
+	]`))
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+
+	out := p.Process([]turbine.Record{{
+		Key:     "1",
+		Payload: []byte(`{"id": 1, "user": {"id": 100, "name": "alice", "email": "alice@example.com", "age": 30}}`),
+	}, {
+		Key:     "2",
+		Payload: []byte(`{"id": 2, "user": {"id": 5, "name": "bob", "email": "bob@example.com", "age": 12}}`),
+	}})
+	if len(out) != 1 {
+		t.Fatalf("want 1 record, got %d", len(out))
+	}
+
+	got := gjson.ParseBytes(out[0].Payload)
+	if got.Get("email").String() != "ff8d9819fc0e12bf0d24892e45987e249a28dce836a85cad60e28eaaa8c6d976" {
+		t.Fatalf("want hashed email, got %s", out[0].Payload)
+	}
+	if got.Get(`user\.name`).Exists() || got.Get(`user\.email`).Exists() {
+		t.Fatalf("want user.name and user.email gone, got %s", out[0].Payload)
+	}
+	if got.Get(`user\.id`).Int() != 100 || !got.Get("adult").Bool() {
+		t.Fatalf("want user.id 100 and adult, got %s", out[0].Payload)
+	}
+}
+
+func TestNewPipelineErrors(t *testing.T) {
+	tests := map[string]struct {
+		config string
+		want   string
+	}{
+		"not a list":     {`{"type": "flatten"}`, "transforms:"},
+		"missing type":   {`[{"fields": ["a"]}]`, "transforms step 0: missing type"},
+		"unknown type":   {`[{"type": "flatten"}, {"type": "flaten"}]`, `transforms step 1: unknown type "flaten"`},
+		"unknown param":  {`[{"type": "hash", "field": ["a"]}]`, `transforms step 0 (hash): json: unknown field "field"`},
+		"bad expression": {`[{"type": "filter", "where": "a >"}]`, "transforms step 0 (filter): a >: 1:4: unexpected end of expression"},
+		"no fields":      {`[{"type": "remove", "fields": []}]`, "transforms step 0 (remove): no fields"},
+		"no path":        {`[{"type": "explode"}]`, "transforms step 0 (explode): no path"},
+		"bad rules":      {`[{"type": "sanitize", "rules": "mysql"}]`, `transforms step 0 (sanitize): unknown rules "mysql"`},
+		"bad deletes":    {`[{"type": "extract_new_state", "deletes": "keep"}]`, `transforms step 0 (extract_new_state): unknown deletes "keep"`},
+		"bad patch":      {`[{"type": "patch", "patch": [{"op": "mv", "path": "/a"}]}]`, `transforms step 0 (patch): operation 0: unknown operation "mv"`},
+		"bad arrays":     {`[{"type": "flatten", "arrays": "zip"}]`, `transforms step 0 (flatten): unknown arrays "zip"`},
+		"same new names": {`[{"type": "rename", "fields": {"a": "c", "b": "c"}}]`, "both renamed to c"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewPipeline([]byte(tt.config))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("want error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestReadPipeline(t *testing.T) {
+	fsys := fstest.MapFS{
+		"with.json":    {Data: []byte(`{"name": "a", "transforms": [{"type": "unwrap"}, {"type": "flatten"}]}`)},
+		"without.json": {Data: []byte(`{"name": "a"}`)},
+	}
+
+	p, err := ReadPipeline(fsys, "with.json")
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	if len(p.Steps) != 2 {
+		t.Fatalf("want 2 steps, got %d", len(p.Steps))
+	}
+
+	p, err = ReadPipeline(fsys, "without.json")
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	if len(p.Steps) != 0 {
+		t.Fatalf("want no steps, got %d", len(p.Steps))
+	}
+
+	// The app's own config must build, and give records the schema cmd/ddl
+	// needs.
+	app, err := ReadPipeline(appConfig, "app.json")
+	if err != nil {
+		t.Fatalf("want app.json to build, got %s", err)
+	}
+	out := app.Process(readFixtureRecords(t, "fixtures/nested.json", "events"))
+	if len(out) == 0 {
+		t.Fatalf("want app.json to keep the fixture records")
+	}
+	for _, r := range out {
+		if !gjson.GetBytes(r.Payload, "schema").IsObject() {
+			t.Fatalf("want app.json records to have a schema, got %s", r.Payload)
+		}
+	}
+}
+
+func TestPipelineFlattenOptions(t *testing.T) {
+	p, err := NewPipeline([]byte(`[{"type": "flatten", "delimiter": "_", "arrays": "join", "join_separator": "|"}]`))
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+
+	out := p.Process([]turbine.Record{{
+		Key:     "1",
+		Payload: []byte(`{"user": {"id": 100}, "actions": ["register", "purchase"]}`),
+	}})
+	got := gjson.ParseBytes(out[0].Payload)
+	if got.Get("user_id").Int() != 100 || got.Get("actions").String() != "register|purchase" {
+		t.Fatalf("want user_id 100 and joined actions, got %s", out[0].Payload)
+	}
+}
+
+func TestPipelineWrap(t *testing.T) {
+	p, err := NewPipeline([]byte(`[{"type": "wrap", "name": "event", "integer_type": "float64"}]`))
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+
+	out := p.Process([]turbine.Record{{Key: "1", Payload: []byte(`{"id": 1}`)}})
+	got := gjson.ParseBytes(out[0].Payload)
+	if got.Get("schema.name").String() != "event" || got.Get("schema.fields.0.type").String() != "float64" {
+		t.Fatalf("want a float64 id in an event schema, got %s", out[0].Payload)
+	}
+}
+
+func TestPipelinePatch(t *testing.T) {
+	p, err := NewPipeline([]byte(`[
+		{"type": "patch", "patch": [{"op": "add", "path": "/source", "value": "mongo"}]},
+		{"type": "patch", "patch": {"user": {"email": null}}}
+	]`))
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+
+	out := p.Process([]turbine.Record{{Key: "1", Payload: []byte(`{"user": {"id": 100, "email": "alice@example.com"}}`)}})
+	got := gjson.ParseBytes(out[0].Payload)
+	if got.Get("source").String() != "mongo" || got.Get("user.email").Exists() {
+		t.Fatalf("want source added and user.email removed, got %s", out[0].Payload)
+	}
+}
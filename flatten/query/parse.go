@@ -0,0 +1,683 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/meroxa/flatten/expr"
+)
+
+// statement is a parsed SELECT statement, with its expressions translated
+// to package expr.
+type statement struct {
+	items []item
+	where expr.Node
+}
+
+// item is an item of the select list.
+type item struct {
+	pos    int
+	star   bool      // *
+	name   string    // output field
+	node   expr.Node // the expression, nil for *
+	column []string  // the field names of a plain column reference
+}
+
+// parse parses a SELECT statement.
+func parse(src string) (*statement, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{src: src, tokens: tokens}
+	return p.statement()
+}
+
+type parser struct {
+	src    string
+	tokens []token
+	i      int
+
+	from string // table name or alias qualifying column references
+}
+
+func (p *parser) peek() token { return p.tokens[p.i] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) is(kind tokenKind, text string) bool {
+	t := p.peek()
+	return t.kind == kind && t.text == text
+}
+
+func (p *parser) isKeyword(kw string) bool { return p.is(tokKeyword, kw) }
+
+func (p *parser) isOp(op string) bool { return p.is(tokOp, op) }
+
+func (p *parser) expectKeyword(kw string) error {
+	if t := p.next(); t.kind != tokKeyword || t.text != kw {
+		return p.errorf(t, "want %s, got %s", kw, describe(t))
+	}
+	return nil
+}
+
+func (p *parser) expectOp(op string) error {
+	if t := p.next(); t.kind != tokOp || t.text != op {
+		return p.errorf(t, "want %q, got %s", op, describe(t))
+	}
+	return nil
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	return &expr.Error{Src: p.src, Pos: t.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) statement() (*statement, error) {
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+
+	// Read FROM first, so that column references can be qualified with the
+	// table name in the select list.
+	start := p.i
+	depth := 0
+	for t := p.peek(); t.kind != tokEOF; t = p.peek() {
+		if t.kind == tokOp && t.text == "(" {
+			depth++
+		}
+		if t.kind == tokOp && t.text == ")" {
+			depth--
+		}
+		if depth == 0 && t.kind == tokKeyword && (t.text == "FROM" || t.text == "WHERE") {
+			break
+		}
+		p.next()
+	}
+	end := p.i
+	if p.isKeyword("FROM") {
+		p.next()
+		t := p.next()
+		if t.kind != tokIdent {
+			return nil, p.errorf(t, "want table name, got %s", describe(t))
+		}
+		p.from = t.text
+		if p.isKeyword("AS") {
+			p.next()
+		}
+		if p.peek().kind == tokIdent {
+			p.from = p.next().text
+		}
+	}
+	rest := p.i
+
+	s := &statement{}
+	p.i = start
+	for {
+		it, err := p.item()
+		if err != nil {
+			return nil, err
+		}
+		s.items = append(s.items, it)
+		if !p.isOp(",") {
+			break
+		}
+		p.next()
+	}
+	if p.i != end {
+		return nil, p.errorf(p.peek(), "unexpected %s", describe(p.peek()))
+	}
+
+	p.i = rest
+	if p.isKeyword("WHERE") {
+		p.next()
+		where, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		s.where = where
+	}
+	if p.isOp(";") {
+		p.next()
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected %s", describe(t))
+	}
+
+	seen := map[string]bool{}
+	stars := 0
+	for _, it := range s.items {
+		if it.star {
+			stars++
+			if stars > 1 {
+				return nil, &expr.Error{Src: p.src, Pos: it.pos, Msg: "* selected twice"}
+			}
+			continue
+		}
+		if seen[it.name] {
+			return nil, &expr.Error{Src: p.src, Pos: it.pos, Msg: fmt.Sprintf("%s selected twice", it.name)}
+		}
+		seen[it.name] = true
+	}
+	return s, nil
+}
+
+func (p *parser) item() (item, error) {
+	t := p.peek()
+	if p.isOp("*") {
+		p.next()
+		return item{pos: t.pos, star: true}, nil
+	}
+
+	n, err := p.expr()
+	if err != nil {
+		return item{}, err
+	}
+	it := item{pos: t.pos, node: n}
+	if names, ok := columnNames(n); ok {
+		it.column = names
+		it.name = names[len(names)-1]
+	}
+
+	if p.isKeyword("AS") {
+		p.next()
+	}
+	if a := p.peek(); a.kind == tokIdent {
+		p.next()
+		it.name = a.text
+	}
+	if it.name == "" {
+		return item{}, p.errorf(t, "expression needs a name: add AS name")
+	}
+	return it, nil
+}
+
+// columnNames returns the field names of the column n refers to, if it is a
+// plain column reference.
+func columnNames(n expr.Node) ([]string, bool) {
+	var names []string
+	for {
+		switch x := n.(type) {
+		case *expr.Ident:
+			names = append([]string{x.Name}, names...)
+			return names, true
+		case *expr.Select:
+			names = append([]string{x.Field}, names...)
+			n = x.X
+		default:
+			return nil, false
+		}
+	}
+}
+
+func (p *parser) expr() (expr.Node, error) { return p.or() }
+
+func (p *parser) or() (expr.Node, error) {
+	x, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("OR") {
+		t := p.next()
+		y, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		x = &expr.Binary{At: t.pos, Op: "||", X: x, Y: y}
+	}
+	return x, nil
+}
+
+func (p *parser) and() (expr.Node, error) {
+	x, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("AND") {
+		t := p.next()
+		y, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		x = &expr.Binary{At: t.pos, Op: "&&", X: x, Y: y}
+	}
+	return x, nil
+}
+
+func (p *parser) not() (expr.Node, error) {
+	if p.isKeyword("NOT") {
+		t := p.next()
+		x, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return &expr.Unary{At: t.pos, Op: "!", X: x}, nil
+	}
+	return p.predicate()
+}
+
+var comparisons = map[string]string{"=": "==", "<>": "!=", "!=": "!=", "<": "<", "<=": "<=", ">": ">", ">=": ">="}
+
+func (p *parser) predicate() (expr.Node, error) {
+	x, err := p.concat()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	if op, ok := comparisons[t.text]; ok && t.kind == tokOp {
+		p.next()
+		y, err := p.concat()
+		if err != nil {
+			return nil, err
+		}
+		return &expr.Binary{At: t.pos, Op: op, X: x, Y: y}, nil
+	}
+
+	if p.isKeyword("IS") {
+		p.next()
+		op := "=="
+		if p.isKeyword("NOT") {
+			p.next()
+			op = "!="
+		}
+		if err := p.expectKeyword("NULL"); err != nil {
+			return nil, err
+		}
+		return &expr.Binary{At: t.pos, Op: op, X: x, Y: &expr.Literal{At: t.pos}}, nil
+	}
+
+	negate := false
+	if p.isKeyword("NOT") {
+		p.next()
+		negate = true
+	}
+	op := p.peek()
+	if op.kind != tokKeyword || op.text != "LIKE" && op.text != "IN" && op.text != "BETWEEN" {
+		if negate {
+			return nil, p.errorf(op, "want LIKE, IN or BETWEEN after NOT, got %s", describe(op))
+		}
+		return x, nil
+	}
+	p.next()
+
+	var n expr.Node
+	switch op.text {
+	case "LIKE":
+		pattern, err := p.concat()
+		if err != nil {
+			return nil, err
+		}
+		n = &expr.Call{At: op.pos, Name: "like", Args: []expr.Node{x, pattern}}
+
+	case "IN":
+		if err := p.expectOp("("); err != nil {
+			return nil, err
+		}
+		l := &expr.ListLit{At: op.pos}
+		for {
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			l.Elems = append(l.Elems, e)
+			if !p.isOp(",") {
+				break
+			}
+			p.next()
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+		n = &expr.Binary{At: op.pos, Op: "in", X: x, Y: l}
+
+	case "BETWEEN":
+		lo, err := p.concat()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("AND"); err != nil {
+			return nil, err
+		}
+		hi, err := p.concat()
+		if err != nil {
+			return nil, err
+		}
+		n = &expr.Binary{At: op.pos, Op: "&&",
+			X: &expr.Binary{At: op.pos, Op: ">=", X: x, Y: lo},
+			Y: &expr.Binary{At: op.pos, Op: "<=", X: x, Y: hi},
+		}
+
+	}
+	if negate {
+		n = &expr.Unary{At: t.pos, Op: "!", X: n}
+	}
+	return n, nil
+}
+
+// concat parses || concatenations, which convert their operands to strings.
+func (p *parser) concat() (expr.Node, error) {
+	x, err := p.additive()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("||") {
+		t := p.next()
+		y, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		x = &expr.Binary{At: t.pos, Op: "+", X: toString(x), Y: toString(y)}
+	}
+	return x, nil
+}
+
+func toString(n expr.Node) expr.Node {
+	return &expr.Call{At: n.Pos(), Name: "string", Args: []expr.Node{n}}
+}
+
+func (p *parser) additive() (expr.Node, error) {
+	x, err := p.multiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("+") || p.isOp("-") {
+		t := p.next()
+		y, err := p.multiplicative()
+		if err != nil {
+			return nil, err
+		}
+		x = &expr.Binary{At: t.pos, Op: t.text, X: x, Y: y}
+	}
+	return x, nil
+}
+
+func (p *parser) multiplicative() (expr.Node, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("*") || p.isOp("/") || p.isOp("%") {
+		t := p.next()
+		y, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		x = &expr.Binary{At: t.pos, Op: t.text, X: x, Y: y}
+	}
+	return x, nil
+}
+
+func (p *parser) unary() (expr.Node, error) {
+	if p.isOp("-") || p.isOp("+") {
+		t := p.next()
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if t.text == "+" {
+			return x, nil
+		}
+		return &expr.Unary{At: t.pos, Op: "-", X: x}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (expr.Node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokInt:
+		v, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			return nil, p.errorf(t, "integer %s out of range", t.text)
+		}
+		return &expr.Literal{At: t.pos, Value: v}, nil
+
+	case tokFloat:
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf(t, "invalid number %s", t.text)
+		}
+		return &expr.Literal{At: t.pos, Value: v}, nil
+
+	case tokString:
+		return &expr.Literal{At: t.pos, Value: t.text}, nil
+
+	case tokKeyword:
+		switch t.text {
+		case "NULL":
+			return &expr.Literal{At: t.pos}, nil
+		case "TRUE", "FALSE":
+			return &expr.Literal{At: t.pos, Value: t.text == "TRUE"}, nil
+		case "CASE":
+			return p.caseExpr(t)
+		case "CAST":
+			return p.cast(t)
+		}
+
+	case tokIdent:
+		if p.isOp("(") {
+			return p.call(t)
+		}
+		return p.column(t)
+
+	case tokOp:
+		if t.text == "(" {
+			x, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expectOp(")")
+		}
+	}
+	return nil, p.errorf(t, "unexpected %s", describe(t))
+}
+
+// column parses a column reference starting with t, dropping the table
+// name or alias if it is qualified with one.
+func (p *parser) column(t token) (expr.Node, error) {
+	names := []string{t.text}
+	for p.isOp(".") {
+		p.next()
+		f := p.next()
+		if f.kind != tokIdent {
+			return nil, p.errorf(f, "want field name, got %s", describe(f))
+		}
+		names = append(names, f.text)
+	}
+	if len(names) > 1 && p.from != "" && names[0] == p.from {
+		names = names[1:]
+	}
+	var n expr.Node = &expr.Ident{At: t.pos, Name: names[0]}
+	for _, name := range names[1:] {
+		n = &expr.Select{At: t.pos, X: n, Field: name}
+	}
+	return n, nil
+}
+
+func (p *parser) args() ([]expr.Node, error) {
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	var args []expr.Node
+	for !p.isOp(")") {
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, x)
+		if !p.isOp(",") {
+			break
+		}
+		p.next()
+	}
+	return args, p.expectOp(")")
+}
+
+// caseExpr parses the rest of CASE [x] WHEN ... THEN ... [ELSE ...] END.
+func (p *parser) caseExpr(t token) (expr.Node, error) {
+	var subject expr.Node
+	if !p.isKeyword("WHEN") {
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		subject = x
+	}
+
+	type branch struct {
+		at         int
+		cond, then expr.Node
+	}
+	var branches []branch
+	for p.isKeyword("WHEN") {
+		w := p.next()
+		cond, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if subject != nil {
+			cond = &expr.Binary{At: w.pos, Op: "==", X: subject, Y: cond}
+		}
+		if err := p.expectKeyword("THEN"); err != nil {
+			return nil, err
+		}
+		then, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		branches = append(branches, branch{at: w.pos, cond: cond, then: then})
+	}
+	if len(branches) == 0 {
+		return nil, p.errorf(p.peek(), "want WHEN, got %s", describe(p.peek()))
+	}
+
+	var els expr.Node = &expr.Literal{At: t.pos}
+	if p.isKeyword("ELSE") {
+		p.next()
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		els = x
+	}
+	if err := p.expectKeyword("END"); err != nil {
+		return nil, err
+	}
+
+	for i := len(branches) - 1; i >= 0; i-- {
+		b := branches[i]
+		els = &expr.Cond{At: b.at, Cond: b.cond, Then: b.then, Else: els}
+	}
+	return els, nil
+}
+
+// casts maps SQL types to the expr functions converting to them.
+var casts = map[string]string{
+	"INT": "int", "INTEGER": "int", "BIGINT": "int", "SMALLINT": "int",
+	"FLOAT": "float", "DOUBLE": "float", "REAL": "float", "NUMERIC": "float", "DECIMAL": "float",
+	"TEXT": "string", "VARCHAR": "string", "CHAR": "string", "STRING": "string",
+	"BOOLEAN": "bool", "BOOL": "bool",
+	"TIMESTAMP": "timestamp",
+}
+
+// cast parses the rest of CAST(x AS type).
+func (p *parser) cast(t token) (expr.Node, error) {
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	x, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("AS"); err != nil {
+		return nil, err
+	}
+	typ := p.next()
+	fn, ok := casts[strings.ToUpper(typ.text)]
+	if typ.kind != tokIdent || !ok {
+		return nil, p.errorf(typ, "unknown type %s", describe(typ))
+	}
+	// Skip a length or precision, as in VARCHAR(20) or NUMERIC(10, 2).
+	if p.isOp("(") {
+		if _, err := p.args(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectOp(")"); err != nil {
+		return nil, err
+	}
+	return &expr.Call{At: t.pos, Name: fn, Args: []expr.Node{x}}, nil
+}
+
+// call parses a call of the SQL function named by t.
+func (p *parser) call(t token) (expr.Node, error) {
+	args, err := p.args()
+	if err != nil {
+		return nil, err
+	}
+	name := strings.ToUpper(t.text)
+	switch name {
+	case "SUBSTRING", "SUBSTR":
+		// SUBSTRING(s, from[, length]) counts from 1.
+		if len(args) < 2 || len(args) > 3 {
+			return nil, p.errorf(t, "%s needs 2 or 3 arguments, got %d", name, len(args))
+		}
+		start := &expr.Binary{At: t.pos, Op: "-", X: args[1], Y: &expr.Literal{At: t.pos, Value: int64(1)}}
+		sub := []expr.Node{args[0], start}
+		if len(args) == 3 {
+			sub = append(sub, &expr.Binary{At: t.pos, Op: "+", X: start, Y: args[2]})
+		}
+		return &expr.Call{At: t.pos, Name: "substring", Args: sub}, nil
+
+	case "CONCAT":
+		// CONCAT skips nulls, unlike ||.
+		var n expr.Node = &expr.Literal{At: t.pos, Value: ""}
+		for _, a := range args {
+			s := &expr.Call{At: a.Pos(), Name: "coalesce", Args: []expr.Node{toString(a), &expr.Literal{At: a.Pos(), Value: ""}}}
+			n = &expr.Binary{At: t.pos, Op: "+", X: n, Y: s}
+		}
+		return n, nil
+
+	case "NULLIF":
+		if len(args) != 2 {
+			return nil, p.errorf(t, "NULLIF needs 2 arguments, got %d", len(args))
+		}
+		return &expr.Cond{At: t.pos,
+			Cond: &expr.Binary{At: t.pos, Op: "==", X: args[0], Y: args[1]},
+			Then: &expr.Literal{At: t.pos},
+			Else: args[0],
+		}, nil
+	}
+
+	fn, ok := sqlFunctions[name]
+	if !ok {
+		return nil, p.errorf(t, "unknown function %s", t.text)
+	}
+	return &expr.Call{At: t.pos, Name: fn, Args: args}, nil
+}
+
+// sqlFunctions maps SQL scalar functions to expr functions.
+var sqlFunctions = map[string]string{
+	"LOWER": "lower", "UPPER": "upper", "TRIM": "trim",
+	"LENGTH": "size", "CHAR_LENGTH": "size",
+	"REPLACE": "replace", "COALESCE": "coalesce",
+	"ABS": "abs", "CEIL": "ceil", "CEILING": "ceil", "FLOOR": "floor", "ROUND": "round",
+	"YEAR": "year", "MONTH": "month", "DAY": "day", "HOUR": "hour", "MINUTE": "minute", "SECOND": "second",
+}
+
+func describe(t token) string {
+	switch t.kind {
+	case tokEOF:
+		return "end of statement"
+	case tokString:
+		return "'" + t.text + "'"
+	}
+	return t.text
+}
@@ -0,0 +1,290 @@
+// Package query evaluates SQL SELECT statements over record payloads:
+//
+//	SELECT id, lower(email) AS email,
+//	       CASE WHEN deleted_at IS NULL THEN 'active' ELSE 'deleted' END AS status,
+//	       CAST(user_id AS TEXT) || '-' || activity AS event
+//	FROM user_activity
+//	WHERE activity <> 'logged_out'
+//
+// Each payload is a row and each top-level field a column; nested fields are
+// read with ".". A statement selects columns, "*" or expressions, which need
+// a name (AS name) unless they are plain columns. It supports WHERE, CASE,
+// CAST to INTEGER, FLOAT, TEXT, BOOLEAN and TIMESTAMP, IS [NOT] NULL, [NOT]
+// LIKE, [NOT] IN, [NOT] BETWEEN, || and common scalar functions. FROM names
+// the table, only to qualify columns: which records are read is up to the
+// app. Double quotes quote column names, so "user.email" is the flattened
+// key.
+//
+// Expressions are compiled to package expr, and checked against the Kafka
+// Connect schema of the records, once per schema. The schema of the result
+// is derived from the select list: plain columns keep their schema field and
+// expressions get one of their type.
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/meroxa/flatten/expr"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// Query is a compiled SELECT statement.
+type Query struct {
+	src  string
+	stmt *statement
+
+	mu    sync.Mutex
+	plans map[string]planResult // by schema, "" for records without one
+}
+
+type planResult struct {
+	plan *plan
+	err  error
+}
+
+// Compile parses the SELECT statement src and checks it against records
+// without a schema, which reports syntax errors, unknown functions and type
+// errors that don't depend on the schema.
+func Compile(src string) (*Query, error) {
+	stmt, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+	q := &Query{src: src, stmt: stmt, plans: map[string]planResult{}}
+	if _, err := q.plan(gjson.Result{}); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Apply evaluates q on payload, a JSON row or a {"schema": ..., "payload": ...}
+// envelope. It returns the selected row, in the same form, and whether the
+// row passes the WHERE clause.
+func (q *Query) Apply(payload []byte) ([]byte, bool, error) {
+	res := gjson.GetManyBytes(payload, "schema", "payload")
+	withSchema := res[0].IsObject() && res[1].Exists()
+	schema, row := gjson.Result{}, gjson.ParseBytes(payload)
+	if withSchema {
+		schema, row = res[0], res[1]
+	}
+
+	p, err := q.plan(schema)
+	if err != nil {
+		return nil, false, err
+	}
+	rowJSON := []byte(row.Raw)
+
+	if p.where != nil {
+		v, err := p.where.Eval(rowJSON)
+		if err != nil {
+			return nil, false, err
+		}
+		if v != true {
+			return nil, false, nil
+		}
+	}
+
+	out := []byte(`{}`)
+	for _, c := range p.columns {
+		if c.star {
+			// Without a schema, * selects whatever fields the row has.
+			row.ForEach(func(k, v gjson.Result) bool {
+				out, err = sjson.SetRawBytes(out, escapePath(k.Str), []byte(v.Raw))
+				return err == nil
+			})
+			if err != nil {
+				return nil, false, err
+			}
+			continue
+		}
+
+		var raw []byte
+		if c.program == nil {
+			raw = []byte(row.Get(c.path).Raw)
+		} else {
+			v, err := c.program.Eval(rowJSON)
+			if err != nil {
+				return nil, false, fmt.Errorf("%s: %w", c.name, err)
+			}
+			if raw, err = json.Marshal(expr.Encode(v, withSchema)); err != nil {
+				return nil, false, err
+			}
+		}
+		if len(raw) == 0 {
+			raw = []byte("null")
+		}
+		out, err = sjson.SetRawBytes(out, escapePath(c.name), raw)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	if !withSchema {
+		return out, true, nil
+	}
+	envelope := []byte(`{}`)
+	envelope, err = sjson.SetRawBytes(envelope, "schema", p.schema)
+	if err != nil {
+		return nil, false, err
+	}
+	envelope, err = sjson.SetRawBytes(envelope, "payload", out)
+	return envelope, true, err
+}
+
+// plan is a Query checked against one schema.
+type plan struct {
+	where   *expr.Program
+	columns []column
+	schema  []byte // of the output, nil without a schema
+}
+
+// column is a field of the output.
+type column struct {
+	name    string
+	star    bool          // all fields of rows without a schema
+	path    string        // gjson path of a plain column, copied as is
+	program *expr.Program // computing other columns
+}
+
+// plan returns q checked against schema, a zero Result for records without
+// one. Plans are cached by schema.
+func (q *Query) plan(schema gjson.Result) (*plan, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if r, ok := q.plans[schema.Raw]; ok {
+		return r.plan, r.err
+	}
+	p, err := q.newPlan(schema)
+	q.plans[schema.Raw] = planResult{plan: p, err: err}
+	return p, err
+}
+
+func (q *Query) newPlan(schema gjson.Result) (*plan, error) {
+	withSchema := schema.Exists()
+	rowType := expr.Type{}
+	if withSchema {
+		rowType = expr.SchemaType(schema)
+	}
+
+	p := &plan{}
+	if q.stmt.where != nil {
+		where, err := expr.Check(q.stmt.where, q.src, rowType)
+		if err != nil {
+			return nil, err
+		}
+		if k := where.Type().Kind; k != expr.Bool && k != expr.Dyn && k != expr.Null {
+			return nil, &expr.Error{Src: q.src, Pos: q.stmt.where.Pos(), Msg: fmt.Sprintf("WHERE is %s, want bool", where.Type())}
+		}
+		p.where = where
+	}
+
+	var fields []json.RawMessage
+	names := map[string]int{}
+	add := func(pos int, c column, field gjson.Result) error {
+		if i, ok := names[c.name]; ok && withSchema {
+			if c.path == "" || p.columns[i].path != c.path {
+				return &expr.Error{Src: q.src, Pos: pos, Msg: fmt.Sprintf("%s selected twice", c.name)}
+			}
+			return nil
+		}
+		names[c.name] = len(p.columns)
+		p.columns = append(p.columns, c)
+		if !withSchema {
+			return nil
+		}
+		f, err := sjson.SetBytes([]byte(field.Raw), "field", c.name)
+		if err != nil {
+			return err
+		}
+		fields = append(fields, f)
+		return nil
+	}
+
+	for _, it := range q.stmt.items {
+		if it.star && !withSchema {
+			p.columns = append(p.columns, column{star: true})
+			continue
+		}
+		if it.star {
+			var err error
+			schema.Get("fields").ForEach(func(_, f gjson.Result) bool {
+				name := f.Get("field").String()
+				err = add(it.pos, column{name: name, path: escapePath(name)}, f)
+				return err == nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		program, err := expr.Check(it.node, q.src, rowType)
+		if err != nil {
+			return nil, err
+		}
+		if it.column != nil {
+			if field, ok := schemaField(schema, it.column); ok || !withSchema {
+				escaped := make([]string, len(it.column))
+				for i, name := range it.column {
+					escaped[i] = escapePath(name)
+				}
+				if err := add(it.pos, column{name: it.name, path: strings.Join(escaped, ".")}, field); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+		fieldSchema := expr.ConnectSchema(program.Type())
+		fieldSchema["field"] = it.name
+		field, err := json.Marshal(fieldSchema)
+		if err != nil {
+			return nil, err
+		}
+		if err := add(it.pos, column{name: it.name, program: program}, gjson.ParseBytes(field)); err != nil {
+			return nil, err
+		}
+	}
+
+	if withSchema {
+		s := map[string]interface{}{"type": "struct", "optional": false, "fields": fields}
+		if fields == nil {
+			s["fields"] = []interface{}{}
+		}
+		b, err := json.Marshal(s)
+		if err != nil {
+			return nil, err
+		}
+		p.schema = b
+	}
+	return p, nil
+}
+
+// schemaField returns the field of the struct schema describing the nested
+// field names.
+func schemaField(schema gjson.Result, names []string) (gjson.Result, bool) {
+	f := schema
+	for _, name := range names {
+		found := false
+		f.Get("fields").ForEach(func(_, field gjson.Result) bool {
+			if field.Get("field").String() == name {
+				f, found = field, true
+				return false
+			}
+			return true
+		})
+		if !found {
+			return gjson.Result{}, false
+		}
+	}
+	return f, true
+}
+
+// escapePath escapes name for use as a single gjson/sjson path component.
+func escapePath(name string) string {
+	r := strings.NewReplacer(`\`, `\\`, ".", `\.`, "*", `\*`, "?", `\?`, "|", `\|`, "#", `\#`, "@", `\@`, ":", `\:`)
+	return r.Replace(name)
+}
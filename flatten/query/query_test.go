@@ -0,0 +1,130 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+const testEnvelope = `{
+	"schema": {"type": "struct", "name": "user_activity", "fields": [
+		{"field": "id", "type": "int32", "optional": false},
+		{"field": "email", "type": "string", "optional": true},
+		{"field": "activity", "type": "string", "optional": true},
+		{"field": "created_at", "type": "int64", "name": "org.apache.kafka.connect.data.Timestamp", "version": 1},
+		{"field": "deleted_at", "type": "int64", "name": "org.apache.kafka.connect.data.Timestamp", "version": 1, "optional": true}
+	]},
+	"payload": {"id": 2, "email": "User8@Example.com", "activity": "logged in", "created_at": 1643406665288, "deleted_at": null}
+}`
+
+func TestApplyWithSchema(t *testing.T) {
+	q, err := Compile(`
+		SELECT a.id, lower(email) AS email,
+		       CASE WHEN deleted_at IS NULL THEN 'active' ELSE 'deleted' END AS status,
+		       CAST(id AS TEXT) || ':' || activity AS event,
+		       created_at AS at,
+		       year(created_at) AS year
+		FROM user_activity a
+		WHERE activity LIKE 'logged%' AND id NOT IN (1, 3)`)
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+
+	out, ok, err := q.Apply([]byte(testEnvelope))
+	if err != nil || !ok {
+		t.Fatalf("want row, got %v, %v", ok, err)
+	}
+	got := gjson.ParseBytes(out)
+	want := `{"id":2,"email":"user8@example.com","status":"active","event":"2:logged in","at":1643406665288,"year":2022}`
+	if got.Get("payload").Raw != want {
+		t.Fatalf("want payload %s, got %s", want, got.Get("payload").Raw)
+	}
+
+	fields := got.Get("schema.fields").Array()
+	if len(fields) != 6 {
+		t.Fatalf("want 6 schema fields, got %s", got.Get("schema"))
+	}
+	for i, want := range []string{
+		`{"field":"id","type":"int32","optional":false}`,
+		`{"field":"email","optional":true,"type":"string"}`,
+		`{"field":"status","optional":true,"type":"string"}`,
+		`{"field":"event","optional":true,"type":"string"}`,
+		`{"field":"at","type":"int64","name":"org.apache.kafka.connect.data.Timestamp","version":1}`,
+		`{"field":"year","optional":true,"type":"int64"}`,
+	} {
+		if got := fields[i].Raw; got != want {
+			t.Fatalf("want schema field %s, got %s", want, got)
+		}
+	}
+}
+
+func TestApplyWhere(t *testing.T) {
+	q, err := Compile(`SELECT * FROM t WHERE id BETWEEN 3 AND 5 OR email IS NULL`)
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	_, ok, err := q.Apply([]byte(testEnvelope))
+	if err != nil || ok {
+		t.Fatalf("want row filtered out, got %v, %v", ok, err)
+	}
+
+	out, ok, err := q.Apply([]byte(`{"id": 4, "email": "a@example.com", "user.name": "a"}`))
+	if err != nil || !ok {
+		t.Fatalf("want row, got %v, %v", ok, err)
+	}
+	if string(out) != `{"id":4,"email":"a@example.com","user.name":"a"}` {
+		t.Fatalf("want all fields, got %s", out)
+	}
+}
+
+func TestApplyWithoutSchema(t *testing.T) {
+	q, err := Compile(`SELECT "user.name" AS name, upper(substring(email, 1, 1)) AS initial, price * 2 AS double, NULLIF(note, '') AS note, CONCAT(note, missing, '!') AS c`)
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	out, ok, err := q.Apply([]byte(`{"user.name": "alice", "email": "alice@example.com", "price": 1.25, "note": ""}`))
+	if err != nil || !ok {
+		t.Fatalf("want row, got %v, %v", ok, err)
+	}
+	want := `{"name":"alice","initial":"A","double":2.5,"note":null,"c":"!"}`
+	if string(out) != want {
+		t.Fatalf("want %s, got %s", want, out)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`SELECT`, "1:7: unexpected end of statement"},
+		{`SELECT id FROM`, "1:15: want table name, got end of statement"},
+		{`SELECT lower(email) FROM t`, "1:8: expression needs a name: add AS name"},
+		{`SELECT id, name AS id`, "1:12: id selected twice"},
+		{`SELECT id WHERE name = 'a`, "1:24: unterminated string"},
+		{`SELECT CAST(id AS JSON) AS j`, "1:19: unknown type JSON"},
+		{`SELECT foo(id) AS f`, "1:8: unknown function foo"},
+		{`SELECT id WHERE id NOT 3`, "1:24: want LIKE, IN or BETWEEN after NOT, got 3"},
+		{`SELECT id WHERE lower(id)`, "1:17: WHERE is string, want bool"},
+		{`SELECT CASE WHEN id > 1 THEN 'a' ELSE 1 END AS x`, "1:13: branches are string and int"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			_, err := Compile(tt.src)
+			if err == nil || err.Error() != tt.want {
+				t.Fatalf("want error %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestSchemaErrors(t *testing.T) {
+	q, err := Compile(`SELECT id, emial FROM t`)
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	_, _, err = q.Apply([]byte(testEnvelope))
+	if err == nil || err.Error() != "1:12: emial: unknown field emial" {
+		t.Fatalf("want unknown field error, got %v", err)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/meroxa/turbine-go"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// NamingRules describe which column names a destination accepts.
+type NamingRules struct {
+	Name      string
+	Upper     bool   // fold to upper case instead of lower case
+	Prefix    string // prepended to names that don't start with a letter or '_'
+	Letter    bool   // names must start with a letter, '_' is not enough
+	MaxLength int
+	Reserved  []string // in lower case
+}
+
+// sqlReserved are reserved in Postgres, Snowflake and Redshift alike.
+var sqlReserved = []string{
+	"all", "and", "any", "as", "asc", "between", "both", "case", "cast", "check",
+	"column", "constraint", "create", "cross", "current_date", "current_time",
+	"current_timestamp", "current_user", "default", "distinct", "else", "end",
+	"false", "for", "foreign", "from", "grant", "group", "having", "in", "inner",
+	"insert", "intersect", "into", "is", "join", "leading", "left", "like",
+	"limit", "natural", "not", "null", "offset", "on", "or", "order", "outer",
+	"primary", "references", "right", "select", "session_user", "table", "then",
+	"to", "trailing", "true", "union", "unique", "user", "using", "when", "where",
+	"with",
+}
+
+var (
+	PostgresRules = NamingRules{
+		Name:      "postgres",
+		Prefix:    "_",
+		MaxLength: 63,
+		Reserved:  append([]string{"analyse", "analyze", "array", "asymmetric", "do", "except", "fetch", "initially", "lateral", "only", "placing", "returning", "some", "symmetric", "variadic", "window"}, sqlReserved...),
+	}
+	SnowflakeRules = NamingRules{
+		Name:      "snowflake",
+		Upper:     true,
+		Prefix:    "C_",
+		Letter:    true,
+		MaxLength: 255,
+		Reserved:  append([]string{"account", "connection", "database", "gscluster", "ilike", "increment", "issue", "localtime", "localtimestamp", "minus", "qualify", "regexp", "rlike", "row", "rows", "sample", "schema", "some", "start", "tablesample", "trigger", "try_cast", "update", "values", "view", "whenever"}, sqlReserved...),
+	}
+	RedshiftRules = NamingRules{
+		Name:      "redshift",
+		Prefix:    "_",
+		MaxLength: 127,
+		Reserved:  append([]string{"aes128", "aes256", "allowoverwrite", "backup", "blanksasnull", "bytedict", "credentials", "delta", "delta32k", "disable", "emptyasnull", "encode", "encrypt", "except", "explicit", "globaldict256", "globaldict64k", "identity", "ignore", "lzo", "minus", "mostly13", "mostly32", "mostly8", "new", "offline", "oid", "old", "open", "parallel", "partition", "percent", "permissions", "raw", "readratio", "recover", "respect", "restore", "snapshot", "sysdate", "system", "tag", "tdes", "text255", "text32k", "timestamp", "top", "truncatecolumns", "wallet"}, sqlReserved...),
+	}
+)
+
+// Sanitize renames the top-level payload fields of every record, typically
+// after Flatten, to column names following the NamingRules of its Mapping:
+//
+//   - names are folded to lower (or upper) case,
+//   - characters other than letters, digits and '_' are replaced with '_',
+//   - names starting with anything else than a letter get the rules' Prefix,
+//   - reserved words get a '_' suffix,
+//   - names longer than MaxLength are truncated and get a hash suffix.
+//
+// Mapping remembers the column name given to every field, so a field gets the
+// same column name in every record. The first name seen keeps a column, valid
+// names first within a record, and later names colliding with it after
+// sanitization get a hash suffix. When MappingField is set, every record gets
+// that field holding a JSON object of its renamed columns to their original
+// names.
+// Records with a Kafka Connect style schema have their schema fields renamed
+// as well.
+type Sanitize struct {
+	Mapping      *ColumnMapping
+	MappingField string
+}
+
+func NewSanitize(rules NamingRules, mappingField string) Sanitize {
+	return Sanitize{Mapping: NewColumnMapping(rules), MappingField: mappingField}
+}
+
+func (f Sanitize) Process(rr []turbine.Record) []turbine.Record {
+	out := rr[:0]
+	for _, r := range rr {
+		err := f.sanitize(&r.Payload)
+		if err != nil {
+			log.Printf("error sanitizing record %s: %s", r.Key, err)
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func (f Sanitize) sanitize(p *turbine.Payload) error {
+	val := []byte(*p)
+	payloadPath := ""
+	if hasSchema(val) {
+		payloadPath = "payload"
+	}
+
+	fields := gjson.GetBytes(val, payloadPath)
+	if payloadPath == "" {
+		fields = gjson.ParseBytes(val)
+	}
+	if !fields.IsObject() {
+		return fmt.Errorf("payload is not an object")
+	}
+
+	var names []string
+	fields.ForEach(func(k, _ gjson.Result) bool {
+		names = append(names, k.String())
+		return true
+	})
+	// names seen together keep their columns whatever their order in the
+	// payload
+	sort.Slice(names, func(i, j int) bool {
+		return f.Mapping.prefer(names[i], names[j])
+	})
+
+	out := []byte("{}")
+	renamed := make(map[string]string)
+	var err error
+	for _, name := range names {
+		col := f.Mapping.Column(name)
+		if col != name {
+			renamed[col] = name
+		}
+		out, err = sjson.SetRawBytes(out, escapePath(col), []byte(fields.Get(escapePath(name)).Raw))
+		if err != nil {
+			return err
+		}
+	}
+
+	mappingCol := ""
+	if f.MappingField != "" {
+		b, err := json.Marshal(renamed)
+		if err != nil {
+			return err
+		}
+		mappingCol = f.Mapping.Column(f.MappingField)
+		out, err = sjson.SetBytes(out, escapePath(mappingCol), string(b))
+		if err != nil {
+			return err
+		}
+	}
+
+	if payloadPath == "" {
+		*p = out
+		return nil
+	}
+
+	val, err = sjson.SetRawBytes(val, "payload", out)
+	if err != nil {
+		return err
+	}
+	val, err = f.sanitizeSchema(val, mappingCol)
+	if err != nil {
+		return err
+	}
+	*p = val
+	return nil
+}
+
+// sanitizeSchema renames the top-level schema fields and adds the mapping
+// field, if any.
+func (f Sanitize) sanitizeSchema(p []byte, mappingCol string) ([]byte, error) {
+	var err error
+	for i, field := range gjson.GetBytes(p, "schema.fields").Array() {
+		col := f.Mapping.Column(field.Get("field").String())
+		p, err = sjson.SetBytes(p, fmt.Sprintf("schema.fields.%d.field", i), col)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if mappingCol == "" {
+		return p, nil
+	}
+	if _, ok := schemaFieldPath(p, mappingCol); ok {
+		return p, nil
+	}
+	return sjson.SetBytes(p, "schema.fields.-1", map[string]interface{}{
+		"field":    mappingCol,
+		"optional": true,
+		"type":     "string",
+	})
+}
+
+// ColumnMapping assigns column names to field names following NamingRules.
+// It is safe for concurrent use.
+type ColumnMapping struct {
+	rules    NamingRules
+	reserved map[string]bool
+
+	mu      sync.Mutex
+	columns map[string]string // field name to column name
+	fields  map[string]string // column name to field name
+}
+
+func NewColumnMapping(rules NamingRules) *ColumnMapping {
+	reserved := make(map[string]bool, len(rules.Reserved))
+	for _, w := range rules.Reserved {
+		reserved[w] = true
+	}
+	return &ColumnMapping{
+		rules:    rules,
+		reserved: reserved,
+		columns:  make(map[string]string),
+		fields:   make(map[string]string),
+	}
+}
+
+// Column returns the column name for the field name, assigning one the first
+// time name is seen. A column is never reassigned: when a name collides after
+// sanitization with one seen before, the newcomer gets a hash suffix.
+func (m *ColumnMapping) Column(name string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if col, ok := m.columns[name]; ok {
+		return col
+	}
+
+	col := m.sanitize(name)
+	if other, ok := m.fields[col]; ok && other != name {
+		col = m.withHash(col, name)
+	}
+	m.columns[name] = col
+	m.fields[col] = name
+	return col
+}
+
+// prefer reports whether a should be assigned a column before b, so it wins a
+// collision between names seen in the same record: valid names go before
+// names that had to be changed, and ties to the name sorting first.
+func (m *ColumnMapping) prefer(a, b string) bool {
+	va, vb := m.Valid(a), m.Valid(b)
+	if va != vb {
+		return va
+	}
+	return a < b
+}
+
+// Mapping returns a copy of all column names assigned so far, by field name.
+func (m *ColumnMapping) Mapping() map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make(map[string]string, len(m.columns))
+	for k, v := range m.columns {
+		out[k] = v
+	}
+	return out
+}
+
+// Valid reports whether name is a column name as is.
+func (m *ColumnMapping) Valid(name string) bool {
+	return m.sanitize(name) == name
+}
+
+func (m *ColumnMapping) sanitize(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	col := strings.ToLower(b.String())
+
+	switch {
+	case col == "":
+		col = m.rules.Prefix
+	case col[0] == '_' && m.rules.Letter, col[0] >= '0' && col[0] <= '9':
+		col = strings.ToLower(m.rules.Prefix) + col
+	}
+	if m.reserved[col] {
+		col += "_"
+	}
+	if m.rules.Upper {
+		col = strings.ToUpper(col)
+	}
+	if m.rules.MaxLength > 0 && len(col) > m.rules.MaxLength {
+		col = m.withHash(col, name)
+	}
+	return col
+}
+
+// withHash returns col with a suffix derived from name, truncated to fit
+// MaxLength.
+func (m *ColumnMapping) withHash(col, name string) string {
+	sum := sha256.Sum256([]byte(name))
+	suffix := "_" + hex.EncodeToString(sum[:4])
+	if m.rules.Upper {
+		suffix = strings.ToUpper(suffix)
+	}
+	if m.rules.MaxLength > 0 && len(col)+len(suffix) > m.rules.MaxLength {
+		col = col[:m.rules.MaxLength-len(suffix)]
+	}
+	return col + suffix
+}
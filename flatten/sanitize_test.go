@@ -0,0 +1,141 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/meroxa/turbine-go"
+	"github.com/tidwall/gjson"
+)
+
+func TestColumnMapping_Column(t *testing.T) {
+	long := strings.Repeat("a", 70)
+
+	tests := []struct {
+		rules NamingRules
+		name  string
+		want  string
+	}{
+		{PostgresRules, "user.email", "user_email"},
+		{PostgresRules, "User Name", "user_name"},
+		{PostgresRules, "actions.1", "actions_1"},
+		{PostgresRules, "1st", "_1st"},
+		{PostgresRules, "_id", "_id"},
+		{PostgresRules, "order", "order_"},
+		{PostgresRules, "user", "user_"},
+		{PostgresRules, long, strings.Repeat("a", 54) + "_6bd5e503"},
+		{RedshiftRules, long, long},
+		{RedshiftRules, "timestamp", "timestamp_"},
+		{SnowflakeRules, "user.email", "USER_EMAIL"},
+		{SnowflakeRules, "_id", "C__ID"},
+		{SnowflakeRules, "1st", "C_1ST"},
+		{SnowflakeRules, "qualify", "QUALIFY_"},
+	}
+
+	for _, tt := range tests {
+		m := NewColumnMapping(tt.rules)
+		if got := m.Column(tt.name); got != tt.want {
+			t.Fatalf("%s: want %s for %s, got %s", tt.rules.Name, tt.want, tt.name, got)
+		}
+	}
+}
+
+func TestColumnMapping_Collision(t *testing.T) {
+	tests := []struct {
+		name   string
+		names  []string
+		winner string
+	}{
+		{"valid name first", []string{"user_email", "user.email"}, "user_email"},
+		{"valid name last", []string{"user.email", "user_email"}, "user.email"},
+		{"renamed names", []string{"user-email", "user.email"}, "user-email"},
+		{"renamed names reversed", []string{"user.email", "user-email"}, "user.email"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewColumnMapping(PostgresRules)
+			for _, name := range tt.names {
+				m.Column(name)
+			}
+
+			mapping := m.Mapping()
+			winner, loser := tt.winner, tt.names[0]
+			if loser == winner {
+				loser = tt.names[1]
+			}
+			if got := mapping[winner]; got != "user_email" {
+				t.Fatalf("want %s to get user_email, got %s", winner, got)
+			}
+			if got, want := mapping[loser], m.withHash("user_email", loser); got != want {
+				t.Fatalf("want %s to get %s, got %s", loser, want, got)
+			}
+			for _, name := range tt.names {
+				if got := m.Column(name); got != mapping[name] {
+					t.Fatalf("want stable column name %s for %s, got %s", mapping[name], name, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSanitize_ProcessDropsInvalidRecords(t *testing.T) {
+	f := NewSanitize(PostgresRules, "")
+	rr := []turbine.Record{
+		{Key: "1", Payload: []byte(`[1]`)},
+		{Key: "2", Payload: []byte(`{"user.email": "alice@example.com"}`)},
+	}
+
+	out := f.Process(rr)
+	if len(out) != 1 || out[0].Key != "2" {
+		t.Fatalf("want only record 2, got %v", out)
+	}
+}
+
+func TestSanitize_Process(t *testing.T) {
+	f := NewSanitize(PostgresRules, "column_mapping")
+	rr := []turbine.Record{
+		{Key: "1", Payload: []byte(`{"user.email": "alice@example.com", "user_email": "a@example.com", "order": 1}`)},
+	}
+
+	out := f.Process(rr)
+
+	p := out[0].Payload
+	if got := gjson.GetBytes(p, "user_email").String(); got != "a@example.com" {
+		t.Fatalf("want valid name user_email to be kept, got %s", p)
+	}
+	if got := gjson.GetBytes(p, "order_").Int(); got != 1 {
+		t.Fatalf("want order_ 1, got %s", p)
+	}
+
+	mapping := gjson.Parse(gjson.GetBytes(p, "column_mapping").String()).Map()
+	if len(mapping) != 2 || mapping["order_"].String() != "order" {
+		t.Fatalf("want mapping of the 2 renamed columns, got %s", gjson.GetBytes(p, "column_mapping"))
+	}
+	for col, name := range mapping {
+		if name.String() == "user.email" && gjson.GetBytes(p, col).String() != "alice@example.com" {
+			t.Fatalf("want user.email to be renamed to %s, got %s", col, p)
+		}
+	}
+}
+
+func TestSanitize_ProcessWithSchema(t *testing.T) {
+	f := NewSanitize(SnowflakeRules, "")
+	rr := []turbine.Record{{
+		Key: "1",
+		Payload: []byte(`{"schema": {"type": "struct", "fields": [
+			{"field": "id", "type": "int32", "optional": false},
+			{"field": "user.email", "type": "string", "optional": true}
+		]}, "payload": {"id": 1, "user.email": "alice@example.com"}}`),
+	}}
+
+	out := f.Process(rr)
+
+	p := out[0].Payload
+	if got := gjson.GetBytes(p, "payload.USER_EMAIL").String(); got != "alice@example.com" {
+		t.Fatalf("want payload.USER_EMAIL, got %s", p)
+	}
+	if got := gjson.GetBytes(p, "schema.fields.#.field").String(); got != `["ID","USER_EMAIL"]` {
+		t.Fatalf("want schema fields ID and USER_EMAIL, got %s", got)
+	}
+}
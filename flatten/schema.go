@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// hasSchema reports whether p is a {"schema": ..., "payload": ...} envelope.
+func hasSchema(p []byte) bool {
+	res := gjson.GetManyBytes(p, "schema", "payload")
+	return res[0].IsObject() && res[1].Exists()
+}
+
+// schemaFieldPath returns the sjson path of the schema field describing the
+// payload field at path, following nested struct fields.
+func schemaFieldPath(p []byte, path string) (string, bool) {
+	schemaPath := "schema"
+	for _, name := range splitPath(path) {
+		found := false
+		gjson.GetBytes(p, schemaPath+".fields").ForEach(func(i, f gjson.Result) bool {
+			if f.Get("field").String() == name {
+				schemaPath = fmt.Sprintf("%s.fields.%d", schemaPath, i.Int())
+				found = true
+				return false
+			}
+			return true
+		})
+		if !found {
+			return "", false
+		}
+	}
+	return schemaPath, true
+}
+
+// escapePath escapes name for use as a single gjson/sjson path component.
+func escapePath(name string) string {
+	r := strings.NewReplacer(`\`, `\\`, ".", `\.`, "*", `\*`, "?", `\?`, "|", `\|`, "#", `\#`, "@", `\@`, ":", `\:`)
+	return r.Replace(name)
+}
+
+// splitPath splits a gjson/sjson path into its unescaped components.
+func splitPath(path string) []string {
+	var parts []string
+	var part strings.Builder
+	for i := 0; i < len(path); i++ {
+		switch {
+		case path[i] == '\\' && i+1 < len(path):
+			i++
+			part.WriteByte(path[i])
+		case path[i] == '.':
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteByte(path[i])
+		}
+	}
+	return append(parts, part.String())
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"log"
+
+	"github.com/meroxa/flatten/query"
+	"github.com/meroxa/turbine-go"
+)
+
+// SQL replaces every record by the row a SQL SELECT statement selects from
+// its payload, as described in package query, and drops the records its
+// WHERE clause rejects. Records with a Kafka Connect schema get the schema
+// derived from the select list. Records the statement fails on are dropped.
+type SQL struct {
+	Query *query.Query
+}
+
+// NewSQL compiles the SELECT statement stmt.
+func NewSQL(stmt string) (SQL, error) {
+	q, err := query.Compile(stmt)
+	if err != nil {
+		return SQL{}, err
+	}
+	return SQL{Query: q}, nil
+}
+
+func (f SQL) Process(rr []turbine.Record) []turbine.Record {
+	out := rr[:0]
+	for _, r := range rr {
+		p, ok, err := f.Query.Apply(r.Payload)
+		if err != nil {
+			log.Printf("error querying record %s: %s", r.Key, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		r.Payload = p
+		out = append(out, r)
+	}
+	return out
+}
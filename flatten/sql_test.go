@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/meroxa/turbine-go"
+	"github.com/tidwall/gjson"
+)
+
+func TestSQLOnDemoPG(t *testing.T) {
+	f, err := NewSQL(`
+		SELECT id, email, activity, created_at
+		FROM user_activity
+		WHERE activity = 'logged in' AND deleted_at IS NULL`)
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+
+	out := f.Process(readFixtureRecords(t, "fixtures/pg.json", "user_activity"))
+	if len(out) != 2 || out[0].Key != "2" || out[1].Key != "3" {
+		t.Fatalf("want records 2 and 3, got %v", out)
+	}
+	got := gjson.ParseBytes(out[0].Payload)
+	if got.Get("payload").Raw != `{"id":2,"email":"user8@example.com","activity":"logged in","created_at":1643406665288}` {
+		t.Fatalf("want selected columns, got %s", got.Get("payload"))
+	}
+	if n := len(got.Get("schema.fields").Array()); n != 4 {
+		t.Fatalf("want 4 schema fields, got %d", n)
+	}
+}
+
+func readFixtureRecords(t *testing.T, path, collection string) []turbine.Record {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+
+	var fixtures map[string][]struct {
+		Key   string
+		Value json.RawMessage
+	}
+	err = json.Unmarshal(b, &fixtures)
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+
+	var rr []turbine.Record
+	for _, f := range fixtures[collection] {
+		rr = append(rr, turbine.Record{Key: f.Key, Payload: []byte(f.Value)})
+	}
+	return rr
+}
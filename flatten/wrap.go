@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/meroxa/turbine-go"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// WrapOptions configures Wrap.
+type WrapOptions struct {
+	// Schema is used as is instead of inferring one, when set.
+	Schema json.RawMessage `json:"schema"`
+	// Name is given to the inferred top-level struct, when set.
+	Name string `json:"name"`
+	// IntegerType is the type of numbers without a fraction or exponent,
+	// "int64" by default. Set it to "float64" to type all numbers alike.
+	IntegerType string `json:"integer_type"`
+	// FloatType is the type of all other numbers, "float64" by default.
+	FloatType string `json:"float_type"`
+}
+
+// Wrap is the inverse of transforms.Unwrap: it puts the payload of every
+// record in a {"schema": ..., "payload": ...} envelope, so it can be written
+// to destinations expecting Kafka Connect schemas.
+//
+// Unless Options.Schema is set, the schema is inferred from the payload:
+// objects become structs, arrays get an item schema covering all their
+// elements and fields are optional only if they are null (or missing from
+// some array elements). Null values and empty arrays have no type to infer
+// and are typed as optional strings. Records that already have a schema are
+// passed on unchanged and records that can't be wrapped are dropped.
+type Wrap struct {
+	Options WrapOptions
+}
+
+func (f Wrap) Process(rr []turbine.Record) []turbine.Record {
+	out := rr[:0]
+	for _, r := range rr {
+		err := WrapPayload(&r.Payload, f.Options)
+		if err != nil {
+			log.Printf("error wrapping record %s: %s", r.Key, err)
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// WrapPayload puts the JSON object in p in a schema envelope.
+func WrapPayload(p *turbine.Payload, opts WrapOptions) error {
+	if hasSchema(*p) {
+		return nil
+	}
+	if opts.IntegerType == "" {
+		opts.IntegerType = "int64"
+	}
+	if opts.FloatType == "" {
+		opts.FloatType = "float64"
+	}
+
+	payload := gjson.ParseBytes(*p)
+	if !payload.IsObject() {
+		return fmt.Errorf("payload is not an object")
+	}
+
+	schema := []byte(opts.Schema)
+	if len(schema) == 0 {
+		s, err := inferSchema(payload, opts)
+		if err != nil {
+			return err
+		}
+		s.Name = opts.Name
+		schema, err = json.Marshal(s)
+		if err != nil {
+			return err
+		}
+	} else if t := gjson.GetBytes(schema, "type").String(); t != "struct" {
+		return fmt.Errorf("schema must be a struct, got %q", t)
+	}
+
+	val, err := sjson.SetRawBytes([]byte(`{}`), "schema", schema)
+	if err != nil {
+		return err
+	}
+	val, err = sjson.SetRawBytes(val, "payload", []byte(payload.Raw))
+	if err != nil {
+		return err
+	}
+	*p = val
+	return nil
+}
+
+// connectSchema is a Kafka Connect schema. An empty Type stands for a null
+// value whose type is yet unknown.
+type connectSchema struct {
+	Type     string           `json:"type"`
+	Optional bool             `json:"optional"`
+	Field    string           `json:"field,omitempty"`
+	Name     string           `json:"name,omitempty"`
+	Fields   []*connectSchema `json:"fields,omitempty"`
+	Items    *connectSchema   `json:"items,omitempty"`
+}
+
+// MarshalJSON always writes the fields of structs, even when there are none:
+// Kafka Connect rejects struct schemas without them.
+func (s connectSchema) MarshalJSON() ([]byte, error) {
+	type plain connectSchema
+	v := struct {
+		plain
+		Fields *[]*connectSchema `json:"fields,omitempty"`
+	}{plain: plain(s)}
+	if s.Type == "struct" {
+		fields := s.Fields
+		if fields == nil {
+			fields = []*connectSchema{}
+		}
+		v.Fields = &fields
+	}
+	return json.Marshal(v)
+}
+
+func inferSchema(v gjson.Result, opts WrapOptions) (*connectSchema, error) {
+	s, err := infer(v, opts)
+	if err != nil {
+		return nil, err
+	}
+	s.resolveNulls()
+	return s, nil
+}
+
+func infer(v gjson.Result, opts WrapOptions) (*connectSchema, error) {
+	switch {
+	case v.IsObject():
+		s := &connectSchema{Type: "struct"}
+		var err error
+		v.ForEach(func(k, fv gjson.Result) bool {
+			var fs *connectSchema
+			fs, err = infer(fv, opts)
+			if err != nil {
+				err = fmt.Errorf("%s: %w", k.String(), err)
+				return false
+			}
+			fs.Field = k.String()
+			s.Fields = append(s.Fields, fs)
+			return true
+		})
+		return s, err
+	case v.IsArray():
+		var items *connectSchema
+		for i, el := range v.Array() {
+			s, err := infer(el, opts)
+			if err != nil {
+				return nil, fmt.Errorf("%d: %w", i, err)
+			}
+			if items == nil {
+				items = s
+				continue
+			}
+			items, err = mergeSchemas(items, s, opts)
+			if err != nil {
+				return nil, fmt.Errorf("%d: %w", i, err)
+			}
+		}
+		if items == nil {
+			items = &connectSchema{Optional: true}
+		}
+		return &connectSchema{Type: "array", Items: items}, nil
+	}
+
+	switch v.Type {
+	case gjson.Null:
+		return &connectSchema{Optional: true}, nil
+	case gjson.String:
+		return &connectSchema{Type: "string"}, nil
+	case gjson.True, gjson.False:
+		return &connectSchema{Type: "boolean"}, nil
+	case gjson.Number:
+		if strings.ContainsAny(v.Raw, ".eE") {
+			return &connectSchema{Type: opts.FloatType}, nil
+		}
+		return &connectSchema{Type: opts.IntegerType}, nil
+	default:
+		return nil, fmt.Errorf("unsupported value %s", v.Raw)
+	}
+}
+
+// mergeSchemas returns a schema covering values of both a and b, which are
+// elements of the same array.
+func mergeSchemas(a, b *connectSchema, opts WrapOptions) (*connectSchema, error) {
+	optional := a.Optional || b.Optional
+	switch {
+	case a.Type == "":
+		b.Optional = true
+		return b, nil
+	case b.Type == "":
+		a.Optional = true
+		return a, nil
+	case a.Type == b.Type:
+	case isNumber(a.Type, opts) && isNumber(b.Type, opts):
+		return &connectSchema{Type: opts.FloatType, Optional: optional}, nil
+	default:
+		return nil, fmt.Errorf("conflicting types %s and %s", a.Type, b.Type)
+	}
+
+	switch a.Type {
+	case "struct":
+		for _, bf := range b.Fields {
+			af := a.field(bf.Field)
+			if af == nil {
+				bf.Optional = true
+				a.Fields = append(a.Fields, bf)
+				continue
+			}
+			merged, err := mergeSchemas(af, bf, opts)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", bf.Field, err)
+			}
+			merged.Field = af.Field
+			*af = *merged
+		}
+		for _, af := range a.Fields {
+			if b.field(af.Field) == nil {
+				af.Optional = true
+			}
+		}
+	case "array":
+		items, err := mergeSchemas(a.Items, b.Items, opts)
+		if err != nil {
+			return nil, err
+		}
+		a.Items = items
+	}
+	a.Optional = optional
+	return a, nil
+}
+
+func isNumber(t string, opts WrapOptions) bool {
+	return t == opts.IntegerType || t == opts.FloatType
+}
+
+func (s *connectSchema) field(name string) *connectSchema {
+	for _, f := range s.Fields {
+		if f.Field == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// resolveNulls types values that were only ever seen as null as strings.
+func (s *connectSchema) resolveNulls() {
+	if s.Type == "" {
+		s.Type = "string"
+	}
+	for _, f := range s.Fields {
+		f.resolveNulls()
+	}
+	if s.Items != nil {
+		s.Items.resolveNulls()
+	}
+}
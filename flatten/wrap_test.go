@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/meroxa/turbine-go"
+	"github.com/meroxa/turbine-go/transforms"
+	"github.com/tidwall/gjson"
+)
+
+func TestWrap_Process(t *testing.T) {
+	r := turbine.Record{Key: "1", Payload: []byte(nestedEvent)}
+
+	out := Wrap{}.Process([]turbine.Record{r})
+
+	if len(out) != 1 {
+		t.Fatalf("want 1 record, got %d", len(out))
+	}
+	if !out[0].JSONSchema() {
+		t.Fatalf("want a JSON with schema record, got %s", out[0].Payload)
+	}
+
+	want := `{"type":"struct","optional":false,"fields":[` +
+		`{"type":"int64","optional":false,"field":"id"},` +
+		`{"type":"struct","optional":false,"field":"user","fields":[` +
+		`{"type":"int64","optional":false,"field":"id"},` +
+		`{"type":"string","optional":false,"field":"name"},` +
+		`{"type":"string","optional":false,"field":"email"}]},` +
+		`{"type":"array","optional":false,"field":"actions","items":{"type":"string","optional":false}}]}`
+	if got := gjson.GetBytes(out[0].Payload, "schema").Raw; got != want {
+		t.Fatalf("want schema %s, got %s", want, got)
+	}
+
+	p := out[0].Payload
+	err := transforms.Unwrap(&p)
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	if !jsonEqual(string(p), nestedEvent) {
+		t.Fatalf("want Unwrap to restore %s, got %s", nestedEvent, p)
+	}
+}
+
+func TestWrapPayload_Infer(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    WrapOptions
+		payload string
+		path    string
+		want    string
+	}{
+		{
+			name:    "null",
+			payload: `{"a": null}`,
+			path:    "fields.0",
+			want:    `{"type":"string","optional":true,"field":"a"}`,
+		},
+		{
+			name:    "empty array",
+			payload: `{"a": []}`,
+			path:    "fields.0.items",
+			want:    `{"type":"string","optional":true}`,
+		},
+		{
+			name:    "mixed numbers",
+			payload: `{"a": [1, 2.5]}`,
+			path:    "fields.0.items",
+			want:    `{"type":"float64","optional":false}`,
+		},
+		{
+			name:    "number types",
+			opts:    WrapOptions{IntegerType: "int32", FloatType: "float32"},
+			payload: `{"a": 1, "b": 1e3}`,
+			path:    "fields.#.type",
+			want:    `["int32","float32"]`,
+		},
+		{
+			name:    "array of structs",
+			payload: `{"a": [{"x": 1, "y": null}, {"x": 2, "z": "s"}, null]}`,
+			path:    "fields.0.items",
+			want: `{"type":"struct","optional":true,"fields":[` +
+				`{"type":"int64","optional":false,"field":"x"},` +
+				`{"type":"string","optional":true,"field":"y"},` +
+				`{"type":"string","optional":true,"field":"z"}]}`,
+		},
+		{
+			name:    "empty object",
+			payload: `{"a": {}}`,
+			path:    "fields.0",
+			want:    `{"type":"struct","optional":false,"field":"a","fields":[]}`,
+		},
+		{
+			name:    "empty payload",
+			payload: `{}`,
+			path:    "@this",
+			want:    `{"type":"struct","optional":false,"fields":[]}`,
+		},
+		{
+			name:    "name",
+			opts:    WrapOptions{Name: "events"},
+			payload: `{"a": 1}`,
+			path:    "name",
+			want:    `"events"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := turbine.Payload(tt.payload)
+			err := WrapPayload(&p, tt.opts)
+			if err != nil {
+				t.Fatalf("want no error, got %s", err)
+			}
+			if got := gjson.GetBytes(p, "schema."+tt.path).Raw; got != tt.want {
+				t.Fatalf("want %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestWrapPayload_Schema(t *testing.T) {
+	schema := `{"type":"struct","optional":false,"fields":[{"type":"int32","optional":false,"field":"id"}]}`
+	p := turbine.Payload(`{"id": 1}`)
+
+	err := WrapPayload(&p, WrapOptions{Schema: json.RawMessage(schema)})
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	if got := gjson.GetBytes(p, "schema").Raw; got != schema {
+		t.Fatalf("want schema %s, got %s", schema, got)
+	}
+
+	err = WrapPayload(&p, WrapOptions{Schema: json.RawMessage(`{"type":"string"}`)})
+	if err != nil {
+		t.Fatalf("want wrapped payload to be left alone, got %s", err)
+	}
+	if got := gjson.GetBytes(p, "schema").Raw; got != schema {
+		t.Fatalf("want schema %s, got %s", schema, got)
+	}
+}
+
+func TestWrap_ProcessConflictingTypes(t *testing.T) {
+	rr := []turbine.Record{
+		{Key: "1", Payload: []byte(`{"a": [1, "one"]}`)},
+		{Key: "2", Payload: []byte(`{"a": [1, 2]}`)},
+	}
+
+	out := Wrap{}.Process(rr)
+
+	if len(out) != 1 || out[0].Key != "2" {
+		t.Fatalf("want only record 2, got %v", out)
+	}
+}
+
+func jsonEqual(a, b string) bool {
+	var va, vb interface{}
+	if json.Unmarshal([]byte(a), &va) != nil || json.Unmarshal([]byte(b), &vb) != nil {
+		return false
+	}
+	ja, _ := json.Marshal(va)
+	jb, _ := json.Marshal(vb)
+	return string(ja) == string(jb)
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/meroxa/turbine-go"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// Strategy is how a FieldRule anonymizes a value.
+type Strategy string
+
+const (
+	StrategyHMAC       Strategy = "hmac"
+	StrategyPseudonym  Strategy = "pseudonym"
+	StrategyTokenize   Strategy = "tokenize"
+	StrategyTruncate   Strategy = "truncate"
+	StrategyRedact     Strategy = "redact"
+	StrategyMask       Strategy = "mask"
+	StrategyGeneralize Strategy = "generalize"
+	StrategyDelete     Strategy = "delete"
+)
+
+// PreviousSuffix is appended to the name of a pseudonymized field to get the
+// field holding its hash with the previous key, during a key rotation.
+const PreviousSuffix = "_previous"
+
+// Redacted replaces values anonymized with StrategyRedact.
+const Redacted = "[REDACTED]"
+
+// DateGranularity is what GeneralizeDate reduces a date to.
+type DateGranularity string
+
+const (
+	Month DateGranularity = "month"
+	Year  DateGranularity = "year"
+)
+
+// FieldRule anonymizes the payload field at Path, which may be a nested path
+// such as "user.email". Rules are built with HMAC, Pseudonym, Tokenize,
+// Truncate, Redact, Mask, GeneralizeDate and Delete.
+type FieldRule struct {
+	Path          string
+	Strategy      Strategy
+	Key           []byte          // HMAC key
+	Pseudonymizer Pseudonymizer   // used by Pseudonym
+	Vault         Vault           // used by Tokenize
+	Length        int             // characters kept by Truncate and Mask, negative counts as 0
+	Granularity   DateGranularity // used by GeneralizeDate
+}
+
+// HMAC replaces the value with its hex encoded HMAC-SHA256 keyed with key.
+func HMAC(path string, key []byte) FieldRule {
+	return FieldRule{Path: path, Strategy: StrategyHMAC, Key: key}
+}
+
+// Pseudonym replaces the value with its versioned hash from p. While p is
+// rotating keys, the hash with the previous key is written next to it, to the
+// field named Path+PreviousSuffix.
+func Pseudonym(path string, p Pseudonymizer) FieldRule {
+	return FieldRule{Path: path, Strategy: StrategyPseudonym, Pseudonymizer: p}
+}
+
+// Tokenize replaces the value with a random token issued by vault. Unlike
+// hashes, tokens can be turned back into values with Detokenize.
+func Tokenize(path string, vault Vault) FieldRule {
+	return FieldRule{Path: path, Strategy: StrategyTokenize, Vault: vault}
+}
+
+// Truncate keeps the first n characters of the value.
+func Truncate(path string, n int) FieldRule {
+	return FieldRule{Path: path, Strategy: StrategyTruncate, Length: n}
+}
+
+// Redact replaces the value with Redacted.
+func Redact(path string) FieldRule {
+	return FieldRule{Path: path, Strategy: StrategyRedact}
+}
+
+// Mask replaces all but the last n characters of the value with '*'.
+func Mask(path string, n int) FieldRule {
+	return FieldRule{Path: path, Strategy: StrategyMask, Length: n}
+}
+
+// GeneralizeDate reduces a date to its month ("2006-01") or year ("2006").
+// Values may be Kafka Connect Timestamp (epoch milliseconds) or Date (epoch
+// days) fields, or RFC 3339 / "2006-01-02" strings.
+func GeneralizeDate(path string, g DateGranularity) FieldRule {
+	return FieldRule{Path: path, Strategy: StrategyGeneralize, Granularity: g}
+}
+
+// Delete removes the field.
+func Delete(path string) FieldRule {
+	return FieldRule{Path: path, Strategy: StrategyDelete}
+}
+
+// Anonymize applies its Rules to every record. The schema of each anonymized
+// field is updated to match: fields are retyped to string (dropping any
+// logical type) or removed for Delete. Records that can't be anonymized are
+// dropped rather than passed on with their PII.
+type Anonymize struct {
+	Rules []FieldRule
+}
+
+func NewAnonymize(rules ...FieldRule) Anonymize {
+	return Anonymize{Rules: rules}
+}
+
+func (f Anonymize) Process(rr []turbine.Record) []turbine.Record {
+	out := rr[:0]
+	for _, r := range rr {
+		err := f.anonymize(&r.Payload)
+		if err != nil {
+			log.Printf("error anonymizing record %s: %s", r.Key, err)
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func (f Anonymize) anonymize(p *turbine.Payload) error {
+	for _, rule := range f.Rules {
+		err := rule.apply(p)
+		if err != nil {
+			return fmt.Errorf("%s %s: %w", rule.Strategy, rule.Path, err)
+		}
+	}
+	return nil
+}
+
+func (rule FieldRule) apply(p *turbine.Payload) error {
+	nestedPath := "payload." + rule.Path
+	res := gjson.GetBytes(*p, nestedPath)
+	if !res.Exists() {
+		return nil
+	}
+
+	if rule.Strategy == StrategyDelete {
+		val, err := sjson.DeleteBytes(*p, nestedPath)
+		if err != nil {
+			return err
+		}
+		val, err = deleteSchemaField(val, rule.Path)
+		if err != nil {
+			return err
+		}
+		*p = val
+		return nil
+	}
+
+	if res.Type == gjson.Null {
+		return nil
+	}
+
+	anon, err := rule.anonymizeValue(res, schemaField(*p, rule.Path))
+	if err != nil {
+		return err
+	}
+
+	val, err := sjson.SetBytes(*p, nestedPath, anon)
+	if err != nil {
+		return err
+	}
+	val, err = setSchemaString(val, rule.Path)
+	if err != nil {
+		return err
+	}
+
+	if rule.Strategy == StrategyPseudonym {
+		if prev, ok := rule.Pseudonymizer.HashPrevious(res.String()); ok {
+			val, err = setStringField(val, rule.Path+PreviousSuffix, prev)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	*p = val
+	return nil
+}
+
+// length is rule.Length, clamped so Truncate and Mask never index out of range.
+func (rule FieldRule) length() int {
+	if rule.Length < 0 {
+		return 0
+	}
+	return rule.Length
+}
+
+func (rule FieldRule) anonymizeValue(v gjson.Result, field gjson.Result) (string, error) {
+	s := v.String()
+	switch rule.Strategy {
+	case StrategyHMAC:
+		mac := hmac.New(sha256.New, rule.Key)
+		mac.Write([]byte(s))
+		return hex.EncodeToString(mac.Sum(nil)), nil
+	case StrategyPseudonym:
+		return rule.Pseudonymizer.Hash(s), nil
+	case StrategyTokenize:
+		return rule.Vault.Token(s)
+	case StrategyTruncate:
+		r := []rune(s)
+		if n := rule.length(); len(r) > n {
+			r = r[:n]
+		}
+		return string(r), nil
+	case StrategyRedact:
+		return Redacted, nil
+	case StrategyMask:
+		r := []rune(s)
+		for i := 0; i < len(r)-rule.length(); i++ {
+			r[i] = '*'
+		}
+		return string(r), nil
+	case StrategyGeneralize:
+		t, err := parseDate(v, field.Get("name").String())
+		if err != nil {
+			return "", err
+		}
+		if rule.Granularity == Year {
+			return t.Format("2006"), nil
+		}
+		return t.Format("2006-01"), nil
+	default:
+		return "", fmt.Errorf("unknown strategy %q", rule.Strategy)
+	}
+}
+
+// parseDate reads dates like Conversion, in UTC.
+func parseDate(v gjson.Result, logicalType string) (time.Time, error) {
+	t, err := Conversion{}.parseTime(v, logicalType)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.UTC(), nil
+}
+
+// schemaFieldPath returns the sjson path of the schema field describing the
+// payload field at path, following nested struct fields.
+func schemaFieldPath(p []byte, path string) (string, bool) {
+	schemaPath := "schema"
+	for _, name := range strings.Split(path, ".") {
+		found := false
+		gjson.GetBytes(p, schemaPath+".fields").ForEach(func(i, f gjson.Result) bool {
+			if f.Get("field").String() == name {
+				schemaPath = fmt.Sprintf("%s.fields.%d", schemaPath, i.Int())
+				found = true
+				return false
+			}
+			return true
+		})
+		if !found {
+			return "", false
+		}
+	}
+	return schemaPath, true
+}
+
+func schemaField(p []byte, path string) gjson.Result {
+	schemaPath, ok := schemaFieldPath(p, path)
+	if !ok {
+		return gjson.Result{}
+	}
+	return gjson.GetBytes(p, schemaPath)
+}
+
+// setSchemaString retypes the schema field for path to a plain string. Its
+// default, of the old type and not anonymized, is dropped.
+func setSchemaString(p []byte, path string) ([]byte, error) {
+	schemaPath, ok := schemaFieldPath(p, path)
+	if !ok {
+		return p, nil
+	}
+
+	var err error
+	for _, attr := range []string{"name", "version", "parameters", "default"} {
+		p, err = sjson.DeleteBytes(p, schemaPath+"."+attr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sjson.SetBytes(p, schemaPath+".type", "string")
+}
+
+// setStringField sets the payload field at path to s, adding it to the schema
+// as an optional string if it's new.
+func setStringField(p []byte, path string, s string) ([]byte, error) {
+	nestedPath := "payload." + path
+	fieldExists := gjson.GetBytes(p, nestedPath).Exists()
+
+	p, err := sjson.SetBytes(p, nestedPath, s)
+	if err != nil {
+		return nil, err
+	}
+	if fieldExists {
+		return setSchemaString(p, path)
+	}
+
+	parentPath := "schema"
+	if i := strings.LastIndex(path, "."); i >= 0 {
+		var ok bool
+		parentPath, ok = schemaFieldPath(p, path[:i])
+		if !ok {
+			return p, nil
+		}
+	}
+	if !gjson.GetBytes(p, parentPath).Exists() {
+		return p, nil
+	}
+	return sjson.SetBytes(p, parentPath+".fields.-1", map[string]interface{}{
+		"field":    path[strings.LastIndex(path, ".")+1:],
+		"optional": true,
+		"type":     "string",
+	})
+}
+
+func deleteSchemaField(p []byte, path string) ([]byte, error) {
+	schemaPath, ok := schemaFieldPath(p, path)
+	if !ok {
+		return p, nil
+	}
+	return sjson.DeleteBytes(p, schemaPath)
+}
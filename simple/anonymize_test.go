@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/meroxa/turbine-go"
+	"github.com/tidwall/gjson"
+)
+
+func TestAnonymize_Strategies(t *testing.T) {
+	key := []byte("secret")
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("user8@example.com"))
+	hashed := hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name  string
+		rule  FieldRule
+		field string
+		want  interface{}
+	}{
+		{name: "hmac", rule: HMAC("email", key), field: "email", want: hashed},
+		{name: "truncate", rule: Truncate("email", 5), field: "email", want: "user8"},
+		{name: "truncate short value", rule: Truncate("activity", 100), field: "activity", want: "registered"},
+		{name: "redact", rule: Redact("activity"), field: "activity", want: Redacted},
+		{name: "mask", rule: Mask("email", 4), field: "email", want: "*************.com"},
+		{name: "mask short value", rule: Mask("activity", 20), field: "activity", want: "registered"},
+		{name: "truncate negative length", rule: Truncate("activity", -1), field: "activity", want: ""},
+		{name: "mask negative length", rule: Mask("activity", -1), field: "activity", want: "**********"},
+		{name: "generalize to month", rule: GeneralizeDate("created_at", Month), field: "created_at", want: "2022-01"},
+		{name: "generalize to year", rule: GeneralizeDate("created_at", Year), field: "created_at", want: "2022"},
+		{name: "hmac number", rule: HMAC("user_id", key), field: "user_id", want: hmacHex(key, "108")},
+		{name: "delete", rule: Delete("email"), field: "email", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := readFixtureRecords(t, "fixtures/pg.json", "user_activity")
+			out := NewAnonymize(tt.rule).Process(rr[:1])
+			if len(out) != 1 {
+				t.Fatalf("want 1 record, got %d", len(out))
+			}
+
+			p := out[0].Payload
+			if got := p.Get(tt.field); got != tt.want {
+				t.Fatalf("want %s to be %v, got %v", tt.field, tt.want, got)
+			}
+
+			field := schemaFieldByName(p, tt.field)
+			if tt.rule.Strategy == StrategyDelete {
+				if field.Exists() {
+					t.Fatalf("want %s to be removed from the schema, got %s", tt.field, field.Raw)
+				}
+				return
+			}
+			if field.Get("type").String() != "string" || field.Get("name").Exists() {
+				t.Fatalf("want %s to be a plain string in the schema, got %s", tt.field, field.Raw)
+			}
+			if !field.Get("optional").Exists() {
+				t.Fatalf("want the rest of the %s schema field to be kept, got %s", tt.field, field.Raw)
+			}
+		})
+	}
+}
+
+func TestAnonymize_DropsDefault(t *testing.T) {
+	rr := []turbine.Record{{
+		Key: "1",
+		Payload: []byte(`{"schema":{"type":"struct","fields":[` +
+			`{"field":"user_id","type":"int32","optional":true,"default":0}]},` +
+			`"payload":{"user_id":108}}`),
+	}}
+	out := NewAnonymize(HMAC("user_id", []byte("secret"))).Process(rr)
+	if len(out) != 1 {
+		t.Fatalf("want 1 record, got %d", len(out))
+	}
+
+	field := schemaFieldByName(out[0].Payload, "user_id")
+	if field.Get("type").String() != "string" || field.Get("default").Exists() {
+		t.Fatalf("want user_id to be a string without its int default, got %s", field.Raw)
+	}
+}
+
+func TestAnonymize_NullAndMissingFields(t *testing.T) {
+	rr := readFixtureRecords(t, "fixtures/pg.json", "user_activity")
+	out := NewAnonymize(GeneralizeDate("deleted_at", Month), Redact("missing")).Process(rr)
+	if len(out) != len(rr) {
+		t.Fatalf("want %d records, got %d", len(rr), len(out))
+	}
+	for _, r := range out {
+		if got := r.Payload.Get("deleted_at"); got != nil {
+			t.Fatalf("want deleted_at to stay null, got %v", got)
+		}
+		if r.Payload.Get("missing") != nil {
+			t.Fatalf("want missing field not to be added, got %s", r.Payload)
+		}
+	}
+}
+
+func TestAnonymize_NestedFields(t *testing.T) {
+	r := turbine.Record{
+		Key: "1",
+		Payload: []byte(`{"schema":{"type":"struct","fields":[{"field":"id","type":"int32"},` +
+			`{"field":"user","type":"struct","fields":[{"field":"email","type":"string","optional":true},` +
+			`{"field":"birthday","type":"int32","name":"org.apache.kafka.connect.data.Date","optional":true},` +
+			`{"field":"ssn","type":"string","optional":true}]}]},` +
+			`"payload":{"id":1,"user":{"email":"alice@example.com","birthday":7000,"ssn":"123-45-6789"}}}`),
+	}
+
+	out := NewAnonymize(
+		Mask("user.email", 11),
+		GeneralizeDate("user.birthday", Year),
+		Delete("user.ssn"),
+	).Process([]turbine.Record{r})
+
+	p := out[0].Payload
+	if got := p.Get("user.email"); got != "******example.com" {
+		t.Fatalf("want masked email, got %v", got)
+	}
+	if got := p.Get("user.birthday"); got != "1989" {
+		t.Fatalf("want birthday to be generalized to 1989, got %v", got)
+	}
+	if p.Get("user.ssn") != nil {
+		t.Fatalf("want ssn to be deleted, got %s", p)
+	}
+
+	fields := gjson.GetBytes(p, "schema.fields.1.fields")
+	if got := fields.Get("#.field").String(); got != `["email","birthday"]` {
+		t.Fatalf("want ssn to be removed from the nested schema, got %s", got)
+	}
+	if got := fields.Get("1.type").String(); got != "string" || fields.Get("1.name").Exists() {
+		t.Fatalf("want birthday to be retyped to string, got %s", fields.Get("1").Raw)
+	}
+}
+
+func TestAnonymize_InvalidRecordDropped(t *testing.T) {
+	rr := readFixtureRecords(t, "fixtures/pg.json", "user_activity")
+	out := NewAnonymize(GeneralizeDate("activity", Month)).Process(rr)
+	if len(out) != 0 {
+		t.Fatalf("want records that can't be anonymized to be dropped, got %d", len(out))
+	}
+}
+
+func hmacHex(key []byte, s string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func schemaFieldByName(p turbine.Payload, name string) gjson.Result {
+	return gjson.GetBytes(p, `schema.fields.#(field=="`+name+`")`)
+}
@@ -1,22 +1,19 @@
 package main
 
 import (
-	"crypto/md5"
-	"encoding/hex"
-	"log"
-
 	turbine "github.com/meroxa/turbine-go"
 	"github.com/meroxa/turbine-go/runner"
 )
 
 func main() {
+	loadDedupState()
 	runner.Start(App{})
+	closeDedupState()
 }
 
 var _ turbine.App = (*App)(nil)
 
 type App struct{}
-type Anonymize struct{}
 
 func (a App) Run(v turbine.Turbine) error {
 	db, err := v.Resources("demopg")
@@ -29,9 +26,26 @@ func (a App) Run(v turbine.Turbine) error {
 		return err
 	}
 
-	res := v.Process(rr, Anonymize{})
+	pseudonymizer, err := pseudonymizerFromSecrets(v)
+	if err != nil {
+		return err
+	}
+
+	// CDC replays and at-least-once delivery repeat records
+	deduped := v.Process(rr, userActivityDedup)
+
+	anonymized := v.Process(deduped, NewAnonymize(
+		Pseudonym("email", pseudonymizer),
+	))
 	// second return is dead-letter queue
 
+	// S3 consumers expect RFC 3339 UTC times rather than epoch milliseconds
+	res := v.Process(anonymized, NewConvert(
+		Cast("created_at", TypeString),
+		Cast("updated_at", TypeString),
+		Cast("deleted_at", TypeString),
+	))
+
 	s3, err := v.Resources("s3")
 	if err != nil {
 		return err
@@ -43,21 +57,3 @@ func (a App) Run(v turbine.Turbine) error {
 
 	return nil
 }
-
-func (f Anonymize) Process(rr []turbine.Record) []turbine.Record {
-	for i, r := range rr {
-		hashedEmail := consistentHash(r.Payload.Get("email").(string))
-		err := r.Payload.Set("email", hashedEmail)
-		if err != nil {
-			log.Println("error setting value: ", err)
-			break
-		}
-		rr[i] = r
-	}
-	return rr
-}
-
-func consistentHash(s string) string {
-	h := md5.Sum([]byte(s))
-	return hex.EncodeToString(h[:])
-}
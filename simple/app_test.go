@@ -8,4 +8,15 @@ func TestApp_Run(t *testing.T) {
 }
 
 func TestAnonymize_Process(t *testing.T) {
+	rr := readFixtureRecords(t, "fixtures/pg.json", "user_activity")
+	out := NewAnonymize(HMAC("email", []byte("secret"))).Process(rr)
+
+	if len(out) != 3 {
+		t.Fatalf("want 3 records, got %d", len(out))
+	}
+	for _, r := range out {
+		if got := r.Payload.Get("email"); got != hmacHex([]byte("secret"), "user8@example.com") {
+			t.Fatalf("want email to be hashed, got %v", got)
+		}
+	}
 }
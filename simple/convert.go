@@ -0,0 +1,468 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/meroxa/turbine-go"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// Type is what a Conversion casts a value to.
+type Type string
+
+const (
+	TypeString    Type = "string"
+	TypeInt       Type = "int"       // int64
+	TypeFloat     Type = "float"     // float64
+	TypeBool      Type = "bool"      // boolean
+	TypeTimestamp Type = "timestamp" // Kafka Connect Timestamp, epoch milliseconds
+	TypeDate      Type = "date"      // Kafka Connect Date, epoch days
+	TypeDecimal   Type = "decimal"   // Kafka Connect Decimal, see WithScale
+)
+
+// EpochUnit is the unit of a number holding a point in time.
+type EpochUnit string
+
+const (
+	Seconds      EpochUnit = "s"
+	Milliseconds EpochUnit = "ms"
+	Microseconds EpochUnit = "us"
+	Nanoseconds  EpochUnit = "ns"
+)
+
+// LayoutNTZ formats times without a time zone, the way Snowflake expects
+// TIMESTAMP_NTZ values.
+const LayoutNTZ = "2006-01-02 15:04:05.999999999"
+
+const (
+	kcTimestamp = "org.apache.kafka.connect.data.Timestamp"
+	kcDate      = "org.apache.kafka.connect.data.Date"
+	kcDecimal   = "org.apache.kafka.connect.data.Decimal"
+)
+
+// Conversion casts the payload field at Path, which may be a nested path such
+// as "user.created_at", to the type To. Conversions are built with Cast and
+// refined with WithUnit, WithLayout, WithFormat, WithLocation and WithScale.
+//
+// Values are read as points in time when the field has the Kafka Connect
+// Timestamp or Date logical type, when Unit is set, or when they are strings
+// and To is a time type or Layout or Format is set. Fields with the Decimal
+// logical type are decoded with the scale in their schema. Casts to int fail
+// rather than drop a fraction or overflow.
+type Conversion struct {
+	Path     string
+	To       Type
+	Unit     EpochUnit      // of numbers holding times, read and written, milliseconds by default
+	Layout   string         // parses string times, RFC 3339 and "2006-01-02" by default
+	Format   string         // formats times cast to strings, RFC 3339 by default
+	Location *time.Location // of times without a zone, and of formatted times, UTC by default
+	Scale    int            // digits after the decimal point of decimals
+}
+
+// Cast returns a Conversion of the field at path to type to.
+func Cast(path string, to Type) Conversion {
+	return Conversion{Path: path, To: to}
+}
+
+// WithUnit reads and writes times as epoch numbers in unit u.
+func (c Conversion) WithUnit(u EpochUnit) Conversion {
+	c.Unit = u
+	return c
+}
+
+// WithLayout parses string times with layout.
+func (c Conversion) WithLayout(layout string) Conversion {
+	c.Layout = layout
+	return c
+}
+
+// WithFormat formats times cast to strings with layout.
+func (c Conversion) WithFormat(layout string) Conversion {
+	c.Format = layout
+	return c
+}
+
+// WithLocation reads times without a zone in loc, and formats times in loc.
+func (c Conversion) WithLocation(loc *time.Location) Conversion {
+	c.Location = loc
+	return c
+}
+
+// WithScale sets the number of digits after the decimal point of decimals.
+func (c Conversion) WithScale(scale int) Conversion {
+	c.Scale = scale
+	return c
+}
+
+// Convert applies its Conversions to every record and rewrites the schema of
+// each converted field to match. Null values are kept as they are, but their
+// schema is still rewritten. Records that can't be converted are dropped.
+type Convert struct {
+	Conversions []Conversion
+}
+
+func NewConvert(cc ...Conversion) Convert {
+	return Convert{Conversions: cc}
+}
+
+func (f Convert) Process(rr []turbine.Record) []turbine.Record {
+	out := rr[:0]
+	for _, r := range rr {
+		err := f.convert(&r.Payload)
+		if err != nil {
+			log.Printf("error converting record %s: %s", r.Key, err)
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func (f Convert) convert(p *turbine.Payload) error {
+	for _, c := range f.Conversions {
+		err := c.apply(p)
+		if err != nil {
+			return fmt.Errorf("cast %s to %s: %w", c.Path, c.To, err)
+		}
+	}
+	return nil
+}
+
+func (c Conversion) apply(p *turbine.Payload) error {
+	nestedPath := "payload." + c.Path
+	res := gjson.GetBytes(*p, nestedPath)
+	if !res.Exists() {
+		return nil
+	}
+
+	val := []byte(*p)
+	if res.Type != gjson.Null {
+		v, err := c.convertValue(res, schemaField(val, c.Path))
+		if err != nil {
+			return err
+		}
+		val, err = sjson.SetBytes(val, nestedPath, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	val, err := c.setSchemaType(val)
+	if err != nil {
+		return err
+	}
+	*p = val
+	return nil
+}
+
+func (c Conversion) convertValue(v gjson.Result, field gjson.Result) (interface{}, error) {
+	logicalType := field.Get("name").String()
+	if logicalType == kcDecimal && v.Type == gjson.String {
+		d, err := decodeDecimal(v.Str, int(field.Get("parameters.scale").Int()))
+		if err != nil {
+			return nil, err
+		}
+		return c.fromDecimal(d)
+	}
+
+	isTime := logicalType == kcTimestamp || logicalType == kcDate || c.Unit != "" ||
+		(v.Type == gjson.String && (c.To == TypeTimestamp || c.To == TypeDate || c.Layout != "" || c.Format != ""))
+
+	if isTime {
+		t, err := c.parseTime(v, logicalType)
+		if err != nil {
+			return nil, err
+		}
+		return c.fromTime(t)
+	}
+
+	switch c.To {
+	case TypeString:
+		return v.String(), nil
+	case TypeInt:
+		switch v.Type {
+		case gjson.Number:
+			return parseInt(v.Raw)
+		case gjson.String:
+			return parseInt(strings.TrimSpace(v.Str))
+		case gjson.True:
+			return 1, nil
+		case gjson.False:
+			return 0, nil
+		}
+	case TypeFloat:
+		switch v.Type {
+		case gjson.Number:
+			return v.Float(), nil
+		case gjson.String:
+			return strconv.ParseFloat(strings.TrimSpace(v.Str), 64)
+		case gjson.True:
+			return 1.0, nil
+		case gjson.False:
+			return 0.0, nil
+		}
+	case TypeBool:
+		switch v.Type {
+		case gjson.Number:
+			return v.Float() != 0, nil
+		case gjson.String:
+			return strconv.ParseBool(strings.TrimSpace(v.Str))
+		case gjson.True, gjson.False:
+			return v.Bool(), nil
+		}
+	case TypeTimestamp, TypeDate:
+		t, err := c.parseTime(v, logicalType)
+		if err != nil {
+			return nil, err
+		}
+		return c.fromTime(t)
+	case TypeDecimal:
+		switch v.Type {
+		case gjson.Number:
+			return encodeDecimal(v.Raw, c.Scale)
+		case gjson.String:
+			return encodeDecimal(strings.TrimSpace(v.Str), c.Scale)
+		}
+	default:
+		return nil, fmt.Errorf("unknown type %q", c.To)
+	}
+	return nil, fmt.Errorf("unable to cast %s", v.Raw)
+}
+
+func (c Conversion) location() *time.Location {
+	if c.Location == nil {
+		return time.UTC
+	}
+	return c.Location
+}
+
+// fromDecimal casts the decoded decimal d.
+func (c Conversion) fromDecimal(d string) (interface{}, error) {
+	switch c.To {
+	case TypeString:
+		return d, nil
+	case TypeInt:
+		return parseInt(d)
+	case TypeFloat:
+		return strconv.ParseFloat(d, 64)
+	case TypeBool:
+		r, _ := new(big.Rat).SetString(d)
+		return r.Sign() != 0, nil
+	case TypeDecimal:
+		return encodeDecimal(d, c.Scale)
+	default:
+		return nil, fmt.Errorf("unable to cast a decimal to %s", c.To)
+	}
+}
+
+// parseInt parses s as an int64. Numbers with a fraction or exponent are
+// accepted as long as they are whole and in range.
+func parseInt(s string) (int64, error) {
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err == nil {
+		return i, nil
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, err
+	}
+	if !r.IsInt() {
+		return 0, fmt.Errorf("%s is not a whole number", s)
+	}
+	if !r.Num().IsInt64() {
+		return 0, fmt.Errorf("%s is out of range", s)
+	}
+	return r.Num().Int64(), nil
+}
+
+func (c Conversion) parseTime(v gjson.Result, logicalType string) (time.Time, error) {
+	switch {
+	case v.Type == gjson.Number && logicalType == kcDate:
+		return time.Unix(v.Int()*24*60*60, 0).UTC(), nil
+	case v.Type == gjson.Number && logicalType == kcTimestamp:
+		return time.UnixMilli(v.Int()).UTC(), nil
+	case v.Type == gjson.Number:
+		return fromEpoch(v.Int(), c.Unit), nil
+	case v.Type == gjson.String && c.Layout != "":
+		return time.ParseInLocation(c.Layout, v.Str, c.location())
+	case v.Type == gjson.String:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+			t, err := time.ParseInLocation(layout, v.Str, c.location())
+			if err == nil {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("unable to parse %s as a time", v.Raw)
+}
+
+func (c Conversion) fromTime(t time.Time) (interface{}, error) {
+	t = t.In(c.location())
+	switch c.To {
+	case TypeString:
+		if c.Format != "" {
+			return t.Format(c.Format), nil
+		}
+		return t.Format(time.RFC3339Nano), nil
+	case TypeInt:
+		return toEpoch(t, c.Unit), nil
+	case TypeFloat:
+		return float64(toEpoch(t, c.Unit)), nil
+	case TypeTimestamp:
+		return t.UnixMilli(), nil
+	case TypeDate:
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return day.Unix() / (24 * 60 * 60), nil
+	default:
+		return nil, fmt.Errorf("unable to cast a time to %s", c.To)
+	}
+}
+
+func fromEpoch(n int64, u EpochUnit) time.Time {
+	switch u {
+	case Seconds:
+		return time.Unix(n, 0).UTC()
+	case Microseconds:
+		return time.UnixMicro(n).UTC()
+	case Nanoseconds:
+		return time.Unix(0, n).UTC()
+	default:
+		return time.UnixMilli(n).UTC()
+	}
+}
+
+func toEpoch(t time.Time, u EpochUnit) int64 {
+	switch u {
+	case Seconds:
+		return t.Unix()
+	case Microseconds:
+		return t.UnixMicro()
+	case Nanoseconds:
+		return t.UnixNano()
+	default:
+		return t.UnixMilli()
+	}
+}
+
+// decodeDecimal reverses encodeDecimal, returning the value with scale digits
+// after the decimal point.
+func decodeDecimal(s string, scale int) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", fmt.Errorf("unable to decode %q as a decimal: %w", s, err)
+	}
+	unscaled := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		unscaled.Sub(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(len(b))*8))
+	}
+	r := new(big.Rat).SetInt(unscaled)
+	if scale >= 0 {
+		r.Quo(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)))
+		return r.FloatString(scale), nil
+	}
+	r.Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(-scale)), nil)))
+	return r.FloatString(0), nil
+}
+
+// encodeDecimal returns the Kafka Connect encoding of the decimal number s
+// with scale digits after the decimal point: the base64 encoded, big-endian
+// two's complement bytes of its unscaled value. A negative scale rounds s to a
+// multiple of 10^-scale. Extra digits are rounded half away from zero.
+func encodeDecimal(s string, scale int) (string, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return "", fmt.Errorf("unable to parse %q as a decimal", s)
+	}
+	if scale >= 0 {
+		r.Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)))
+	} else {
+		r.Quo(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(-scale)), nil)))
+	}
+
+	// round half away from zero
+	num, den := new(big.Int).Abs(r.Num()), r.Denom()
+	unscaled, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if rem.Mul(rem, big.NewInt(2)).Cmp(den) >= 0 {
+		unscaled.Add(unscaled, big.NewInt(1))
+	}
+	if r.Sign() < 0 {
+		unscaled.Neg(unscaled)
+	}
+	return base64.StdEncoding.EncodeToString(twosComplement(unscaled)), nil
+}
+
+func twosComplement(i *big.Int) []byte {
+	if i.Sign() >= 0 {
+		b := i.Bytes()
+		if len(b) == 0 || b[0]&0x80 != 0 {
+			b = append([]byte{0}, b...)
+		}
+		return b
+	}
+
+	// 2^n - |i|, with n a whole number of bytes large enough to keep the
+	// sign bit set
+	n := uint(i.BitLen()/8+1) * 8
+	return new(big.Int).Add(new(big.Int).Lsh(big.NewInt(1), n), i).Bytes()
+}
+
+// setSchemaType rewrites the schema field for c.Path to describe c.To. The
+// field's default is dropped, as it holds a value of the old type.
+func (c Conversion) setSchemaType(p []byte) ([]byte, error) {
+	schemaPath, ok := schemaFieldPath(p, c.Path)
+	if !ok {
+		return p, nil
+	}
+
+	var err error
+	for _, attr := range []string{"name", "version", "parameters", "default"} {
+		p, err = sjson.DeleteBytes(p, schemaPath+"."+attr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	attrs := map[string]interface{}{}
+	switch c.To {
+	case TypeString:
+		attrs["type"] = "string"
+	case TypeInt:
+		attrs["type"] = "int64"
+	case TypeFloat:
+		attrs["type"] = "float64"
+	case TypeBool:
+		attrs["type"] = "boolean"
+	case TypeTimestamp:
+		attrs["type"] = "int64"
+		attrs["name"] = kcTimestamp
+		attrs["version"] = 1
+	case TypeDate:
+		attrs["type"] = "int32"
+		attrs["name"] = kcDate
+		attrs["version"] = 1
+	case TypeDecimal:
+		attrs["type"] = "bytes"
+		attrs["name"] = kcDecimal
+		attrs["version"] = 1
+		attrs["parameters"] = map[string]string{"scale": strconv.Itoa(c.Scale)}
+	}
+
+	for _, attr := range []string{"type", "name", "version", "parameters"} {
+		v, ok := attrs[attr]
+		if !ok {
+			continue
+		}
+		p, err = sjson.SetBytes(p, schemaPath+"."+attr, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/meroxa/turbine-go"
+	"github.com/tidwall/gjson"
+)
+
+func TestConvert_Fixtures(t *testing.T) {
+	rr := readFixtureRecords(t, "fixtures/pg.json", "user_activity")
+	out := NewConvert(
+		Cast("created_at", TypeString),
+		Cast("updated_at", TypeString).WithFormat(LayoutNTZ),
+		Cast("deleted_at", TypeString),
+	).Process(rr)
+
+	if len(out) != 3 {
+		t.Fatalf("want 3 records, got %d", len(out))
+	}
+	p := out[0].Payload
+	if got := p.Get("created_at"); got != "2022-01-26T16:25:53.68Z" {
+		t.Fatalf("want created_at 2022-01-26T16:25:53.68Z, got %v", got)
+	}
+	if got := p.Get("updated_at"); got != "2022-01-26 16:25:53.68" {
+		t.Fatalf("want updated_at 2022-01-26 16:25:53.68, got %v", got)
+	}
+	if got := p.Get("deleted_at"); got != nil {
+		t.Fatalf("want deleted_at to stay null, got %v", got)
+	}
+
+	for _, name := range []string{"created_at", "updated_at", "deleted_at"} {
+		f := schemaFieldByName(p, name)
+		if f.Get("type").String() != "string" || f.Get("name").Exists() || f.Get("version").Exists() {
+			t.Fatalf("want %s to be a plain string, got %s", name, f.Raw)
+		}
+	}
+	if !schemaFieldByName(p, "deleted_at").Get("optional").Bool() {
+		t.Fatal("want deleted_at to stay optional")
+	}
+}
+
+func TestConvert_Values(t *testing.T) {
+	amsterdam, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Skipf("time zone database not available: %s", err)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		conv  Conversion
+		want  string
+		kind  string
+		logic string
+	}{
+		{"string to int", `"42"`, Cast("v", TypeInt), `42`, "int64", ""},
+		{"float string to int", `"42.0"`, Cast("v", TypeInt), `42`, "int64", ""},
+		{"string to float", `"4.5"`, Cast("v", TypeFloat), `4.5`, "float64", ""},
+		{"number to string", `4.5`, Cast("v", TypeString), `"4.5"`, "string", ""},
+		{"string to bool", `"true"`, Cast("v", TypeBool), `true`, "boolean", ""},
+		{"number to bool", `0`, Cast("v", TypeBool), `false`, "boolean", ""},
+		{"epoch seconds to timestamp", `1643214353`, Cast("v", TypeTimestamp).WithUnit(Seconds), `1643214353000`, "int64", kcTimestamp},
+		{"timestamp to epoch seconds", `"2022-01-26T16:25:53Z"`, Cast("v", TypeInt).WithUnit(Seconds), `1643214353`, "int64", ""},
+		{"epoch seconds to string", `1643214353`, Cast("v", TypeString).WithUnit(Seconds).WithLocation(amsterdam), `"2022-01-26T17:25:53+01:00"`, "string", ""},
+		{"layout to timestamp", `"26/01/2022 17:25"`, Cast("v", TypeTimestamp).WithLayout("02/01/2006 15:04").WithLocation(amsterdam), `1643214300000`, "int64", kcTimestamp},
+		{"string to date", `"2022-01-26T23:30:00-05:00"`, Cast("v", TypeDate), `19019`, "int32", kcDate},
+		{"string to ntz", `"2022-01-26T17:25:53+01:00"`, Cast("v", TypeString).WithFormat(LayoutNTZ), `"2022-01-26 16:25:53"`, "string", ""},
+		{"number to decimal", `12.345`, Cast("v", TypeDecimal).WithScale(2), `"BNM="`, "bytes", kcDecimal},
+		{"negative decimal", `"-1"`, Cast("v", TypeDecimal), `"/w=="`, "bytes", kcDecimal},
+		{"decimal sign byte", `128`, Cast("v", TypeDecimal), `"AIA="`, "bytes", kcDecimal},
+		{"negative scale", `12355`, Cast("v", TypeDecimal).WithScale(-2), `"fA=="`, "bytes", kcDecimal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := turbine.Payload(`{"schema": {"type": "struct", "fields": [{"field": "v", "type": "string", "optional": false}]}, "payload": {"v": ` + tt.value + `}}`)
+			out := NewConvert(tt.conv).Process([]turbine.Record{{Key: "1", Payload: p}})
+			if len(out) != 1 {
+				t.Fatalf("want 1 record, got %d", len(out))
+			}
+
+			p = out[0].Payload
+			if got := gjson.GetBytes(p, "payload.v").Raw; got != tt.want {
+				t.Fatalf("want %s, got %s", tt.want, got)
+			}
+			f := schemaFieldByName(p, "v")
+			if got := f.Get("type").String(); got != tt.kind {
+				t.Fatalf("want type %s, got %s", tt.kind, got)
+			}
+			if got := f.Get("name").String(); got != tt.logic {
+				t.Fatalf("want name %q, got %q", tt.logic, got)
+			}
+		})
+	}
+}
+
+func TestConvert_DecimalScaleParameter(t *testing.T) {
+	p := turbine.Payload(`{"schema": {"type": "struct", "fields": [{"field": "v", "type": "float64", "optional": false}]}, "payload": {"v": 1.5}}`)
+	out := NewConvert(Cast("v", TypeDecimal).WithScale(3)).Process([]turbine.Record{{Key: "1", Payload: p}})
+
+	if got := schemaFieldByName(out[0].Payload, "v").Get("parameters.scale").String(); got != "3" {
+		t.Fatalf("want scale parameter 3, got %q", got)
+	}
+}
+
+func TestConvert_DropsDefault(t *testing.T) {
+	p := turbine.Payload(`{"schema": {"type": "struct", "fields": [{"field": "v", "type": "string", "optional": true, "default": "0"}]}, "payload": {"v": "42"}}`)
+	out := NewConvert(Cast("v", TypeInt)).Process([]turbine.Record{{Key: "1", Payload: p}})
+	if len(out) != 1 {
+		t.Fatalf("want 1 record, got %d", len(out))
+	}
+
+	f := schemaFieldByName(out[0].Payload, "v")
+	if f.Get("type").String() != "int64" || f.Get("default").Exists() {
+		t.Fatalf("want v to be an int64 without its string default, got %s", f.Raw)
+	}
+}
+
+func TestConvert_InvalidRecordDropped(t *testing.T) {
+	rr := []turbine.Record{
+		{Key: "1", Payload: []byte(`{"payload": {"v": "not a number"}}`)},
+		{Key: "2", Payload: []byte(`{"payload": {"v": "7"}}`)},
+		{Key: "3", Payload: []byte(`{"payload": {"v": "1.9"}}`)},
+		{Key: "4", Payload: []byte(`{"payload": {"v": 1e30}}`)},
+	}
+	out := NewConvert(Cast("v", TypeInt)).Process(rr)
+
+	if len(out) != 1 || out[0].Key != "2" {
+		t.Fatalf("want only record 2, got %v", out)
+	}
+}
+
+func TestConvert_FromDecimal(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		conv  Conversion
+		want  string
+	}{
+		{"to string", `"AJY="`, Cast("v", TypeString), `"1.50"`},
+		{"negative to string", `"/2o="`, Cast("v", TypeString), `"-1.50"`},
+		{"to float", `"AJY="`, Cast("v", TypeFloat), `1.5`},
+		{"to int", `"AMg="`, Cast("v", TypeInt), `2`},
+		{"to bool", `"AA=="`, Cast("v", TypeBool), `false`},
+		{"rescale", `"AJY="`, Cast("v", TypeDecimal).WithScale(3), `"Bdw="`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := turbine.Payload(`{"schema": {"type": "struct", "fields": [{"field": "v", "type": "bytes", "optional": false, ` +
+				`"name": "org.apache.kafka.connect.data.Decimal", "version": 1, "parameters": {"scale": "2"}}]}, "payload": {"v": ` + tt.value + `}}`)
+			out := NewConvert(tt.conv).Process([]turbine.Record{{Key: "1", Payload: p}})
+			if len(out) != 1 {
+				t.Fatalf("want 1 record, got %d", len(out))
+			}
+			if got := gjson.GetBytes(out[0].Payload, "payload.v").Raw; got != tt.want {
+				t.Fatalf("want %s, got %s", tt.want, got)
+			}
+		})
+	}
+
+	p := turbine.Payload(`{"schema": {"type": "struct", "fields": [{"field": "v", "type": "bytes", "optional": false, ` +
+		`"name": "org.apache.kafka.connect.data.Decimal", "version": 1, "parameters": {"scale": "2"}}]}, "payload": {"v": "AJY="}}`)
+	if out := NewConvert(Cast("v", TypeInt)).Process([]turbine.Record{{Key: "1", Payload: p}}); len(out) != 0 {
+		t.Fatalf("want 1.50 to int to be dropped, got %s", out[0].Payload)
+	}
+}
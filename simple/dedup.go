@@ -0,0 +1,259 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/meroxa/turbine-go"
+	"github.com/tidwall/gjson"
+)
+
+// DedupStore remembers the keys Dedup has seen.
+type DedupStore interface {
+	// Add records key as seen at t. It returns false if key was already
+	// there, leaving the time it was first seen untouched.
+	Add(key string, t time.Time) (bool, error)
+	// Expire forgets keys first seen before t.
+	Expire(t time.Time) error
+}
+
+// DedupKey returns the key records are deduplicated on.
+type DedupKey func(turbine.Record) (string, error)
+
+// RecordKey deduplicates records on their key. CDC sources reuse the key of a
+// row for its updates and deletes, which RecordKey drops as duplicates; use
+// ContentHash for them.
+func RecordKey(r turbine.Record) (string, error) {
+	if r.Key == "" {
+		return "", errors.New("missing key")
+	}
+	return r.Key, nil
+}
+
+// FieldsKey deduplicates records on the values of the payload fields at
+// paths. Missing fields count as null.
+func FieldsKey(paths ...string) DedupKey {
+	return func(r turbine.Record) (string, error) {
+		values := make([]string, len(paths))
+		for i, path := range paths {
+			res := gjson.GetBytes(r.Payload, "payload."+path)
+			values[i] = res.Raw
+			if !res.Exists() {
+				values[i] = "null"
+			}
+		}
+		b, err := json.Marshal(values)
+		if err != nil {
+			return "", err
+		}
+		return hashKey(b), nil
+	}
+}
+
+// ContentHash deduplicates records on a hash of their whole payload, without
+// the schema, so only exact replays are dropped: an update or a delete of the
+// same row changes the payload.
+func ContentHash(r turbine.Record) (string, error) {
+	data := gjson.GetBytes(r.Payload, "payload")
+	if !data.Exists() {
+		return "", errors.New("missing payload")
+	}
+	return hashKey([]byte(data.Raw)), nil
+}
+
+func hashKey(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// DedupStats counts the records seen by a Dedup.
+type DedupStats struct {
+	Seen       int
+	Duplicates int
+	Errors     int
+}
+
+func (s DedupStats) String() string {
+	return fmt.Sprintf("seen=%d duplicates=%d errors=%d", s.Seen, s.Duplicates, s.Errors)
+}
+
+// Dedup drops records whose Key was already seen within Window, as kept in
+// Store. The window starts when a key is first seen: duplicates don't extend
+// it. Records whose key can't be computed or checked are passed on, since a
+// duplicate is better than a lost record.
+type Dedup struct {
+	Key    DedupKey
+	Window time.Duration
+	Store  DedupStore
+	stats  *dedupStats
+	now    func() time.Time
+}
+
+type dedupStats struct {
+	mu sync.Mutex
+	DedupStats
+}
+
+func NewDedup(key DedupKey, window time.Duration, store DedupStore) Dedup {
+	return Dedup{Key: key, Window: window, Store: store, stats: &dedupStats{}, now: time.Now}
+}
+
+// Stats returns a snapshot of the counters of f and all its copies.
+func (f Dedup) Stats() DedupStats {
+	f.stats.mu.Lock()
+	defer f.stats.mu.Unlock()
+	return f.stats.DedupStats
+}
+
+func (f Dedup) Process(rr []turbine.Record) []turbine.Record {
+	now := f.now()
+	err := f.Store.Expire(now.Add(-f.Window))
+	if err != nil {
+		log.Printf("error expiring dedup keys: %s", err)
+	}
+
+	f.stats.mu.Lock()
+	defer f.stats.mu.Unlock()
+
+	out := rr[:0]
+	for _, r := range rr {
+		f.stats.Seen++
+		added, err := f.add(r, now)
+		if err != nil {
+			f.stats.Errors++
+			log.Printf("error deduplicating record %s: %s", r.Key, err)
+		}
+		if err == nil && !added {
+			f.stats.Duplicates++
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func (f Dedup) add(r turbine.Record, now time.Time) (bool, error) {
+	key, err := f.Key(r)
+	if err != nil {
+		return false, err
+	}
+	return f.Store.Add(key, now)
+}
+
+// MemoryDedupStore is a DedupStore kept in memory. Load and Save persist it to
+// a file between runs.
+type MemoryDedupStore struct {
+	mu   sync.Mutex
+	keys map[string]time.Time
+}
+
+var _ DedupStore = (*MemoryDedupStore)(nil)
+
+func NewMemoryDedupStore() *MemoryDedupStore {
+	return &MemoryDedupStore{keys: make(map[string]time.Time)}
+}
+
+func (s *MemoryDedupStore) Add(key string, t time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[key]; ok {
+		return false, nil
+	}
+	s.keys[key] = t
+	return true, nil
+}
+
+func (s *MemoryDedupStore) Expire(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, seen := range s.keys {
+		if seen.Before(t) {
+			delete(s.keys, key)
+		}
+	}
+	return nil
+}
+
+// Load reads keys previously written by Save. A missing file is not an error.
+func (s *MemoryDedupStore) Load(path string) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var keys map[string]time.Time
+	err = json.Unmarshal(b, &keys)
+	if err != nil {
+		return fmt.Errorf("unable to read dedup state %s: %w", path, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, seen := range keys {
+		s.keys[key] = seen
+	}
+	return nil
+}
+
+// Save writes all keys to path.
+func (s *MemoryDedupStore) Save(path string) error {
+	s.mu.Lock()
+	b, err := json.Marshal(s.keys)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, b, 0o600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// dedupWindow is how long user_activity keys are remembered, long enough to
+// cover CDC replays.
+const dedupWindow = 24 * time.Hour
+
+var (
+	dedupStore = NewMemoryDedupStore()
+	// Records are deduplicated on their content rather than their key, which
+	// the updates and deletes of a row share with its insert.
+	userActivityDedup = NewDedup(ContentHash, dedupWindow, dedupStore)
+)
+
+func loadDedupState() {
+	path := os.Getenv("DEDUP_STATE_FILE")
+	if path == "" {
+		return
+	}
+	err := dedupStore.Load(path)
+	if err != nil {
+		log.Println("error loading dedup state: ", err)
+	}
+}
+
+func closeDedupState() {
+	log.Printf("dedup stats: %s", userActivityDedup.Stats())
+	path := os.Getenv("DEDUP_STATE_FILE")
+	if path == "" {
+		return
+	}
+	err := dedupStore.Save(path)
+	if err != nil {
+		log.Println("error saving dedup state: ", err)
+	}
+}
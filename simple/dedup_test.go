@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/meroxa/turbine-go"
+)
+
+func TestDedup_Process(t *testing.T) {
+	now := time.Now()
+	f := NewDedup(RecordKey, time.Hour, NewMemoryDedupStore())
+	f.now = func() time.Time { return now }
+
+	rr := readFixtureRecords(t, "fixtures/pg.json", "user_activity")
+	replay := append(readFixtureRecords(t, "fixtures/pg.json", "user_activity")[:2], turbine.Record{Key: "4"})
+
+	if out := f.Process(rr); len(out) != 3 {
+		t.Fatalf("want 3 records, got %d", len(out))
+	}
+	out := f.Process(replay)
+	if len(out) != 1 || out[0].Key != "4" {
+		t.Fatalf("want only record 4, got %v", out)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if out := f.Process(readFixtureRecords(t, "fixtures/pg.json", "user_activity")); len(out) != 3 {
+		t.Fatalf("want keys to expire after the window, got %d records", len(out))
+	}
+
+	want := DedupStats{Seen: 9, Duplicates: 2}
+	if got := f.Stats(); got != want {
+		t.Fatalf("want stats %+v, got %+v", want, got)
+	}
+}
+
+func TestDedup_Keys(t *testing.T) {
+	a := turbine.Record{Key: "1", Payload: []byte(`{"payload": {"user_id": 108, "activity": "logged in", "id": 1}}`)}
+	b := turbine.Record{Key: "2", Payload: []byte(`{"payload": {"user_id": 108, "activity": "logged in", "id": 2}}`)}
+	c := turbine.Record{Key: "3", Payload: []byte(`{"payload": {"user_id": 108, "activity": "logged in", "id": 2}}`)}
+
+	tests := []struct {
+		name string
+		key  DedupKey
+		want []string
+	}{
+		{"record key", RecordKey, []string{"1", "2", "3"}},
+		{"fields", FieldsKey("user_id", "activity"), []string{"1"}},
+		{"content hash", ContentHash, []string{"1", "2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := NewDedup(tt.key, time.Hour, NewMemoryDedupStore()).Process([]turbine.Record{a, b, c})
+			if len(out) != len(tt.want) {
+				t.Fatalf("want %d records, got %d", len(tt.want), len(out))
+			}
+			for i, key := range tt.want {
+				if out[i].Key != key {
+					t.Fatalf("want record %s, got %s", key, out[i].Key)
+				}
+			}
+		})
+	}
+}
+
+func TestUserActivityDedup_KeepsUpdatesAndDeletes(t *testing.T) {
+	f := NewDedup(userActivityDedup.Key, time.Hour, NewMemoryDedupStore())
+
+	rr := readFixtureRecords(t, "fixtures/pg.json", "user_activity")
+	f.Process(rr)
+
+	replay := readFixtureRecords(t, "fixtures/pg.json", "user_activity")
+	updated := replay[0]
+	updated.Payload = []byte(strings.Replace(string(updated.Payload), `"updated_at":1643214353680`, `"updated_at":1643214400000`, 1))
+	deleted := replay[1]
+	deleted.Payload = []byte(strings.Replace(string(deleted.Payload), `"deleted_at":null`, `"deleted_at":1643214400000`, 1))
+
+	out := f.Process([]turbine.Record{replay[0], updated, deleted})
+	if len(out) != 2 || out[0].Key != "1" || out[1].Key != "2" {
+		t.Fatalf("want the update of 1 and the delete of 2, got %v", out)
+	}
+}
+
+func TestDedup_KeyErrorPassedOn(t *testing.T) {
+	f := NewDedup(RecordKey, time.Hour, NewMemoryDedupStore())
+	out := f.Process([]turbine.Record{{}, {}})
+
+	if len(out) != 2 {
+		t.Fatalf("want records without a key to be passed on, got %d", len(out))
+	}
+	if got := f.Stats().Errors; got != 2 {
+		t.Fatalf("want 2 errors, got %d", got)
+	}
+}
+
+func TestMemoryDedupStore_SaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.json")
+	now := time.Now()
+
+	s := NewMemoryDedupStore()
+	if _, err := s.Add("1", now); err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	if err := s.Save(path); err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+
+	loaded := NewMemoryDedupStore()
+	if err := loaded.Load(path); err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	added, err := loaded.Add("1", now)
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	if added {
+		t.Fatal("want key 1 to be known after loading")
+	}
+
+	if err := NewMemoryDedupStore().Load(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Fatalf("want no error for a missing file, got %s", err)
+	}
+}
+
+// readFixtureRecords reads a collection from a fixtures file the same way the
+// local runner does.
+func readFixtureRecords(t *testing.T, path, collection string) []turbine.Record {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+
+	var fixtures map[string][]struct {
+		Key   string
+		Value map[string]interface{}
+	}
+	err = json.Unmarshal(b, &fixtures)
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+
+	var rr []turbine.Record
+	for _, f := range fixtures[collection] {
+		p, _ := json.Marshal(f.Value)
+		rr = append(rr, turbine.Record{Key: f.Key, Payload: p})
+	}
+	return rr
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/meroxa/turbine-go"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// Payload fields recording how a record's fields were encrypted.
+const (
+	EncryptionKeyIDField   = "encryption_key_id"
+	EncryptionDataKeyField = "encryption_data_key"
+)
+
+// KeyProvider wraps and unwraps data keys with a master key.
+type KeyProvider interface {
+	// KeyID identifies the master key WrapKey uses.
+	KeyID() string
+	WrapKey(dataKey []byte) ([]byte, error)
+	// UnwrapKey unwraps a data key wrapped by the master key keyID.
+	UnwrapKey(keyID string, wrapped []byte) ([]byte, error)
+}
+
+// masterKey is a KeyProvider wrapping data keys with AES-256-GCM.
+type masterKey struct {
+	id  string
+	key []byte
+}
+
+// newMasterKey parses a base64 encoded 256-bit key. Its ID is prefixed to a
+// fingerprint of the key, so a rotated key gets a new ID.
+func newMasterKey(prefix, encoded string) (*masterKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("master key must be base64 encoded: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("master key must be 32 bytes, got %d", len(key))
+	}
+	sum := sha256.Sum256(key)
+	return &masterKey{id: prefix + ":" + hex.EncodeToString(sum[:8]), key: key}, nil
+}
+
+// NewFileKeyProvider reads a base64 encoded 256-bit master key from path.
+func NewFileKeyProvider(path string) (KeyProvider, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return newMasterKey("file", string(b))
+}
+
+// NewSecretKeyProvider registers the secret name with v and uses its value, a
+// base64 encoded 256-bit key, as master key.
+func NewSecretKeyProvider(v turbine.Turbine, name string) (KeyProvider, error) {
+	err := v.RegisterSecret(name) // makes env var available to data app
+	if err != nil {
+		return nil, err
+	}
+	return newMasterKey("secret", os.Getenv(name))
+}
+
+func (k *masterKey) KeyID() string {
+	return k.id
+}
+
+func (k *masterKey) WrapKey(dataKey []byte) ([]byte, error) {
+	return seal(k.key, dataKey, []byte(k.id))
+}
+
+func (k *masterKey) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	if keyID != k.id {
+		return nil, fmt.Errorf("unknown master key %q", keyID)
+	}
+	return unseal(k.key, wrapped, []byte(k.id))
+}
+
+// Encrypt encrypts the payload fields at Paths with AES-256-GCM. Each record
+// gets its own data key, which is wrapped with the master key from Keys and
+// stored in the record with the master key ID. Encrypted fields become base64
+// strings; their original schema type is kept in the schema field parameters
+// so Decrypt can restore it.
+type Encrypt struct {
+	Paths []string
+	Keys  KeyProvider
+}
+
+func (f Encrypt) Process(rr []turbine.Record) []turbine.Record {
+	out := rr[:0]
+	for _, r := range rr {
+		p, err := f.encrypt(r.Payload)
+		if err != nil {
+			log.Printf("error encrypting record %s: %s", r.Key, err)
+			continue
+		}
+		r.Payload = p
+		out = append(out, r)
+	}
+	return out
+}
+
+func (f Encrypt) encrypt(p []byte) ([]byte, error) {
+	dataKey := make([]byte, 32)
+	_, err := rand.Read(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := f.Keys.WrapKey(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	keyID := f.Keys.KeyID()
+
+	for _, path := range f.Paths {
+		res := gjson.GetBytes(p, "payload."+path)
+		if !res.Exists() || res.Type == gjson.Null {
+			continue
+		}
+
+		ct, err := seal(dataKey, []byte(res.Raw), fieldAAD(keyID, path))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		p, err = sjson.SetBytes(p, "payload."+path, base64.StdEncoding.EncodeToString(ct))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		p, err = markEncrypted(p, path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	p, err = setStringField(p, EncryptionKeyIDField, keyID)
+	if err != nil {
+		return nil, err
+	}
+	return setStringField(p, EncryptionDataKeyField, base64.StdEncoding.EncodeToString(wrapped))
+}
+
+// Decrypt reverses Encrypt for the payload fields at Paths, using Keys to
+// unwrap each record's data key. The encryption fields are removed afterwards.
+type Decrypt struct {
+	Paths []string
+	Keys  KeyProvider
+}
+
+func (f Decrypt) Process(rr []turbine.Record) []turbine.Record {
+	out := rr[:0]
+	for _, r := range rr {
+		p, err := f.decrypt(r.Payload)
+		if err != nil {
+			log.Printf("error decrypting record %s: %s", r.Key, err)
+			continue
+		}
+		r.Payload = p
+		out = append(out, r)
+	}
+	return out
+}
+
+func (f Decrypt) decrypt(p []byte) ([]byte, error) {
+	keyID := gjson.GetBytes(p, "payload."+EncryptionKeyIDField).String()
+	wrapped, err := base64.StdEncoding.DecodeString(gjson.GetBytes(p, "payload."+EncryptionDataKeyField).String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid data key: %w", err)
+	}
+	dataKey, err := f.Keys.UnwrapKey(keyID, wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range f.Paths {
+		res := gjson.GetBytes(p, "payload."+path)
+		if res.Type != gjson.String {
+			continue
+		}
+
+		ct, err := base64.StdEncoding.DecodeString(res.Str)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		raw, err := unseal(dataKey, ct, fieldAAD(keyID, path))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		p, err = sjson.SetRawBytes(p, "payload."+path, raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		p, err = unmarkEncrypted(p, path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	for _, field := range []string{EncryptionKeyIDField, EncryptionDataKeyField} {
+		p, err = sjson.DeleteBytes(p, "payload."+field)
+		if err != nil {
+			return nil, err
+		}
+		p, err = deleteSchemaField(p, field)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// fieldAAD binds a ciphertext to the field and master key it was made for.
+func fieldAAD(keyID, path string) []byte {
+	return []byte(keyID + "|" + path)
+}
+
+// markEncrypted retypes the schema field for path to string, keeping its
+// original type, logical type name and version in the field parameters. The
+// original parameters and default, which don't fit the string, are kept there
+// as raw JSON.
+func markEncrypted(p []byte, path string) ([]byte, error) {
+	schemaPath, ok := schemaFieldPath(p, path)
+	if !ok {
+		return p, nil
+	}
+	field := gjson.GetBytes(p, schemaPath)
+
+	params := map[string]string{"encrypted": "true"}
+	for _, attr := range []string{"type", "name", "version"} {
+		if v := field.Get(attr); v.Exists() {
+			params["encrypted."+attr] = v.String()
+		}
+	}
+	for _, attr := range []string{"parameters", "default"} {
+		if v := field.Get(attr); v.Exists() {
+			params["encrypted."+attr] = v.Raw
+		}
+	}
+
+	p, err := setSchemaString(p, path)
+	if err != nil {
+		return nil, err
+	}
+	return sjson.SetBytes(p, schemaPath+".parameters", params)
+}
+
+// unmarkEncrypted restores the schema field changed by markEncrypted.
+func unmarkEncrypted(p []byte, path string) ([]byte, error) {
+	schemaPath, ok := schemaFieldPath(p, path)
+	if !ok {
+		return p, nil
+	}
+	params := gjson.GetBytes(p, schemaPath+".parameters")
+	if params.Get("encrypted").String() != "true" {
+		return p, nil
+	}
+
+	p, err := sjson.DeleteBytes(p, schemaPath+".parameters")
+	if err != nil {
+		return nil, err
+	}
+	for _, attr := range []string{"type", "name", "version", "parameters", "default"} {
+		v := params.Get(`encrypted\.` + attr)
+		if !v.Exists() {
+			continue
+		}
+		switch attr {
+		case "version":
+			p, err = sjson.SetBytes(p, schemaPath+"."+attr, v.Int())
+		case "parameters", "default":
+			p, err = sjson.SetRawBytes(p, schemaPath+"."+attr, []byte(v.String()))
+		default:
+			p, err = sjson.SetBytes(p, schemaPath+"."+attr, v.String())
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// seal encrypts plaintext with AES-256-GCM, returning the nonce followed by
+// the ciphertext.
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func unseal(key, sealed, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ct := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ct, aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/sjson"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	keys, err := NewFileKeyProvider(writeTestKey(t))
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+
+	rr := readFixtureRecords(t, "fixtures/pg.json", "user_activity")
+	for i := range rr {
+		rr[i].Payload = withDecimalField(t, rr[i].Payload, "amount", "AJY=")
+	}
+	original := string(rr[0].Payload)
+
+	paths := []string{"email", "created_at", "deleted_at", "amount"}
+	out := Encrypt{Paths: paths, Keys: keys}.Process(rr)
+	if len(out) != 3 {
+		t.Fatalf("want 3 records, got %d", len(out))
+	}
+
+	p := out[0].Payload
+	email, _ := p.Get("email").(string)
+	if email == "" || strings.Contains(email, "example.com") {
+		t.Fatalf("want email to be encrypted, got %q", email)
+	}
+	if out[1].Payload.Get("email") == email {
+		t.Fatal("want a different ciphertext for every record")
+	}
+	if p.Get("deleted_at") != nil {
+		t.Fatalf("want null fields to stay null, got %v", p.Get("deleted_at"))
+	}
+	if got := p.Get(EncryptionKeyIDField); got != keys.KeyID() || !strings.HasPrefix(keys.KeyID(), "file:") {
+		t.Fatalf("want key ID %s to be recorded, got %v", keys.KeyID(), got)
+	}
+	created := schemaFieldByName(p, "created_at")
+	if created.Get("type").String() != "string" || created.Get("name").Exists() || created.Get(`parameters.encrypted\.type`).String() != "int64" {
+		t.Fatalf("want created_at to be marked as an encrypted string, got %s", created.Raw)
+	}
+	amount := schemaFieldByName(p, "amount")
+	if amount.Get("type").String() != "string" || amount.Get("default").Exists() || amount.Get("parameters.scale").Exists() {
+		t.Fatalf("want amount to be marked as an encrypted string, got %s", amount.Raw)
+	}
+	if f := schemaFieldByName(p, EncryptionDataKeyField); f.Get("type").String() != "string" {
+		t.Fatalf("want %s in the schema, got %s", EncryptionDataKeyField, p)
+	}
+
+	out = Decrypt{Paths: paths, Keys: keys}.Process(out)
+	if len(out) != 3 {
+		t.Fatalf("want 3 records, got %d", len(out))
+	}
+	if !jsonEqual(t, original, string(out[0].Payload)) {
+		t.Fatalf("want decrypting to restore the record\nwant: %s\n got: %s", original, out[0].Payload)
+	}
+}
+
+func TestDecrypt_WrongKey(t *testing.T) {
+	keys, _ := NewFileKeyProvider(writeTestKey(t))
+	other, _ := NewFileKeyProvider(writeTestKey(t))
+
+	rr := readFixtureRecords(t, "fixtures/pg.json", "user_activity")
+	out := Encrypt{Paths: []string{"email"}, Keys: keys}.Process(rr)
+	out = Decrypt{Paths: []string{"email"}, Keys: other}.Process(out)
+	if len(out) != 0 {
+		t.Fatalf("want records to be dropped, got %d", len(out))
+	}
+}
+
+func TestDecrypt_SwappedField(t *testing.T) {
+	keys, _ := NewFileKeyProvider(writeTestKey(t))
+
+	rr := readFixtureRecords(t, "fixtures/pg.json", "user_activity")
+	out := Encrypt{Paths: []string{"email", "activity"}, Keys: keys}.Process(rr[:1])
+
+	// ciphertexts are bound to their field
+	p, _ := sjson.SetBytes(out[0].Payload, "payload.activity", out[0].Payload.Get("email"))
+	out[0].Payload = p
+	out = Decrypt{Paths: []string{"email", "activity"}, Keys: keys}.Process(out)
+	if len(out) != 0 {
+		t.Fatalf("want record to be dropped, got %d", len(out))
+	}
+}
+
+func TestNewSecretKeyProvider(t *testing.T) {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	t.Setenv("ARCHIVE_MASTER_KEY", base64.StdEncoding.EncodeToString(key))
+
+	keys, err := NewSecretKeyProvider(testTurbine{secrets: []string{"ARCHIVE_MASTER_KEY"}}, "ARCHIVE_MASTER_KEY")
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	if !strings.HasPrefix(keys.KeyID(), "secret:") {
+		t.Fatalf("want a secret key ID, got %s", keys.KeyID())
+	}
+
+	if _, err := NewSecretKeyProvider(testTurbine{}, "ARCHIVE_MASTER_KEY"); err == nil {
+		t.Fatal("want error when the secret isn't registered, got nil")
+	}
+
+	t.Setenv("SHORT_KEY", base64.StdEncoding.EncodeToString([]byte("short")))
+	if _, err := NewSecretKeyProvider(testTurbine{secrets: []string{"SHORT_KEY"}}, "SHORT_KEY"); err == nil {
+		t.Fatal("want error for a short key, got nil")
+	}
+}
+
+// withDecimalField adds a Decimal field with scale 2 and a default to p.
+func withDecimalField(t *testing.T, p []byte, name, value string) []byte {
+	p, err := sjson.SetRawBytes(p, "schema.fields.-1", []byte(`{"field":"`+name+`","optional":false,"type":"bytes",`+
+		`"name":"org.apache.kafka.connect.data.Decimal","version":1,"parameters":{"scale":"2"},"default":"AA=="}`))
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	p, err = sjson.SetBytes(p, "payload."+name, value)
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	return p
+}
+
+func writeTestKey(t *testing.T) string {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	path := filepath.Join(t.TempDir(), "master.key")
+	err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0o600)
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	return path
+}
+
+func jsonEqual(t *testing.T, a, b string) bool {
+	var va, vb interface{}
+	if err := json.Unmarshal([]byte(a), &va); err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	if err := json.Unmarshal([]byte(b), &vb); err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	return reflect.DeepEqual(va, vb)
+}
@@ -2,7 +2,11 @@ module meroxa/turbine-go-examples/simple
 
 go 1.19
 
-require github.com/meroxa/turbine-go v0.0.0-20220914174030-f35bdc304176
+require (
+	github.com/meroxa/turbine-go v0.0.0-20220914174030-f35bdc304176
+	github.com/tidwall/gjson v1.14.3
+	github.com/tidwall/sjson v1.2.5
+)
 
 require (
 	github.com/caarlos0/env/v6 v6.10.1 // indirect
@@ -13,10 +17,8 @@ require (
 	github.com/google/uuid v1.3.0 // indirect
 	github.com/meroxa/meroxa-go v0.0.0-20220915173905-789eb4683302 // indirect
 	github.com/oklog/run v1.1.1-0.20200508094559-c7096881717e // indirect
-	github.com/tidwall/gjson v1.14.3 // indirect
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.0 // indirect
-	github.com/tidwall/sjson v1.2.5 // indirect
 	github.com/volatiletech/inflect v0.0.1 // indirect
 	github.com/volatiletech/null/v8 v8.1.2 // indirect
 	github.com/volatiletech/randomize v0.0.1 // indirect
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/meroxa/turbine-go"
+)
+
+// KeyVersion is an HMAC key and the version it is known by.
+type KeyVersion struct {
+	Version string
+	Key     []byte
+}
+
+// ParseKeyVersion parses a key formatted as "<version>:<key>", e.g. "v2:s3cr3t".
+func ParseKeyVersion(s string) (KeyVersion, error) {
+	version, key, ok := strings.Cut(s, ":")
+	if !ok || version == "" || key == "" {
+		return KeyVersion{}, fmt.Errorf("key must be formatted as <version>:<key>")
+	}
+	return KeyVersion{Version: version, Key: []byte(key)}, nil
+}
+
+// Pseudonymizer hashes values with HMAC-SHA256 and prefixes the result with
+// the key version, e.g. "v2:5d41...", so hashes made with different keys are
+// never confused and keys can be rotated.
+//
+// While a key is being rotated, Previous holds the old key. Values are then
+// hashed with both keys so consumers can keep joining on the old hashes until
+// they have migrated.
+type Pseudonymizer struct {
+	Current  KeyVersion
+	Previous *KeyVersion
+}
+
+// Hash returns the versioned hash of s with the current key.
+func (p Pseudonymizer) Hash(s string) string {
+	return hashWith(p.Current, s)
+}
+
+// HashPrevious returns the versioned hash of s with the previous key, if a
+// rotation is in progress.
+func (p Pseudonymizer) HashPrevious(s string) (string, bool) {
+	if p.Previous == nil {
+		return "", false
+	}
+	return hashWith(*p.Previous, s), true
+}
+
+func hashWith(k KeyVersion, s string) string {
+	mac := hmac.New(sha256.New, k.Key)
+	mac.Write([]byte(s))
+	return k.Version + ":" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// pseudonymizerFromSecrets registers ANONYMIZE_KEY and, when set,
+// ANONYMIZE_KEY_PREVIOUS as secrets and builds a Pseudonymizer from them.
+// Setting ANONYMIZE_KEY_PREVIOUS turns on migration mode.
+func pseudonymizerFromSecrets(v turbine.Turbine) (Pseudonymizer, error) {
+	err := v.RegisterSecret("ANONYMIZE_KEY") // makes env var available to data app
+	if err != nil {
+		return Pseudonymizer{}, err
+	}
+	current, err := ParseKeyVersion(os.Getenv("ANONYMIZE_KEY"))
+	if err != nil {
+		return Pseudonymizer{}, fmt.Errorf("ANONYMIZE_KEY: %w", err)
+	}
+	p := Pseudonymizer{Current: current}
+
+	if os.Getenv("ANONYMIZE_KEY_PREVIOUS") == "" {
+		return p, nil
+	}
+	err = v.RegisterSecret("ANONYMIZE_KEY_PREVIOUS")
+	if err != nil {
+		return Pseudonymizer{}, err
+	}
+	previous, err := ParseKeyVersion(os.Getenv("ANONYMIZE_KEY_PREVIOUS"))
+	if err != nil {
+		return Pseudonymizer{}, fmt.Errorf("ANONYMIZE_KEY_PREVIOUS: %w", err)
+	}
+	if previous.Version == current.Version {
+		return Pseudonymizer{}, fmt.Errorf("ANONYMIZE_KEY_PREVIOUS must have a different version than ANONYMIZE_KEY")
+	}
+	p.Previous = &previous
+	return p, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/meroxa/turbine-go"
+)
+
+func TestParseKeyVersion(t *testing.T) {
+	k, err := ParseKeyVersion("v2:s3cr3t:with:colons")
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	if k.Version != "v2" || string(k.Key) != "s3cr3t:with:colons" {
+		t.Fatalf("want version v2 and key s3cr3t:with:colons, got %s and %s", k.Version, k.Key)
+	}
+
+	for _, s := range []string{"", "s3cr3t", ":s3cr3t", "v2:"} {
+		if _, err := ParseKeyVersion(s); err == nil {
+			t.Fatalf("want error parsing %q, got nil", s)
+		}
+	}
+}
+
+func TestPseudonymizer_Hash(t *testing.T) {
+	v1 := KeyVersion{Version: "v1", Key: []byte("old")}
+	v2 := KeyVersion{Version: "v2", Key: []byte("new")}
+
+	p := Pseudonymizer{Current: v1}
+	h := p.Hash("user8@example.com")
+	if !strings.HasPrefix(h, "v1:") || h != "v1:"+hmacHex(v1.Key, "user8@example.com") {
+		t.Fatalf("want v1 prefixed HMAC, got %s", h)
+	}
+	if p.Hash("user8@example.com") != h {
+		t.Fatal("want hashes to be consistent")
+	}
+	if _, ok := p.HashPrevious("user8@example.com"); ok {
+		t.Fatal("want no previous hash outside of a rotation")
+	}
+
+	rotating := Pseudonymizer{Current: v2, Previous: &v1}
+	if got := rotating.Hash("user8@example.com"); got == h || !strings.HasPrefix(got, "v2:") {
+		t.Fatalf("want a v2 hash, got %s", got)
+	}
+	if got, ok := rotating.HashPrevious("user8@example.com"); !ok || got != h {
+		t.Fatalf("want previous hash to match the v1 hash %s, got %s", h, got)
+	}
+}
+
+func TestAnonymize_PseudonymRotation(t *testing.T) {
+	v1 := KeyVersion{Version: "v1", Key: []byte("old")}
+	v2 := KeyVersion{Version: "v2", Key: []byte("new")}
+	p := Pseudonymizer{Current: v2, Previous: &v1}
+
+	rr := readFixtureRecords(t, "fixtures/pg.json", "user_activity")
+	nested := turbine.Record{
+		Key: "4",
+		Payload: []byte(`{"schema":{"type":"struct","fields":[{"field":"user","type":"struct","fields":[{"field":"email","type":"string"}]}]},` +
+			`"payload":{"user":{"email":"user8@example.com"}}}`),
+	}
+
+	out := NewAnonymize(Pseudonym("email", p)).Process(rr[:1])
+	out = append(out, NewAnonymize(Pseudonym("user.email", p)).Process([]turbine.Record{nested})...)
+
+	for _, tt := range []struct {
+		r     turbine.Record
+		field string
+	}{{out[0], "email"}, {out[1], "user.email"}} {
+		if got := tt.r.Payload.Get(tt.field); got != p.Hash("user8@example.com") {
+			t.Fatalf("want %s to be the v2 hash, got %v", tt.field, got)
+		}
+		if got := tt.r.Payload.Get(tt.field + PreviousSuffix); got != (Pseudonymizer{Current: v1}).Hash("user8@example.com") {
+			t.Fatalf("want %s to be the v1 hash, got %v", tt.field+PreviousSuffix, got)
+		}
+	}
+
+	if f := schemaFieldByName(out[0].Payload, "email"+PreviousSuffix); f.Get("type").String() != "string" {
+		t.Fatalf("want email%s to be added to the schema, got %s", PreviousSuffix, out[0].Payload)
+	}
+	if got := out[1].Payload; !strings.Contains(string(got), `{"field":"email_previous","optional":true,"type":"string"}`) {
+		t.Fatalf("want user.email%s to be added to the nested schema, got %s", PreviousSuffix, got)
+	}
+}
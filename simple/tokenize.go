@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/meroxa/turbine-go"
+	"github.com/tidwall/gjson"
+)
+
+// ErrUnknownToken is returned by a Vault for tokens it didn't issue.
+var ErrUnknownToken = errors.New("unknown token")
+
+// Vault stores the mapping between values and the random tokens replacing them.
+type Vault interface {
+	// Token returns the token for value, issuing a new one the first time a
+	// value is seen so a repeated value always gets the same token.
+	Token(value string) (string, error)
+	// Value returns the value token stands for.
+	Value(token string) (string, error)
+}
+
+// FileVault is a Vault kept in memory and persisted to an append-only file of
+// JSON lines, one per issued token. It's meant for local runs and tests: on
+// the platform every replica would issue its own tokens. Values are encrypted
+// in the file with the master key of a KeyProvider, so the file alone doesn't
+// give away the PII it stands for.
+type FileVault struct {
+	mu     sync.Mutex
+	file   *os.File
+	keys   KeyProvider
+	tokens map[string]string // token to value
+	values map[string]string // value to token
+}
+
+type vaultEntry struct {
+	Token string `json:"token"`
+	KeyID string `json:"key_id"`
+	Value []byte `json:"value"` // encrypted with the master key KeyID
+}
+
+var _ Vault = (*FileVault)(nil)
+
+// OpenFileVault opens the vault at path, creating it if needed. Values are
+// encrypted with, and decrypted by, keys.
+func OpenFileVault(path string, keys KeyProvider) (*FileVault, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &FileVault{
+		file:   f,
+		keys:   keys,
+		tokens: make(map[string]string),
+		values: make(map[string]string),
+	}
+
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		var e vaultEntry
+		err := json.Unmarshal(s.Bytes(), &e)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("unable to read vault %s: %w", path, err)
+		}
+		value, err := keys.UnwrapKey(e.KeyID, e.Value)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("unable to decrypt token %s in vault %s: %w", e.Token, path, err)
+		}
+		v.tokens[e.Token] = string(value)
+		v.values[string(value)] = e.Token
+	}
+	if err := s.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return v, nil
+}
+
+func (v *FileVault) Token(value string) (string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if token, ok := v.values[value]; ok {
+		return token, nil
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := v.keys.WrapKey([]byte(value))
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(vaultEntry{Token: token, KeyID: v.keys.KeyID(), Value: sealed})
+	if err != nil {
+		return "", err
+	}
+	_, err = v.file.Write(append(b, '\n'))
+	if err != nil {
+		return "", err
+	}
+
+	v.tokens[token] = value
+	v.values[value] = token
+	return token, nil
+}
+
+func (v *FileVault) Value(token string) (string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	value, ok := v.tokens[token]
+	if !ok {
+		return "", ErrUnknownToken
+	}
+	return value, nil
+}
+
+func (v *FileVault) Close() error {
+	return v.file.Close()
+}
+
+// newToken returns a random token. Tokens carry no information about the value.
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return "tok_" + hex.EncodeToString(b), nil
+}
+
+// DetokenizeSecret is the secret that must be registered to detokenize records.
+const DetokenizeSecret = "DETOKENIZE_SECRET"
+
+// Detokenize replaces the tokens in Paths with the values they stand for.
+// It must be built with NewDetokenize: a Detokenize that wasn't leaves records
+// untouched.
+type Detokenize struct {
+	Paths   []string
+	vault   Vault
+	allowed bool
+}
+
+// NewDetokenize returns a Detokenize for paths, as long as DetokenizeSecret can
+// be registered with v. Its value isn't used: the secret only guards against
+// detokenizing by accident in apps that weren't explicitly given it. What
+// protects the values is the vault, such as the master key of a FileVault.
+func NewDetokenize(v turbine.Turbine, vault Vault, paths ...string) (Detokenize, error) {
+	err := v.RegisterSecret(DetokenizeSecret)
+	if err != nil {
+		return Detokenize{}, fmt.Errorf("detokenizing requires the %s secret: %w", DetokenizeSecret, err)
+	}
+	return Detokenize{Paths: paths, vault: vault, allowed: true}, nil
+}
+
+func (f Detokenize) Process(rr []turbine.Record) []turbine.Record {
+	if !f.allowed {
+		log.Printf("detokenizing is not allowed without the %s secret", DetokenizeSecret)
+		return rr
+	}
+
+	for i, r := range rr {
+		for _, path := range f.Paths {
+			token := gjson.GetBytes(r.Payload, "payload."+path)
+			if token.Type != gjson.String {
+				continue
+			}
+			value, err := f.vault.Value(token.Str)
+			if err != nil {
+				log.Printf("error detokenizing %s of record %s: %s", path, r.Key, err)
+				continue
+			}
+			p, err := setStringField(r.Payload, path, value)
+			if err != nil {
+				log.Printf("error detokenizing %s of record %s: %s", path, r.Key, err)
+				continue
+			}
+			r.Payload = p
+		}
+		rr[i] = r
+	}
+	return rr
+}
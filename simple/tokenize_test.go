@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/meroxa/turbine-go"
+)
+
+func TestFileVault(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vault.jsonl")
+	keys := testKeys(t)
+	v, err := OpenFileVault(path, keys)
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+
+	token, err := v.Token("user8@example.com")
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	if !strings.HasPrefix(token, "tok_") || strings.Contains(token, "user8") {
+		t.Fatalf("want a random token, got %s", token)
+	}
+	if again, _ := v.Token("user8@example.com"); again != token {
+		t.Fatalf("want the same token for a repeated value, got %s and %s", token, again)
+	}
+	if other, _ := v.Token("user9@example.com"); other == token {
+		t.Fatal("want different tokens for different values")
+	}
+	if _, err := v.Value("tok_unknown"); !errors.Is(err, ErrUnknownToken) {
+		t.Fatalf("want ErrUnknownToken, got %v", err)
+	}
+	v.Close()
+
+	reopened, err := OpenFileVault(path, keys)
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	defer reopened.Close()
+	if value, err := reopened.Value(token); err != nil || value != "user8@example.com" {
+		t.Fatalf("want token to survive reopening the vault, got %q (%v)", value, err)
+	}
+	if again, _ := reopened.Token("user8@example.com"); again != token {
+		t.Fatalf("want the same token after reopening, got %s and %s", token, again)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	if strings.Contains(string(b), "user8") {
+		t.Fatalf("want values to be encrypted in the vault file, got %s", b)
+	}
+	if _, err := OpenFileVault(path, testKeys(t)); err == nil {
+		t.Fatal("want error opening the vault with another key, got nil")
+	}
+}
+
+func TestTokenizeDetokenize(t *testing.T) {
+	v, err := OpenFileVault(filepath.Join(t.TempDir(), "vault.jsonl"), testKeys(t))
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	defer v.Close()
+
+	rr := readFixtureRecords(t, "fixtures/pg.json", "user_activity")
+	out := NewAnonymize(Tokenize("email", v), Tokenize("user_id", v)).Process(rr)
+	if len(out) != 3 {
+		t.Fatalf("want 3 records, got %d", len(out))
+	}
+
+	token := out[0].Payload.Get("email")
+	for _, r := range out {
+		if got := r.Payload.Get("email"); got != token || !strings.HasPrefix(got.(string), "tok_") {
+			t.Fatalf("want every record to get the same email token, got %v and %v", token, got)
+		}
+	}
+	if f := schemaFieldByName(out[0].Payload, "user_id"); f.Get("type").String() != "string" {
+		t.Fatalf("want user_id to be retyped to string, got %s", f.Raw)
+	}
+
+	detokenize, err := NewDetokenize(testTurbine{secrets: []string{DetokenizeSecret}}, v, "email", "user_id")
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	out = detokenize.Process(out)
+	for _, r := range out {
+		if got := r.Payload.Get("email"); got != "user8@example.com" {
+			t.Fatalf("want email to be detokenized, got %v", got)
+		}
+		if got := r.Payload.Get("user_id"); got != "108" {
+			t.Fatalf("want user_id to be detokenized, got %v", got)
+		}
+	}
+}
+
+func TestDetokenize_RequiresSecret(t *testing.T) {
+	v, err := OpenFileVault(filepath.Join(t.TempDir(), "vault.jsonl"), testKeys(t))
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	defer v.Close()
+
+	if _, err := NewDetokenize(testTurbine{}, v, "email"); err == nil {
+		t.Fatal("want error without the detokenize secret, got nil")
+	}
+
+	rr := NewAnonymize(Tokenize("email", v)).Process(readFixtureRecords(t, "fixtures/pg.json", "user_activity"))
+	token := rr[0].Payload.Get("email")
+	out := Detokenize{Paths: []string{"email"}, vault: v}.Process(rr)
+	if got := out[0].Payload.Get("email"); got != token {
+		t.Fatalf("want email to stay tokenized, got %v", got)
+	}
+}
+
+func testKeys(t *testing.T) KeyProvider {
+	keys, err := NewFileKeyProvider(writeTestKey(t))
+	if err != nil {
+		t.Fatalf("want no error, got %s", err)
+	}
+	return keys
+}
+
+// testTurbine is a turbine.Turbine where only the given secrets are set.
+type testTurbine struct {
+	secrets []string
+}
+
+func (t testTurbine) Resources(string) (turbine.Resource, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (t testTurbine) Process(rr turbine.Records, _ turbine.Function) turbine.Records {
+	return rr
+}
+
+func (t testTurbine) RegisterSecret(name string) error {
+	for _, s := range t.secrets {
+		if s == name {
+			return nil
+		}
+	}
+	return errors.New("secret is invalid or not set")
+}